@@ -0,0 +1,87 @@
+// Shared helpers of the builder pages. Every value coming from the server
+// is inserted with textContent or attributes, never as HTML.
+
+function builder_project_url (theProjectId, theVerb) {
+	var myURL = '/' + encodeURIComponent(theProjectId);
+	if (theVerb) {
+		myURL += '/' + theVerb;
+	}
+	return myURL;
+}
+
+function builder_fetch_json (theURL) {
+	return fetch(theURL)
+		.then(response => {
+			if (!response.ok) {
+				throw new Error('HTTP Err : ' + response.status);
+			}
+			return response.json();
+		});
+}
+
+// Actions change the builder state, they are POST requests carrying the CSRF token of the page.
+function builder_post (theURL) {
+	const myCSRFMeta = document.querySelector('meta[name="csrf-token"]');
+	return fetch(theURL, {
+		method: 'POST',
+		headers: {'X-CSRF-Token': myCSRFMeta ? myCSRFMeta.content : ''},
+	})
+		.then(response => {
+			if (!response.ok) {
+				throw new Error('HTTP Err : ' + response.status);
+			}
+			return response;
+		});
+}
+
+// Links marked with data-post trigger their action with a POST, then the
+// "builder-posted" event lets the page refresh itself.
+document.addEventListener('click', function (theEvent) {
+	const myPostLink = theEvent.target.closest('a[data-post]');
+	if (myPostLink == null) {
+		return;
+	}
+	theEvent.preventDefault();
+	builder_post(myPostLink.getAttribute('href'))
+		.catch(error => {
+			console.error('Post Err :', error);
+		})
+		.finally(() => {
+			document.dispatchEvent(new Event('builder-posted'));
+		});
+});
+
+function builder_set_text (theElement, theText) {
+	theElement.textContent = (theText == null) ? '' : theText;
+}
+
+// theState : "active", "running", or anything else for disabled
+function builder_render_icon_tool (theContainer, theProjectId, theVerb, theLabel, theState) {
+
+	const myIconDiv = document.createElement('div');
+	const myIconImage = document.createElement('img');
+
+	switch (theState) {
+	case 'running':
+		myIconImage.src = '/assets/project/' + theVerb + '-running.gif';
+		myIconDiv.appendChild(myIconImage);
+		break;
+	case 'active':
+		const myIconLink = document.createElement('a');
+		myIconLink.href = builder_project_url(theProjectId, theVerb);
+		myIconLink.dataset.post = '';
+		myIconImage.src = '/assets/project/' + theVerb + '.svg';
+		myIconLink.appendChild(myIconImage);
+		myIconDiv.appendChild(myIconLink);
+		break;
+	default:
+		myIconImage.src = '/assets/project/' + theVerb + '-disabled.svg';
+		myIconDiv.appendChild(myIconImage);
+	}
+
+	const myLabelDiv = document.createElement('div');
+	myLabelDiv.style.fontSize = '0.8em';
+	myLabelDiv.textContent = theLabel;
+
+	theContainer.replaceChildren(myIconDiv, myLabelDiv);
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kAuditLogFileName = "audit.log"
+const kAuditEntriesShown = 200 // last entries listed on the audit page
+
+type AuditEntry struct {
+    Time time.Time
+    User string // admin user, empty for anonymous requests
+    RemoteAddr string
+    Action string // "build", "up", "down", "deploy", "prefetch", "settings", "new-project", "clear-cache"
+    ProjectId string
+    Details string
+}
+
+var gAuditMutex sync.Mutex
+
+//------------------------------------------------------------------------------
+
+// The audit log is append-only, one JSON entry per line. Its write errors are also logged.
+func builder_audit (theHTTPRequest *http.Request, theUser string, theAction string, theProjectId string, theDetails string) error {
+	myAuditErr := builder_append_audit_entry(theHTTPRequest, theUser, theAction, theProjectId, theDetails)
+	if myAuditErr != nil {
+		gLogger.Error("audit entry not saved", "action", theAction, "project", theProjectId, "error", myAuditErr)
+	}
+	return myAuditErr
+}
+
+// Audits a job requested from the project page, by the admin when the request carries its credentials.
+func builder_audit_job (theHTTPRequest *http.Request, theJob string, theProjectId string, theDetails string, theQueued bool) {
+	if !theQueued {
+		theDetails = strings.TrimSpace(theDetails+" refused, project busy")
+	}
+	builder_audit(theHTTPRequest, builder_get_request_admin_user(theHTTPRequest), theJob, theProjectId, theDetails)
+}
+
+func builder_append_audit_entry (theHTTPRequest *http.Request, theUser string, theAction string, theProjectId string, theDetails string) error {
+
+	myAuditEntry := AuditEntry{Time: time.Now(),
+		User: theUser,
+		RemoteAddr: theHTTPRequest.RemoteAddr,
+		Action: theAction,
+		ProjectId: theProjectId,
+		Details: theDetails,
+	}
+
+	myEntryBytes, myMarshalErr := json.Marshal(myAuditEntry)
+	if myMarshalErr != nil {
+		return myMarshalErr
+	}
+
+	gAuditMutex.Lock()
+	defer gAuditMutex.Unlock()
+
+	myMkdirErr := os.MkdirAll(gDataDirPath, 0755)
+	if myMkdirErr != nil {
+		return myMkdirErr
+	}
+
+	myAuditFile, myOpenErr := os.OpenFile(filepath.Join(gDataDirPath, kAuditLogFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if myOpenErr != nil {
+		return myOpenErr
+	}
+	defer myAuditFile.Close()
+
+	_, myWriteErr := myAuditFile.Write(append(myEntryBytes, '\n'))
+	return myWriteErr
+}
+
+// Returns the last audit entries, of a project or of all when empty, the newest first.
+func builder_load_audit_entries (theProjectId string) ([]AuditEntry, error) {
+
+	var myAuditEntries []AuditEntry
+
+	gAuditMutex.Lock()
+	defer gAuditMutex.Unlock()
+
+	myAuditFile, myOpenErr := os.Open(filepath.Join(gDataDirPath, kAuditLogFileName))
+	if os.IsNotExist(myOpenErr) {
+		return myAuditEntries, nil
+	}
+	if myOpenErr != nil {
+		return myAuditEntries, myOpenErr
+	}
+	defer myAuditFile.Close()
+
+	myScanner := bufio.NewScanner(myAuditFile)
+	myScanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for myScanner.Scan() {
+		var myAuditEntry AuditEntry
+		if json.Unmarshal(myScanner.Bytes(), &myAuditEntry) != nil {
+			continue
+		}
+		if theProjectId != "" && myAuditEntry.ProjectId != theProjectId {
+			continue
+		}
+		myAuditEntries = append(myAuditEntries, myAuditEntry)
+		if len(myAuditEntries) > kAuditEntriesShown {
+			myAuditEntries = myAuditEntries[1:]
+		}
+	}
+
+	for i, j := 0, len(myAuditEntries)-1; i < j; i, j = i+1, j-1 {
+		myAuditEntries[i], myAuditEntries[j] = myAuditEntries[j], myAuditEntries[i]
+	}
+	return myAuditEntries, myScanner.Err()
+}
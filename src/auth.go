@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+const kAdminUserEnvName = "GO_BUILDER_ADMIN_USER"
+const kAdminPasswordEnvName = "GO_BUILDER_ADMIN_PASSWORD"
+
+//------------------------------------------------------------------------------
+
+// Admin pages use HTTP basic auth, they are refused while no admin credentials are configured.
+func builder_check_admin (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) (string, bool) {
+
+	myAdminUser := gServerConfig.AdminUser
+	myAdminPassword := gServerConfig.AdminPassword
+	if myAdminUser == "" || myAdminPassword == "" {
+		http.Error(theHTTPResponse, "Admin access is not configured", http.StatusForbidden)
+		return "", false
+	}
+
+	myRequestUser, myRequestPassword, myBasicAuthOK := theHTTPRequest.BasicAuth()
+	myUserOK := subtle.ConstantTimeCompare([]byte(myRequestUser), []byte(myAdminUser)) == 1
+	myPasswordOK := subtle.ConstantTimeCompare([]byte(myRequestPassword), []byte(myAdminPassword)) == 1
+	if !myBasicAuthOK || !myUserOK || !myPasswordOK {
+		theHTTPResponse.Header().Set("WWW-Authenticate", `Basic realm="go-builder admin", charset="UTF-8"`)
+		http.Error(theHTTPResponse, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	return myRequestUser, true
+}
+
+// Returns the admin user when the request carries the admin credentials, else "", for the logs of the public pages.
+func builder_get_request_admin_user (theHTTPRequest *http.Request) string {
+
+	myRequestUser, myRequestPassword, myBasicAuthOK := theHTTPRequest.BasicAuth()
+	if !myBasicAuthOK || gServerConfig.AdminUser == "" || gServerConfig.AdminPassword == "" {
+		return ""
+	}
+	myUserOK := subtle.ConstantTimeCompare([]byte(myRequestUser), []byte(gServerConfig.AdminUser)) == 1
+	myPasswordOK := subtle.ConstantTimeCompare([]byte(myRequestPassword), []byte(gServerConfig.AdminPassword)) == 1
+	if !myUserOK || !myPasswordOK {
+		return ""
+	}
+	return myRequestUser
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const kBinariesDirName = "binaries"
+const kBinaryPackagesShown = 40 // biggest packages listed on the binary page
+const kBinaryDiffsShown = 20 // biggest package size changes listed on the binary page
+
+// Sizes of a built program, saved after every successful build for the size diff of the next one.
+type BinarySnapshot struct {
+    BuildNumber int
+    Size int64
+    PackageSizes map[string]int64 // symbol bytes by package
+}
+
+//------------------------------------------------------------------------------
+
+// Returns the interpreter (empty for a static program) and the shared libraries of an ELF program.
+func builder_get_elf_link_info (theELFFile *elf.File) (string, []string) {
+	myInterpreter := ""
+	for _, myProgram := range theELFFile.Progs {
+		if myProgram.Type == elf.PT_INTERP {
+			myInterpreterBytes := make([]byte, myProgram.Filesz)
+			myProgram.ReadAt(myInterpreterBytes, 0)
+			myInterpreter = strings.TrimRight(string(myInterpreterBytes), "\x00")
+		}
+	}
+	myLibraries, _ := theELFFile.ImportedLibraries()
+	return myInterpreter, myLibraries
+}
+
+// Package of a symbol : "net/http" for "net/http.(*Client).Do", "(C)" for the C symbols.
+func builder_get_symbol_package (theSymbolName string) string {
+	if strings.HasPrefix(theSymbolName, "type:") || strings.HasPrefix(theSymbolName, "go:") || strings.HasPrefix(theSymbolName, "$") {
+		return "(Go metadata)"
+	}
+	// the type arguments of the generic functions hold other package paths
+	mySymbolName, _, _ := strings.Cut(theSymbolName, "[")
+	mySlashPos := strings.LastIndex(mySymbolName, "/")
+	myDotPos := strings.Index(mySymbolName[mySlashPos+1:], ".")
+	if myDotPos < 0 {
+		return "(C)"
+	}
+	return mySymbolName[:mySlashPos+1+myDotPos]
+}
+
+// Sums the sizes of the function and data symbols by package.
+func builder_get_package_sizes (theELFFile *elf.File) map[string]int64 {
+	myPackageSizes := make(map[string]int64)
+	mySymbols, mySymbolsErr := theELFFile.Symbols()
+	if mySymbolsErr != nil {
+		return myPackageSizes
+	}
+	for _, mySymbol := range mySymbols {
+		mySymbolType := elf.ST_TYPE(mySymbol.Info)
+		if mySymbol.Size == 0 || (mySymbolType != elf.STT_FUNC && mySymbolType != elf.STT_OBJECT) {
+			continue
+		}
+		myPackageSizes[builder_get_symbol_package(mySymbol.Name)] += int64(mySymbol.Size)
+	}
+	return myPackageSizes
+}
+
+func builder_format_size_delta (theDelta int64) string {
+	if theDelta < 0 {
+		return "-"+builder_format_size(-theDelta)
+	}
+	return "+"+builder_format_size(theDelta)
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_binary_snapshot_filepath (theProjectId string, thePrevious bool) string {
+	if thePrevious {
+		return filepath.Join(gDataDirPath, kBinariesDirName, theProjectId+".previous.json")
+	}
+	return filepath.Join(gDataDirPath, kBinariesDirName, theProjectId+".json")
+}
+
+func builder_load_binary_snapshot (theFilePath string) *BinarySnapshot {
+	mySnapshotBytes, myReadErr := os.ReadFile(theFilePath)
+	if myReadErr != nil {
+		return nil
+	}
+	var mySnapshot BinarySnapshot
+	if json.Unmarshal(mySnapshotBytes, &mySnapshot) != nil {
+		return nil
+	}
+	return &mySnapshot
+}
+
+// Saves the sizes of the program of a successful build, the snapshot of the build before becomes the previous one.
+func builder_save_binary_snapshot (theProjectId string, theBuildNumber int) error {
+
+	myTargetFileInfo, myStatErr := os.Stat(builder_get_project_target_filepath(theProjectId))
+	if myStatErr != nil {
+		return myStatErr
+	}
+	mySnapshot := BinarySnapshot{BuildNumber: theBuildNumber, Size: myTargetFileInfo.Size(), PackageSizes: make(map[string]int64)}
+	myELFFile, myOpenErr := elf.Open(builder_get_project_target_filepath(theProjectId))
+	if myOpenErr == nil {
+		mySnapshot.PackageSizes = builder_get_package_sizes(myELFFile)
+		myELFFile.Close()
+	}
+
+	mySnapshotFilePath := builder_get_binary_snapshot_filepath(theProjectId, false)
+	myMkdirErr := os.MkdirAll(filepath.Dir(mySnapshotFilePath), 0755)
+	if myMkdirErr != nil {
+		return myMkdirErr
+	}
+	myLastSnapshot := builder_load_binary_snapshot(mySnapshotFilePath)
+	if myLastSnapshot != nil && myLastSnapshot.BuildNumber != theBuildNumber {
+		myRenameErr := os.Rename(mySnapshotFilePath, builder_get_binary_snapshot_filepath(theProjectId, true))
+		if myRenameErr != nil {
+			return myRenameErr
+		}
+	}
+	mySnapshotBytes, _ := json.Marshal(mySnapshot)
+	return os.WriteFile(mySnapshotFilePath, mySnapshotBytes, 0644)
+}
+
+//------------------------------------------------------------------------------
+
+// Inspects the built program : size, build info, link, sizes by package, and size diff against the previous build.
+func builder_get_project_binary_info (theProjectId string) map[string]interface{} {
+
+	myTargetFilePath := builder_get_project_target_filepath(theProjectId)
+	myBinaryInfo := map[string]interface{}{
+		"Id": theProjectId,
+		"FilePath": myTargetFilePath,
+		"LastMod": builder_get_project_target_lastmod(theProjectId),
+		"Error": "",
+	}
+
+	myTargetFileInfo, myStatErr := os.Stat(myTargetFilePath)
+	if myStatErr != nil {
+		myBinaryInfo["Error"] = "No program built yet"
+		return myBinaryInfo
+	}
+	mySize := myTargetFileInfo.Size()
+	myBinaryInfo["Size"] = builder_format_size(mySize)
+
+	myBuildInfo, myBuildInfoErr := buildinfo.ReadFile(myTargetFilePath)
+	if myBuildInfoErr != nil {
+		myBinaryInfo["BuildInfoError"] = myBuildInfoErr.Error()
+	} else {
+		myBinaryInfo["GoVersion"] = myBuildInfo.GoVersion
+		myBinaryInfo["Path"] = myBuildInfo.Path
+		myBinaryInfo["Main"] = myBuildInfo.Main
+		myBinaryInfo["Deps"] = myBuildInfo.Deps
+		myBinaryInfo["Settings"] = myBuildInfo.Settings
+	}
+
+	myELFFile, myOpenErr := elf.Open(myTargetFilePath)
+	if myOpenErr != nil {
+		myBinaryInfo["Error"] = fmt.Sprintf("Not an ELF program : %v", myOpenErr)
+		return myBinaryInfo
+	}
+	defer myELFFile.Close()
+
+	myInterpreter, myLibraries := builder_get_elf_link_info(myELFFile)
+	myBinaryInfo["Machine"] = myELFFile.Machine.String()
+	myBinaryInfo["Static"] = myInterpreter == "" && len(myLibraries) == 0
+	myBinaryInfo["Interpreter"] = myInterpreter
+	myBinaryInfo["Libraries"] = myLibraries
+
+	myPackageSizes := builder_get_package_sizes(myELFFile)
+	var myPackageNames []string
+	var mySymbolsSize int64
+	for myPackageName, myPackageSize := range myPackageSizes {
+		myPackageNames = append(myPackageNames, myPackageName)
+		mySymbolsSize += myPackageSize
+	}
+	sort.Slice(myPackageNames, func(i, j int) bool {
+		return myPackageSizes[myPackageNames[i]] > myPackageSizes[myPackageNames[j]]
+	})
+	myPackagesInfo := []map[string]interface{}{}
+	for _, myPackageName := range myPackageNames {
+		if len(myPackagesInfo) == kBinaryPackagesShown {
+			break
+		}
+		myPackagesInfo = append(myPackagesInfo, map[string]interface{}{
+			"Name": myPackageName,
+			"Size": builder_format_size(myPackageSizes[myPackageName]),
+			"Percent": fmt.Sprintf("%.1f", float64(myPackageSizes[myPackageName])*100/float64(max(mySymbolsSize, 1))),
+		})
+	}
+	myBinaryInfo["Packages"] = myPackagesInfo
+	myBinaryInfo["PackagesCount"] = len(myPackageNames)
+	myBinaryInfo["SymbolsSize"] = builder_format_size(mySymbolsSize)
+	if len(myPackageNames) == 0 {
+		myBinaryInfo["SymbolsError"] = "No symbol table, the program was stripped"
+	}
+
+	// the snapshot of the current program is the one of the last build, unless it was built since
+	myPreviousSnapshot := builder_load_binary_snapshot(builder_get_binary_snapshot_filepath(theProjectId, true))
+	myLastSnapshot := builder_load_binary_snapshot(builder_get_binary_snapshot_filepath(theProjectId, false))
+	if myLastSnapshot != nil && myLastSnapshot.Size != mySize {
+		myPreviousSnapshot = myLastSnapshot
+	}
+	if myPreviousSnapshot != nil {
+		myBinaryInfo["PreviousBuild"] = myPreviousSnapshot.BuildNumber
+		myBinaryInfo["PreviousSize"] = builder_format_size(myPreviousSnapshot.Size)
+		myBinaryInfo["SizeDelta"] = builder_format_size_delta(mySize - myPreviousSnapshot.Size)
+
+		myDeltas := make(map[string]int64)
+		for myPackageName, myPackageSize := range myPackageSizes {
+			myDeltas[myPackageName] = myPackageSize - myPreviousSnapshot.PackageSizes[myPackageName]
+		}
+		for myPackageName, myPackageSize := range myPreviousSnapshot.PackageSizes {
+			_, myStillBuilt := myPackageSizes[myPackageName]
+			if !myStillBuilt {
+				myDeltas[myPackageName] = -myPackageSize
+			}
+		}
+		var myChangedNames []string
+		for myPackageName, myDelta := range myDeltas {
+			if myDelta != 0 {
+				myChangedNames = append(myChangedNames, myPackageName)
+			}
+		}
+		sort.Slice(myChangedNames, func(i, j int) bool {
+			return max(myDeltas[myChangedNames[i]], -myDeltas[myChangedNames[i]]) > max(myDeltas[myChangedNames[j]], -myDeltas[myChangedNames[j]])
+		})
+		myDiffsInfo := []map[string]interface{}{}
+		for _, myPackageName := range myChangedNames {
+			if len(myDiffsInfo) == kBinaryDiffsShown {
+				break
+			}
+			myState := ""
+			_, myWasBuilt := myPreviousSnapshot.PackageSizes[myPackageName]
+			_, myIsBuilt := myPackageSizes[myPackageName]
+			if !myWasBuilt {
+				myState = "new"
+			} else if !myIsBuilt {
+				myState = "removed"
+			}
+			myDiffsInfo = append(myDiffsInfo, map[string]interface{}{
+				"Name": myPackageName,
+				"Delta": builder_format_size_delta(myDeltas[myPackageName]),
+				"State": myState,
+			})
+		}
+		myBinaryInfo["PackageDiffs"] = myDiffsInfo
+	}
+
+	return myBinaryInfo
+}
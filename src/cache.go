@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const kGoCacheDirName = "gocache"
+const kGoCacheModeShared = "shared"
+const kGoCacheModeProject = "project"
+const kGoCacheProjectsDirName = "projects"
+
+type GoCache struct {
+    Key string // "shared", or the project id of a per-project cache
+    DirPath string
+    GoCacheDirPath string // GOCACHE
+    GoModCacheDirPath string // GOMODCACHE
+}
+
+var gGoCacheClearing = false // guarded by gProjectsMutex, no job starts meanwhile
+
+//------------------------------------------------------------------------------
+
+func builder_new_go_cache (theKey string, theDirPath string) GoCache {
+	return GoCache{Key: theKey,
+		DirPath: theDirPath,
+		GoCacheDirPath: filepath.Join(theDirPath, "go-build"),
+		GoModCacheDirPath: filepath.Join(theDirPath, "mod"),
+	}
+}
+
+func builder_get_project_go_cache (theProjectId string) GoCache {
+	if builder_get_project(theProjectId).GoCache == kGoCacheModeProject {
+		return builder_new_go_cache(theProjectId, filepath.Join(gServerConfig.GoCacheDir, kGoCacheProjectsDirName, theProjectId))
+	}
+	return builder_new_go_cache(kGoCacheModeShared, filepath.Join(gServerConfig.GoCacheDir, kGoCacheModeShared))
+}
+
+// Environment of the go commands of a project build, so that the caches persist between builds.
+func builder_get_go_cache_env (theProjectId string) []string {
+	myGoCache := builder_get_project_go_cache(theProjectId)
+	return []string{"GOCACHE="+myGoCache.GoCacheDirPath, "GOMODCACHE="+myGoCache.GoModCacheDirPath}
+}
+
+// Lists the shared cache, then the per-project caches found on disk.
+func builder_list_go_caches () []GoCache {
+
+	myGoCaches := []GoCache{builder_new_go_cache(kGoCacheModeShared, filepath.Join(gServerConfig.GoCacheDir, kGoCacheModeShared))}
+
+	myProjectsCacheDirPath := filepath.Join(gServerConfig.GoCacheDir, kGoCacheProjectsDirName)
+	myDirEntries, _ := os.ReadDir(myProjectsCacheDirPath)
+	var myProjectIds []string
+	for _, myDirEntry := range myDirEntries {
+		if myDirEntry.IsDir() {
+			myProjectIds = append(myProjectIds, myDirEntry.Name())
+		}
+	}
+	sort.Strings(myProjectIds)
+	for _, myProjectId := range myProjectIds {
+		myGoCaches = append(myGoCaches, builder_new_go_cache(myProjectId, filepath.Join(myProjectsCacheDirPath, myProjectId)))
+	}
+
+	return myGoCaches
+}
+
+func builder_find_go_cache (theKey string) (GoCache, bool) {
+	for _, myGoCache := range builder_list_go_caches() {
+		if myGoCache.Key == theKey {
+			return myGoCache, true
+		}
+	}
+	return GoCache{}, false
+}
+
+func builder_get_dir_size (theDirPath string) int64 {
+	var mySize int64
+	filepath.WalkDir(theDirPath, func(theFilePath string, theDirEntry fs.DirEntry, theWalkErr error) error {
+		if theWalkErr != nil {
+			return nil
+		}
+		if !theDirEntry.IsDir() {
+			myFileInfo, myInfoErr := theDirEntry.Info()
+			if myInfoErr == nil {
+				mySize += myFileInfo.Size()
+			}
+		}
+		return nil
+	})
+	return mySize
+}
+
+func builder_format_size (theSize int64) string {
+	mySize := float64(theSize)
+	for _, myUnit := range []string{"B", "KB", "MB", "GB"} {
+		if mySize < 1024 {
+			return fmt.Sprintf("%.1f %s", mySize, myUnit)
+		}
+		mySize /= 1024
+	}
+	return fmt.Sprintf("%.1f TB", mySize)
+}
+
+// Empties a cache with go clean, which also handles the read-only files of the module cache.
+// Refused while jobs run, the jobs wait for the end of the clear.
+func builder_clear_go_cache (theGoCache GoCache) ([]string, error) {
+
+	var myReturnLines []string
+
+	gProjectsMutex.Lock()
+	if gGoCacheClearing {
+		gProjectsMutex.Unlock()
+		return myReturnLines, fmt.Errorf("another cache clear in progress, retry once it ends")
+	}
+	if gRunningJobs > 0 {
+		myRunningJobs := gRunningJobs
+		gProjectsMutex.Unlock()
+		return myReturnLines, fmt.Errorf("%d job(s) running, retry once they end", myRunningJobs)
+	}
+	gGoCacheClearing = true
+	gProjectsMutex.Unlock()
+	defer func() {
+		gProjectsMutex.Lock()
+		gGoCacheClearing = false
+		gProjectsMutex.Unlock()
+	}()
+
+	myCleanCommand := builder_new_job_command("go", "clean", "-cache", "-modcache")
+	myCleanCommand.Dir = os.TempDir()
+	myCleanCommand.Env = append(os.Environ(), "GOCACHE="+theGoCache.GoCacheDirPath, "GOMODCACHE="+theGoCache.GoModCacheDirPath, "GOFLAGS=")
+	myCleanOutputBytes, myCleanErr := myCleanCommand.CombinedOutput()
+	myReturnLines = builder_append_output_lines(myReturnLines, myCleanOutputBytes)
+	if myCleanErr != nil {
+		return myReturnLines, fmt.Errorf("go clean failed : %v", myCleanErr)
+	}
+
+	// go clean keeps the emptied dirs, per-project caches disappear from the list
+	if theGoCache.Key != kGoCacheModeShared {
+		myRemoveErr := os.RemoveAll(theGoCache.DirPath)
+		if myRemoveErr != nil {
+			return myReturnLines, myRemoveErr
+		}
+	}
+
+	return myReturnLines, nil
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_go_caches_info () []map[string]interface{} {
+
+	myUsingProjectIds := make(map[string][]string)
+	gProjectsMutex.Lock()
+	for _, myProjectId := range gOrderedProjectIds {
+		myGoCacheKey := kGoCacheModeShared
+		if gProjects[myProjectId].GoCache == kGoCacheModeProject {
+			myGoCacheKey = myProjectId
+		}
+		myUsingProjectIds[myGoCacheKey] = append(myUsingProjectIds[myGoCacheKey], myProjectId)
+	}
+	gProjectsMutex.Unlock()
+
+	myGoCachesInfo := []map[string]interface{}{}
+	for _, myGoCache := range builder_list_go_caches() {
+		myGoCachesInfo = append(myGoCachesInfo, map[string]interface{}{
+			"Key": myGoCache.Key,
+			"DirPath": myGoCache.DirPath,
+			"BuildCacheSize": builder_format_size(builder_get_dir_size(myGoCache.GoCacheDirPath)),
+			"ModCacheSize": builder_format_size(builder_get_dir_size(myGoCache.GoModCacheDirPath)),
+			"Projects": myUsingProjectIds[myGoCache.Key],
+		})
+	}
+	return myGoCachesInfo
+}
+
+func builder_get_status_info (theErrors []string, theOutput string) map[string]interface{} {
+	return map[string]interface{}{
+		"GoCaches": builder_get_go_caches_info(),
+		"Toolchains": builder_get_toolchains_info(),
+		"ToolchainArchivesDir": gServerConfig.ToolchainArchivesDir,
+		"RunningJobs": builder_get_running_jobs_count(),
+		"Workers": gServerConfig.Workers,
+		"Errors": theErrors,
+		"Output": theOutput,
+	}
+}
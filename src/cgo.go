@@ -0,0 +1,202 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const kCgoLinkStatic = "static"
+const kCgoLinkDynamic = "dynamic"
+const kCgoLibcGlibc = "glibc"
+const kCgoLibcMusl = "musl"
+const kDefaultMuslCC = "musl-gcc"
+
+// ELF machine of the programs built for every GOARCH.
+var gGoArchMachines = map[string]elf.Machine{
+	"amd64": elf.EM_X86_64,
+	"386": elf.EM_386,
+	"arm64": elf.EM_AARCH64,
+	"arm": elf.EM_ARM,
+	"riscv64": elf.EM_RISCV,
+	"ppc64le": elf.EM_PPC64,
+	"s390x": elf.EM_S390,
+	"loong64": elf.EM_LOONGARCH,
+	"mips64le": elf.EM_MIPS,
+}
+
+type CgoOptions struct {
+    CC string // C compiler, default : the cross compiler of the target arch, musl-gcc with musl, else the one of go env CC
+    CXX string // C++ compiler
+    CFlags string // CGO_CFLAGS
+    LDFlags string // CGO_LDFLAGS
+    PkgConfigPath string // PKG_CONFIG_PATH, ex : "/opt/sqlite/lib/pkgconfig"
+    Link string // "static"(default) or "dynamic"
+    Libc string // "glibc"(default) or "musl"
+    CrossCC map[string]string // C compiler of every target GOARCH, ex : "arm64" : "aarch64-linux-gnu-gcc"
+}
+
+//------------------------------------------------------------------------------
+
+func builder_load_cgo_options (theSettings map[string]string) CgoOptions {
+
+	myOptions := CgoOptions{Link: kCgoLinkStatic,
+		Libc: kCgoLibcGlibc,
+		CrossCC: make(map[string]string),
+	}
+
+	myOptions.CC = strings.TrimSpace(theSettings["CgoCC"])
+	myOptions.CXX = strings.TrimSpace(theSettings["CgoCXX"])
+	myOptions.CFlags = strings.TrimSpace(theSettings["CgoCFlags"])
+	myOptions.LDFlags = strings.TrimSpace(theSettings["CgoLDFlags"])
+	myOptions.PkgConfigPath = strings.TrimSpace(theSettings["CgoPkgConfigPath"])
+	if strings.TrimSpace(theSettings["CgoLink"]) == kCgoLinkDynamic {
+		myOptions.Link = kCgoLinkDynamic
+	}
+	if strings.TrimSpace(theSettings["CgoLibc"]) == kCgoLibcMusl {
+		myOptions.Libc = kCgoLibcMusl
+	}
+	for _, myCrossCC := range builder_split_setting_list(theSettings["CgoCrossCC"]) {
+		myGoArch, myCC, myHasCC := strings.Cut(myCrossCC, "=")
+		if myHasCC {
+			myOptions.CrossCC[strings.TrimSpace(myGoArch)] = strings.TrimSpace(myCC)
+		}
+	}
+
+	return myOptions
+}
+
+func builder_validate_cgo_settings (theValues map[string]string) []string {
+
+	var myErrors []string
+
+	myCgoLink := strings.TrimSpace(theValues["CgoLink"])
+	if myCgoLink != "" && myCgoLink != kCgoLinkStatic && myCgoLink != kCgoLinkDynamic {
+		myErrors = append(myErrors, "CgoLink : expected "+kCgoLinkStatic+" or "+kCgoLinkDynamic)
+	}
+
+	myCgoLibc := strings.TrimSpace(theValues["CgoLibc"])
+	if myCgoLibc != "" && myCgoLibc != kCgoLibcGlibc && myCgoLibc != kCgoLibcMusl {
+		myErrors = append(myErrors, "CgoLibc : expected "+kCgoLibcGlibc+" or "+kCgoLibcMusl)
+	}
+
+	for _, myCrossCC := range builder_split_setting_list(theValues["CgoCrossCC"]) {
+		myGoArch, myCC, myHasCC := strings.Cut(myCrossCC, "=")
+		_, myKnownArch := gGoArchMachines[strings.TrimSpace(myGoArch)]
+		if !myHasCC || !myKnownArch || strings.TrimSpace(myCC) == "" {
+			myErrors = append(myErrors, "CgoCrossCC : invalid entry \""+myCrossCC+"\", expected <arch>=<compiler>, ex : arm64=aarch64-linux-gnu-gcc")
+		}
+	}
+
+	return myErrors
+}
+
+// Quotes a value for the sh -c build commands.
+func builder_shell_quote (theValue string) string {
+	return "'"+strings.ReplaceAll(theValue, "'", `'\''`)+"'"
+}
+
+// Target arch of the builds, GoArch setting or the arch of the builder.
+func builder_get_project_go_arch (theProject *Project) string {
+	if theProject.GoArch != "" {
+		return theProject.GoArch
+	}
+	return runtime.GOARCH
+}
+
+// Returns the build command of the cgo engine : the C toolchain environment, then go build,
+// statically linked by the external linker unless CgoLink=dynamic.
+func builder_get_cgo_build_command (theProject *Project) string {
+
+	myOptions := theProject.Cgo
+	myGoArch := builder_get_project_go_arch(theProject)
+
+	myCC := myOptions.CC
+	if myCC == "" {
+		myCC = myOptions.CrossCC[myGoArch]
+	}
+	if myCC == "" && myOptions.Libc == kCgoLibcMusl {
+		myCC = kDefaultMuslCC
+	}
+
+	myBuildCommand := "CGO_ENABLED=1 GOOS=linux"
+	if theProject.GoArch != "" {
+		myBuildCommand += " GOARCH="+theProject.GoArch
+	}
+	for _, myEnvVar := range [][2]string{{"CC", myCC}, {"CXX", myOptions.CXX}, {"CGO_CFLAGS", myOptions.CFlags}, {"CGO_LDFLAGS", myOptions.LDFlags}, {"PKG_CONFIG_PATH", myOptions.PkgConfigPath}} {
+		if myEnvVar[1] != "" {
+			myBuildCommand += " "+myEnvVar[0]+"="+builder_shell_quote(myEnvVar[1])
+		}
+	}
+	myBuildCommand += " go build"
+	if myOptions.Link == kCgoLinkStatic {
+		// the pure Go resolvers, glibc can't statically link getaddrinfo and getpwnam
+		myBuildCommand += " -tags osusergo,netgo -ldflags '-linkmode external -extldflags \"-static\"'"
+	}
+	myBuildCommand += " -o "+theProject.TargetFilePath
+
+	return myBuildCommand
+}
+
+//------------------------------------------------------------------------------
+
+// Checks that the program built by the cgo engine is linked as configured : its arch, static (no
+// interpreter, no shared library) or dynamic, and the loader of its libc. The file and ldd outputs
+// are added when these commands exist.
+func builder_check_cgo_program (theProjectId string) ([]string, error) {
+
+	var myReturnLines []string
+
+	myProject := builder_get_project(theProjectId)
+	myOptions := myProject.Cgo
+
+	myELFFile, myOpenErr := elf.Open(myProject.TargetFilePath)
+	if myOpenErr != nil {
+		return myReturnLines, fmt.Errorf("not an ELF program : %v", myOpenErr)
+	}
+	defer myELFFile.Close()
+
+	myInterpreter, myLibraries := builder_get_elf_link_info(myELFFile)
+
+	myLinkText := "static"
+	if myInterpreter != "" {
+		myLinkText = "dynamic, interpreter "+myInterpreter
+	}
+	if len(myLibraries) > 0 {
+		myLinkText += ", libraries "+strings.Join(myLibraries, " ")
+	}
+	myReturnLines = append(myReturnLines, fmt.Sprintf("Link check : %s, %s", myELFFile.Machine, myLinkText))
+
+	_, myFileErr := exec.LookPath("file")
+	if myFileErr == nil {
+		myFileOutputBytes, _ := builder_run_command("", "file", "--brief", myProject.TargetFilePath)
+		myReturnLines = append(myReturnLines, "file : "+strings.TrimSpace(string(myFileOutputBytes)))
+	}
+	// ldd runs the loader, only for the programs of the builder arch
+	_, myLddErr := exec.LookPath("ldd")
+	if myLddErr == nil && myInterpreter != "" && builder_get_project_go_arch(myProject) == runtime.GOARCH {
+		myLddOutputBytes, _ := builder_run_command("", "ldd", myProject.TargetFilePath)
+		myReturnLines = builder_append_output_lines(append(myReturnLines, "ldd :"), myLddOutputBytes)
+	}
+
+	myMachine, myKnownArch := gGoArchMachines[builder_get_project_go_arch(myProject)]
+	if myKnownArch && myELFFile.Machine != myMachine {
+		return myReturnLines, fmt.Errorf("%s program expected, got %s", myMachine, myELFFile.Machine)
+	}
+	if myOptions.Link == kCgoLinkStatic && (myInterpreter != "" || len(myLibraries) > 0) {
+		return myReturnLines, fmt.Errorf("static program expected, got %s", myLinkText)
+	}
+	if myOptions.Link == kCgoLinkDynamic && myInterpreter != "" {
+		myIsMuslLoader := strings.Contains(myInterpreter, "ld-musl")
+		if myOptions.Libc == kCgoLibcMusl && !myIsMuslLoader {
+			return myReturnLines, fmt.Errorf("musl program expected, got interpreter %s", myInterpreter)
+		}
+		if myOptions.Libc == kCgoLibcGlibc && myIsMuslLoader {
+			return myReturnLines, fmt.Errorf("glibc program expected, got interpreter %s", myInterpreter)
+		}
+	}
+
+	return myReturnLines, nil
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const kConfigFileEnvName = "GO_BUILDER_CONFIG"
+const kServerLogFileName = "go-builder.log"
+
+type ConfigEntry struct {
+    Key string // key in the config file
+    FlagName string
+    EnvName string
+    Default string
+    Usage string
+    Secret bool // masked when the config is printed
+    Bool bool // "-flag" alone means true
+}
+
+// Flag value of a config entry, a bool flag for the Bool entries.
+type ConfigFlag struct {
+    Value string
+    Bool bool
+}
+
+func (theConfigFlag *ConfigFlag) String () string {
+	if theConfigFlag == nil {
+		return ""
+	}
+	return theConfigFlag.Value
+}
+
+func (theConfigFlag *ConfigFlag) Set (theValue string) error {
+	theConfigFlag.Value = theValue
+	return nil
+}
+
+func (theConfigFlag *ConfigFlag) IsBoolFlag () bool {
+	return theConfigFlag.Bool
+}
+
+// Server config entries, in display order.
+// Every entry is resolved from, by priority : its flag, its env var, the config file, its default.
+var gConfigEntries = []ConfigEntry{
+	{Key: "ListenAddr", FlagName: "listen", EnvName: "GO_BUILDER_LISTEN_ADDR", Default: "", Usage: "listen address, :80, or :443 with TLS, when empty"},
+	{Key: "TLSCertFile", FlagName: "tls-cert", EnvName: "GO_BUILDER_TLS_CERT", Default: "", Usage: "TLS certificate file, reloaded when it changes"},
+	{Key: "TLSKeyFile", FlagName: "tls-key", EnvName: "GO_BUILDER_TLS_KEY", Default: "", Usage: "TLS key file, reloaded when it changes"},
+	{Key: "TLSAutoCert", FlagName: "tls-auto-cert", EnvName: "GO_BUILDER_TLS_AUTO_CERT", Default: "false", Usage: "serve HTTPS with a self-signed CA generated in the data dir, when no cert file is set", Bool: true},
+	{Key: "TLSHosts", FlagName: "tls-hosts", EnvName: "GO_BUILDER_TLS_HOSTS", Default: "", Usage: "host names and IPs of the generated certificate, the hostname and localhost when empty"},
+	{Key: "HTTPRedirectAddr", FlagName: "http-redirect", EnvName: "GO_BUILDER_HTTP_REDIRECT_ADDR", Default: ":80", Usage: "with TLS, address redirecting plain HTTP to HTTPS, \"off\" to disable"},
+	{Key: "ProjectsRoots", FlagName: "", EnvName: kProjectsRootsEnvName, Default: kDefaultProjectsDirPath, Usage: "projects roots, \"label=/path;other=/other/path\" (see -projects-root)"},
+	{Key: "AutoDiscover", FlagName: "autodiscover", EnvName: kAutoDiscoverEnvName, Default: "false", Usage: "register the dirs holding a go.mod as projects", Bool: true},
+	{Key: "DockerHost", FlagName: "docker-host", EnvName: "GO_BUILDER_DOCKER_HOST", Default: kDefaultDockerHost, Usage: "docker daemon address"},
+	{Key: "Workers", FlagName: "workers", EnvName: "GO_BUILDER_WORKERS", Default: "1", Usage: "number of jobs (builds, compose up/down) run at the same time"},
+	{Key: "PollInterval", FlagName: "poll-interval", EnvName: "GO_BUILDER_POLL_INTERVAL", Default: "1s", Usage: "delay between two checks of the pending jobs"},
+	{Key: "GitCloneTimeout", FlagName: "git-clone-timeout", EnvName: "GO_BUILDER_GIT_CLONE_TIMEOUT", Default: "5m", Usage: "git clone timeout of the new projects"},
+	{Key: "ShutdownTimeout", FlagName: "shutdown-timeout", EnvName: "GO_BUILDER_SHUTDOWN_TIMEOUT", Default: "30s", Usage: "on SIGTERM, delay given to the running jobs before they are cancelled"},
+	{Key: "RequeueInterrupted", FlagName: "requeue-interrupted", EnvName: "GO_BUILDER_REQUEUE_INTERRUPTED", Default: "false", Usage: "queue again at startup the jobs interrupted by the last stop", Bool: true},
+	{Key: "LogDir", FlagName: "log-dir", EnvName: "GO_BUILDER_LOG_DIR", Default: "", Usage: "dir of the server log file, stdout only when empty"},
+	{Key: "LogFormat", FlagName: "log-format", EnvName: "GO_BUILDER_LOG_FORMAT", Default: kLogFormatText, Usage: "format of the server log, text or json"},
+	{Key: "LogLevel", FlagName: "log-level", EnvName: "GO_BUILDER_LOG_LEVEL", Default: "info", Usage: "minimum level of the server log, debug (every request), info, warn or error"},
+	{Key: "GoCacheDir", FlagName: "go-cache-dir", EnvName: "GO_BUILDER_GO_CACHE_DIR", Default: "", Usage: "dir of the Go build and module caches of the builds, <DataDir>/gocache when empty"},
+	{Key: "GoProxy", FlagName: "go-proxy", EnvName: "GO_BUILDER_GO_PROXY", Default: "https://proxy.golang.org,direct", Usage: "GOPROXY of the builds, after the modules already downloaded, \"off\" to build offline"},
+	{Key: "GoProxyListenAddr", FlagName: "go-proxy-listen", EnvName: "GO_BUILDER_GO_PROXY_LISTEN_ADDR", Default: "", Usage: "address serving the downloaded modules on /goproxy/, without auth, not served when empty"},
+	{Key: "ToolchainsDir", FlagName: "toolchains-dir", EnvName: "GO_BUILDER_TOOLCHAINS_DIR", Default: "", Usage: "dir of the installed Go toolchains, <DataDir>/toolchains when empty"},
+	{Key: "ToolchainArchivesDir", FlagName: "toolchain-archives-dir", EnvName: "GO_BUILDER_TOOLCHAIN_ARCHIVES_DIR", Default: "", Usage: "dir of the go<version>.<os>-<arch>.tar.gz archives the toolchains are installed from, <DataDir>/toolchain-archives when empty"},
+	{Key: "VulnDBDir", FlagName: "vulndb-dir", EnvName: "GO_BUILDER_VULNDB_DIR", Default: "", Usage: "dir of the mirrored Go vulnerability database of the scans, <DataDir>/vulndb when empty"},
+	{Key: "BuildCgroupDir", FlagName: "build-cgroup-dir", EnvName: "GO_BUILDER_BUILD_CGROUP_DIR", Default: "", Usage: "cgroup v2 dir of the builds cgroups enforcing LimitCPU and LimitMemory, no cgroup when empty"},
+	{Key: "SMTPAddr", FlagName: "smtp-addr", EnvName: "GO_BUILDER_SMTP_ADDR", Default: "", Usage: "host:port of the SMTP server of the email notifications, no email when empty"},
+	{Key: "SMTPFrom", FlagName: "smtp-from", EnvName: "GO_BUILDER_SMTP_FROM", Default: "go-builder@localhost", Usage: "sender address of the email notifications"},
+	{Key: "SMTPUser", FlagName: "smtp-user", EnvName: "GO_BUILDER_SMTP_USER", Default: "", Usage: "SMTP user, PLAIN auth when set"},
+	{Key: "SMTPPassword", FlagName: "", EnvName: "GO_BUILDER_SMTP_PASSWORD", Default: "", Usage: "SMTP password (env or config file only)", Secret: true},
+	{Key: "DataDir", FlagName: "data-dir", EnvName: "GO_BUILDER_DATA_DIR", Default: kDefaultDataDirPath, Usage: "dir of the build history, audit log, credentials..."},
+	{Key: "AdminUser", FlagName: "admin-user", EnvName: kAdminUserEnvName, Default: "", Usage: "admin user of the settings and new project pages"},
+	{Key: "AdminPassword", FlagName: "", EnvName: kAdminPasswordEnvName, Default: "", Usage: "admin password (env or config file only)", Secret: true},
+	{Key: "DefaultEngine", FlagName: "default-engine", EnvName: "GO_BUILDER_DEFAULT_ENGINE", Default: "go", Usage: "Engine of the projects that don't set it"},
+	{Key: "DefaultSrcDir", FlagName: "default-srcdir", EnvName: "GO_BUILDER_DEFAULT_SRCDIR", Default: "src", Usage: "SrcDir of the projects that don't set it"},
+	{Key: "DefaultBuilderImage", FlagName: "default-builder-image", EnvName: "GO_BUILDER_DEFAULT_BUILDER_IMAGE", Default: kDefaultBuilderImage, Usage: "BuilderImage of the projects that don't set it"},
+	{Key: "DefaultImageTags", FlagName: "default-image-tags", EnvName: "GO_BUILDER_DEFAULT_IMAGE_TAGS", Default: kDefaultImageTags, Usage: "ImageTags of the projects that don't set it"},
+	{Key: "DefaultPushRegistry", FlagName: "default-push-registry", EnvName: "GO_BUILDER_DEFAULT_PUSH_REGISTRY", Default: "", Usage: "PushRegistry of the projects that don't set it"},
+	{Key: "DefaultGoCache", FlagName: "default-go-cache", EnvName: "GO_BUILDER_DEFAULT_GO_CACHE", Default: kGoCacheModeShared, Usage: "GoCache of the projects that don't set it"},
+	{Key: "DefaultKeepImages", FlagName: "default-keep-images", EnvName: "GO_BUILDER_DEFAULT_KEEP_IMAGES", Default: strconv.Itoa(kDefaultKeepImages), Usage: "KeepImages of the projects that don't set it"},
+	{Key: "DefaultLimitCPU", FlagName: "default-limit-cpu", EnvName: "GO_BUILDER_DEFAULT_LIMIT_CPU", Default: "", Usage: "LimitCPU of the projects that don't set it, no limit when empty"},
+	{Key: "DefaultLimitMemory", FlagName: "default-limit-memory", EnvName: "GO_BUILDER_DEFAULT_LIMIT_MEMORY", Default: "", Usage: "LimitMemory of the projects that don't set it, no limit when empty"},
+	{Key: "DefaultLimitTime", FlagName: "default-limit-time", EnvName: "GO_BUILDER_DEFAULT_LIMIT_TIME", Default: "", Usage: "LimitTime of the projects that don't set it, no limit when empty"},
+	{Key: "DefaultLimitOutput", FlagName: "default-limit-output", EnvName: "GO_BUILDER_DEFAULT_LIMIT_OUTPUT", Default: "", Usage: "LimitOutput of the projects that don't set it, no limit when empty"},
+}
+
+type ServerConfig struct {
+    ListenAddr string
+    TLSCertFile string
+    TLSKeyFile string
+    TLSEnabled bool // cert files set, or TLSAutoCert
+    TLSAutoCert bool
+    TLSHosts []string
+    HTTPRedirectAddr string // empty when disabled
+    DockerHost string
+    Workers int
+    PollInterval time.Duration
+    GitCloneTimeout time.Duration
+    ShutdownTimeout time.Duration
+    RequeueInterrupted bool
+    LogDir string
+    GoCacheDir string
+    GoProxy string
+    GoProxyListenAddr string // empty when the modules are not served
+    ToolchainsDir string
+    ToolchainArchivesDir string
+    VulnDBDir string
+    BuildCgroupDir string
+    SMTPAddr string
+    SMTPFrom string
+    SMTPUser string
+    SMTPPassword string
+    AdminUser string
+    AdminPassword string
+    DefaultProjectSettings map[string]string // project settings defaults, ex : "Engine" for "DefaultEngine"
+}
+
+var gServerConfig ServerConfig
+
+// Resolved value and source ("default", "config file", "env", "flag") of every entry.
+var gConfigValues = make(map[string]string)
+var gConfigSources = make(map[string]string)
+
+var gLogWriter io.Writer = os.Stdout
+
+//------------------------------------------------------------------------------
+
+func builder_load_server_config () error {
+
+	myConfigFileFlag := flag.String("config", "", "config file, \"Key=Value\" lines (env "+kConfigFileEnvName+")")
+	var myProjectsRootsFlag ProjectsRootsFlag
+	flag.Var(&myProjectsRootsFlag, "projects-root", "projects root, \"label=/path\" or \"/path\", repeatable")
+	myEntryFlags := make(map[string]*ConfigFlag)
+	for _, myConfigEntry := range gConfigEntries {
+		if myConfigEntry.FlagName != "" {
+			myEntryFlags[myConfigEntry.Key] = &ConfigFlag{Bool: myConfigEntry.Bool}
+			flag.Var(myEntryFlags[myConfigEntry.Key], myConfigEntry.FlagName, fmt.Sprintf("%s (env %s, default \"%s\")", myConfigEntry.Usage, myConfigEntry.EnvName, myConfigEntry.Default))
+		}
+	}
+	flag.Parse()
+
+	mySetFlags := make(map[string]bool)
+	flag.Visit(func(theFlag *flag.Flag) {
+		mySetFlags[theFlag.Name] = true
+	})
+
+	myConfigFilePath := os.Getenv(kConfigFileEnvName)
+	if mySetFlags["config"] {
+		myConfigFilePath = *myConfigFileFlag
+	}
+	myConfigFileValues := make(map[string]string)
+	if myConfigFilePath != "" {
+		_, myStatErr := os.Stat(myConfigFilePath)
+		if myStatErr != nil {
+			return fmt.Errorf("config file : %v", myStatErr)
+		}
+		myConfigFileValues = builder_load_settings_file(myConfigFilePath)
+	}
+
+	for _, myConfigEntry := range gConfigEntries {
+		gConfigValues[myConfigEntry.Key] = myConfigEntry.Default
+		gConfigSources[myConfigEntry.Key] = "default"
+		myFileValue, myFileValueExists := myConfigFileValues[myConfigEntry.Key]
+		if myFileValueExists {
+			gConfigValues[myConfigEntry.Key] = strings.TrimSpace(myFileValue)
+			gConfigSources[myConfigEntry.Key] = "config file"
+		}
+		myEnvValue, myEnvValueExists := os.LookupEnv(myConfigEntry.EnvName)
+		if myEnvValueExists {
+			gConfigValues[myConfigEntry.Key] = strings.TrimSpace(myEnvValue)
+			gConfigSources[myConfigEntry.Key] = "env"
+		}
+		if myConfigEntry.FlagName != "" && mySetFlags[myConfigEntry.FlagName] {
+			gConfigValues[myConfigEntry.Key] = strings.TrimSpace(myEntryFlags[myConfigEntry.Key].Value)
+			gConfigSources[myConfigEntry.Key] = "flag"
+		}
+	}
+	if len(myProjectsRootsFlag) > 0 {
+		gConfigValues["ProjectsRoots"] = myProjectsRootsFlag.String()
+		gConfigSources["ProjectsRoots"] = "flag"
+	}
+
+	return builder_apply_server_config()
+}
+
+func builder_apply_server_config () error {
+
+	myProjectsRoots, myRootsErr := builder_parse_projects_roots(gConfigValues["ProjectsRoots"])
+	if myRootsErr != nil {
+		return fmt.Errorf("ProjectsRoots : %v", myRootsErr)
+	}
+	gProjectsRoots = myProjectsRoots
+
+	myWorkers, myAtoiErr := strconv.Atoi(gConfigValues["Workers"])
+	if myAtoiErr != nil || myWorkers <= 0 {
+		return fmt.Errorf("Workers : positive number expected, got \"%s\"", gConfigValues["Workers"])
+	}
+
+	myDurations := make(map[string]time.Duration)
+	for _, myDurationKey := range []string{"PollInterval", "GitCloneTimeout", "ShutdownTimeout"} {
+		myDuration, myParseErr := time.ParseDuration(gConfigValues[myDurationKey])
+		if myParseErr != nil || myDuration <= 0 {
+			return fmt.Errorf("%s : positive duration expected, ex : 30s, got \"%s\"", myDurationKey, gConfigValues[myDurationKey])
+		}
+		myDurations[myDurationKey] = myDuration
+	}
+
+	if (gConfigValues["TLSCertFile"] == "") != (gConfigValues["TLSKeyFile"] == "") {
+		return fmt.Errorf("TLSCertFile and TLSKeyFile go together")
+	}
+
+	if !filepath.IsAbs(gConfigValues["DataDir"]) {
+		return fmt.Errorf("DataDir : absolute path expected, got \"%s\"", gConfigValues["DataDir"])
+	}
+	gDataDirPath = gConfigValues["DataDir"]
+
+	myGoCacheDirPath := gConfigValues["GoCacheDir"]
+	if myGoCacheDirPath == "" {
+		myGoCacheDirPath = filepath.Join(gDataDirPath, kGoCacheDirName)
+	}
+	if !filepath.IsAbs(myGoCacheDirPath) {
+		return fmt.Errorf("GoCacheDir : absolute path expected, got \"%s\"", myGoCacheDirPath)
+	}
+	myDataSubDirPaths := make(map[string]string)
+	for myDirKey, myDefaultDirName := range map[string]string{"ToolchainsDir": kToolchainsDirName, "ToolchainArchivesDir": kToolchainArchivesDirName, "VulnDBDir": kVulnDBDirName} {
+		myDataSubDirPaths[myDirKey] = gConfigValues[myDirKey]
+		if myDataSubDirPaths[myDirKey] == "" {
+			myDataSubDirPaths[myDirKey] = filepath.Join(gDataDirPath, myDefaultDirName)
+		}
+		if !filepath.IsAbs(myDataSubDirPaths[myDirKey]) {
+			return fmt.Errorf("%s : absolute path expected, got \"%s\"", myDirKey, myDataSubDirPaths[myDirKey])
+		}
+	}
+
+	myDefaultGoCache := gConfigValues["DefaultGoCache"]
+	if myDefaultGoCache != kGoCacheModeShared && myDefaultGoCache != kGoCacheModeProject {
+		return fmt.Errorf("DefaultGoCache : expected %s or %s, got \"%s\"", kGoCacheModeShared, kGoCacheModeProject, myDefaultGoCache)
+	}
+
+	if gConfigValues["BuildCgroupDir"] != "" && !filepath.IsAbs(gConfigValues["BuildCgroupDir"]) {
+		return fmt.Errorf("BuildCgroupDir : absolute path expected, got \"%s\"", gConfigValues["BuildCgroupDir"])
+	}
+
+	// the defaults of the project settings go through the checks of the settings form
+	myDefaultSettings := make(map[string]string)
+	for _, myConfigEntry := range gConfigEntries {
+		if strings.HasPrefix(myConfigEntry.Key, "Default") {
+			myDefaultSettings[strings.TrimPrefix(myConfigEntry.Key, "Default")] = gConfigValues[myConfigEntry.Key]
+		}
+	}
+	myDefaultSettingsErrors := builder_validate_project_settings("", myDefaultSettings)
+	if len(myDefaultSettingsErrors) > 0 {
+		return fmt.Errorf("Default%s", myDefaultSettingsErrors[0])
+	}
+
+	if gConfigValues["SMTPAddr"] != "" {
+		_, _, mySplitErr := net.SplitHostPort(gConfigValues["SMTPAddr"])
+		if mySplitErr != nil {
+			return fmt.Errorf("SMTPAddr : host:port expected, got \"%s\"", gConfigValues["SMTPAddr"])
+		}
+	}
+
+	if gConfigValues["GoProxyListenAddr"] != "" {
+		_, _, mySplitErr := net.SplitHostPort(gConfigValues["GoProxyListenAddr"])
+		if mySplitErr != nil {
+			return fmt.Errorf("GoProxyListenAddr : host:port expected, got \"%s\"", gConfigValues["GoProxyListenAddr"])
+		}
+	}
+
+	gAutoDiscover = builder_parse_setting_bool(gConfigValues["AutoDiscover"])
+
+	myTLSAutoCert := gConfigValues["TLSCertFile"] == "" && builder_parse_setting_bool(gConfigValues["TLSAutoCert"])
+	myTLSEnabled := gConfigValues["TLSCertFile"] != "" || myTLSAutoCert
+	myListenAddr := gConfigValues["ListenAddr"]
+	if myListenAddr == "" {
+		myListenAddr = ":80"
+		if myTLSEnabled {
+			myListenAddr = ":443"
+		}
+	}
+	myHTTPRedirectAddr := gConfigValues["HTTPRedirectAddr"]
+	if !myTLSEnabled || myHTTPRedirectAddr == "off" || myHTTPRedirectAddr == myListenAddr {
+		myHTTPRedirectAddr = ""
+	}
+
+	gServerConfig = ServerConfig{ListenAddr: myListenAddr,
+		TLSCertFile: gConfigValues["TLSCertFile"],
+		TLSKeyFile: gConfigValues["TLSKeyFile"],
+		TLSEnabled: myTLSEnabled,
+		TLSAutoCert: myTLSAutoCert,
+		TLSHosts: builder_split_setting_list(gConfigValues["TLSHosts"]),
+		HTTPRedirectAddr: myHTTPRedirectAddr,
+		DockerHost: gConfigValues["DockerHost"],
+		Workers: myWorkers,
+		PollInterval: myDurations["PollInterval"],
+		GitCloneTimeout: myDurations["GitCloneTimeout"],
+		ShutdownTimeout: myDurations["ShutdownTimeout"],
+		RequeueInterrupted: builder_parse_setting_bool(gConfigValues["RequeueInterrupted"]),
+		LogDir: gConfigValues["LogDir"],
+		GoCacheDir: myGoCacheDirPath,
+		GoProxy: gConfigValues["GoProxy"],
+		GoProxyListenAddr: gConfigValues["GoProxyListenAddr"],
+		ToolchainsDir: myDataSubDirPaths["ToolchainsDir"],
+		ToolchainArchivesDir: myDataSubDirPaths["ToolchainArchivesDir"],
+		VulnDBDir: myDataSubDirPaths["VulnDBDir"],
+		BuildCgroupDir: gConfigValues["BuildCgroupDir"],
+		SMTPAddr: gConfigValues["SMTPAddr"],
+		SMTPFrom: gConfigValues["SMTPFrom"],
+		SMTPUser: gConfigValues["SMTPUser"],
+		SMTPPassword: gConfigValues["SMTPPassword"],
+		AdminUser: gConfigValues["AdminUser"],
+		AdminPassword: gConfigValues["AdminPassword"],
+		DefaultProjectSettings: make(map[string]string),
+	}
+	for _, myConfigEntry := range gConfigEntries {
+		if strings.HasPrefix(myConfigEntry.Key, "Default") {
+			gServerConfig.DefaultProjectSettings[strings.TrimPrefix(myConfigEntry.Key, "Default")] = gConfigValues[myConfigEntry.Key]
+		}
+	}
+
+	// the docker CLI commands run by the builder inherit the docker host
+	if gServerConfig.DockerHost != "" {
+		os.Setenv("DOCKER_HOST", gServerConfig.DockerHost)
+	}
+
+	if gServerConfig.LogDir != "" {
+		myMkdirErr := os.MkdirAll(gServerConfig.LogDir, 0755)
+		if myMkdirErr != nil {
+			return fmt.Errorf("LogDir : %v", myMkdirErr)
+		}
+		myLogFile, myOpenErr := os.OpenFile(filepath.Join(gServerConfig.LogDir, kServerLogFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if myOpenErr != nil {
+			return fmt.Errorf("LogDir : %v", myOpenErr)
+		}
+		gLogWriter = io.MultiWriter(os.Stdout, myLogFile)
+	}
+
+	return builder_init_logger(gConfigValues["LogFormat"], gConfigValues["LogLevel"])
+}
+
+// Logs the resolved config, one record per entry, secrets masked.
+func builder_log_server_config () {
+	for _, myConfigEntry := range gConfigEntries {
+		myValue := gConfigValues[myConfigEntry.Key]
+		if myConfigEntry.Secret && myValue != "" {
+			myValue = "********"
+		}
+		gLogger.Info("config", "key", myConfigEntry.Key, "value", myValue, "source", gConfigSources[myConfigEntry.Key])
+	}
+}
+
+// Returns the project settings, completed with the server defaults.
+func builder_get_effective_project_settings (theSettings map[string]string) map[string]string {
+	myEffectiveSettings := make(map[string]string)
+	for mySettingKey, mySettingValue := range gServerConfig.DefaultProjectSettings {
+		myEffectiveSettings[mySettingKey] = mySettingValue
+	}
+	for mySettingKey, mySettingValue := range theSettings {
+		if strings.TrimSpace(mySettingValue) != "" {
+			myEffectiveSettings[mySettingKey] = mySettingValue
+		}
+	}
+	return myEffectiveSettings
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+)
+
+const kCSRFCookieName = "builder_csrf"
+const kCSRFFormFieldName = "csrf_token"
+const kCSRFHeaderName = "X-CSRF-Token"
+
+//------------------------------------------------------------------------------
+
+// Returns the CSRF token of the browser, a new one is set as cookie on its first page.
+// The pages repeat the token in their forms and POST requests (double submit cookie).
+func builder_get_csrf_token (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) string {
+
+	myCookie, myCookieErr := theHTTPRequest.Cookie(kCSRFCookieName)
+	if myCookieErr == nil && len(myCookie.Value) == 64 {
+		return myCookie.Value
+	}
+
+	myTokenBytes := make([]byte, 32)
+	rand.Read(myTokenBytes)
+	myToken := hex.EncodeToString(myTokenBytes)
+
+	http.SetCookie(theHTTPResponse, &http.Cookie{Name: kCSRFCookieName,
+		Value: myToken,
+		Path: "/",
+		HttpOnly: true,
+		Secure: gServerConfig.TLSEnabled,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return myToken
+}
+
+// Checks that the request comes from the same host as the builder, when the browser tells.
+func builder_is_same_origin (theHTTPRequest *http.Request) bool {
+
+	myOrigin := theHTTPRequest.Header.Get("Origin")
+	if myOrigin == "" {
+		myOrigin = theHTTPRequest.Header.Get("Referer")
+	}
+	if myOrigin == "" {
+		return true
+	}
+	myOriginURL, myParseErr := url.Parse(myOrigin)
+	if myParseErr != nil {
+		return false
+	}
+	return myOriginURL.Host == theHTTPRequest.Host
+}
+
+// Only lets through POST requests carrying the CSRF token of their cookie, answers 405 or 403 otherwise.
+func builder_check_post (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) bool {
+
+	if theHTTPRequest.Method != http.MethodPost {
+		theHTTPResponse.Header().Set("Allow", http.MethodPost)
+		http.Error(theHTTPResponse, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	if !builder_is_same_origin(theHTTPRequest) {
+		http.Error(theHTTPResponse, "Cross origin request refused", http.StatusForbidden)
+		return false
+	}
+
+	myCookie, myCookieErr := theHTTPRequest.Cookie(kCSRFCookieName)
+	mySubmittedToken := theHTTPRequest.Header.Get(kCSRFHeaderName)
+	if mySubmittedToken == "" {
+		mySubmittedToken = theHTTPRequest.PostFormValue(kCSRFFormFieldName)
+	}
+	if myCookieErr != nil || myCookie.Value == "" || subtle.ConstantTimeCompare([]byte(myCookie.Value), []byte(mySubmittedToken)) != 1 {
+		http.Error(theHTTPResponse, "Invalid CSRF token, reload the page", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBuilderCheckPost (theTest *testing.T) {
+
+	myToken := strings.Repeat("ab", 32)
+	myOtherToken := strings.Repeat("cd", 32)
+
+	myTestCases := []struct {
+		Name string
+		Method string
+		Cookie string
+		Header string
+		Form string
+		Origin string
+		Referer string
+		Status int // 0 when the request goes through
+	}{
+		{Name: "header token", Method: http.MethodPost, Cookie: myToken, Header: myToken},
+		{Name: "form token", Method: http.MethodPost, Cookie: myToken, Form: myToken},
+		{Name: "same origin", Method: http.MethodPost, Cookie: myToken, Header: myToken, Origin: "http://builder.local"},
+		{Name: "same origin referer", Method: http.MethodPost, Cookie: myToken, Form: myToken, Referer: "http://builder.local/hello"},
+		{Name: "GET", Method: http.MethodGet, Cookie: myToken, Header: myToken, Status: http.StatusMethodNotAllowed},
+		{Name: "no cookie", Method: http.MethodPost, Header: myToken, Status: http.StatusForbidden},
+		{Name: "no token", Method: http.MethodPost, Cookie: myToken, Status: http.StatusForbidden},
+		{Name: "other token", Method: http.MethodPost, Cookie: myToken, Header: myOtherToken, Status: http.StatusForbidden},
+		{Name: "other form token", Method: http.MethodPost, Cookie: myToken, Form: myOtherToken, Status: http.StatusForbidden},
+		{Name: "cross origin", Method: http.MethodPost, Cookie: myToken, Header: myToken, Origin: "http://evil.example", Status: http.StatusForbidden},
+		{Name: "cross origin referer", Method: http.MethodPost, Cookie: myToken, Header: myToken, Referer: "http://evil.example/page", Status: http.StatusForbidden},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myHTTPRequest := httptest.NewRequest(myTestCase.Method, "http://builder.local/hello/build", strings.NewReader(url.Values{kCSRFFormFieldName: {myTestCase.Form}}.Encode()))
+		myHTTPRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if myTestCase.Cookie != "" {
+			myHTTPRequest.AddCookie(&http.Cookie{Name: kCSRFCookieName, Value: myTestCase.Cookie})
+		}
+		if myTestCase.Header != "" {
+			myHTTPRequest.Header.Set(kCSRFHeaderName, myTestCase.Header)
+		}
+		if myTestCase.Origin != "" {
+			myHTTPRequest.Header.Set("Origin", myTestCase.Origin)
+		}
+		if myTestCase.Referer != "" {
+			myHTTPRequest.Header.Set("Referer", myTestCase.Referer)
+		}
+		myHTTPResponse := httptest.NewRecorder()
+
+		myAllowed := builder_check_post(myHTTPResponse, myHTTPRequest)
+		if myAllowed != (myTestCase.Status == 0) {
+			theTest.Errorf("%s : allowed %v, expected %v", myTestCase.Name, myAllowed, myTestCase.Status == 0)
+		}
+		if myTestCase.Status != 0 && myHTTPResponse.Code != myTestCase.Status {
+			theTest.Errorf("%s : status %d, expected %d", myTestCase.Name, myHTTPResponse.Code, myTestCase.Status)
+		}
+	}
+}
+
+func TestBuilderGetCSRFToken (theTest *testing.T) {
+
+	// a new browser gets a new token as cookie
+	myHTTPResponse := httptest.NewRecorder()
+	myToken := builder_get_csrf_token(myHTTPResponse, httptest.NewRequest(http.MethodGet, "/", nil))
+	myCookies := myHTTPResponse.Result().Cookies()
+	if len(myToken) != 64 || len(myCookies) != 1 || myCookies[0].Name != kCSRFCookieName || myCookies[0].Value != myToken {
+		theTest.Fatalf("new token %q, cookies %v", myToken, myCookies)
+	}
+
+	// its next pages keep it
+	myHTTPRequest := httptest.NewRequest(http.MethodGet, "/", nil)
+	myHTTPRequest.AddCookie(myCookies[0])
+	myHTTPResponse = httptest.NewRecorder()
+	if builder_get_csrf_token(myHTTPResponse, myHTTPRequest) != myToken || len(myHTTPResponse.Result().Cookies()) != 0 {
+		theTest.Errorf("token not kept")
+	}
+
+	// a malformed cookie is replaced
+	myHTTPRequest = httptest.NewRequest(http.MethodGet, "/", nil)
+	myHTTPRequest.AddCookie(&http.Cookie{Name: kCSRFCookieName, Value: "short"})
+	if builder_get_csrf_token(httptest.NewRecorder(), myHTTPRequest) == "short" {
+		theTest.Errorf("malformed token kept")
+	}
+}
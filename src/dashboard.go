@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delay during which the dashboard polls reuse the git and docker results of a project.
+const kDashboardCacheDuration = 5 * time.Second
+
+// Git branch, container and image of a project, as last shown by the dashboard.
+type DashboardCacheEntry struct {
+    GitBranch string
+    ContainerState string // "" when no container runs
+    ContainerUp bool
+    ImageAge string
+    CachedAt time.Time
+}
+
+var gDashboardCache = make(map[string]DashboardCacheEntry)
+var gDashboardCacheMutex sync.Mutex // guards gDashboardCache, one poll refreshes it at a time
+
+//------------------------------------------------------------------------------
+
+func builder_get_git_branch (theDirPath string) string {
+	myOutputBytes, myGitErr := builder_run_command(theDirPath, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	if myGitErr != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(myOutputBytes))
+}
+
+// Summarizes the project status as "idle", "building", "starting", "stopping", "deploying", "prefetching", "up" or "down".
+func builder_get_project_dashboard_status (theProjectId string, theComposeAvailable bool, theContainerUp bool) string {
+
+	myProjectStatus := builder_get_project(theProjectId).Status
+	switch {
+	case strings.HasPrefix(myProjectStatus, "build-"), strings.HasPrefix(myProjectStatus, "refresh-"):
+		return "building"
+	case strings.HasPrefix(myProjectStatus, "up-"):
+		return "starting"
+	case strings.HasPrefix(myProjectStatus, "down-"):
+		return "stopping"
+	case strings.HasPrefix(myProjectStatus, "deploy-"):
+		return "deploying"
+	case strings.HasPrefix(myProjectStatus, "prefetch-"):
+		return "prefetching"
+	}
+
+	if theComposeAvailable {
+		if theContainerUp {
+			return "up"
+		}
+		return "down"
+	}
+	return "idle"
+}
+
+// Forgets the dashboard results of a project, refreshed by the next poll : its job just changed them.
+func builder_invalidate_dashboard_cache (theProjectId string) {
+	gDashboardCacheMutex.Lock()
+	delete(gDashboardCache, theProjectId)
+	gDashboardCacheMutex.Unlock()
+}
+
+// Returns the dashboard results of the projects, refreshed when older than kDashboardCacheDuration :
+// git rev-parse for each stale project, and docker images and ps once for all of them.
+func builder_get_dashboard_cache_entries (theProjects []*Project, theDockerConnected bool) map[string]DashboardCacheEntry {
+
+	myCacheEntries := make(map[string]DashboardCacheEntry)
+
+	gDashboardCacheMutex.Lock()
+	defer gDashboardCacheMutex.Unlock()
+
+	myNow := time.Now()
+	myDockerListed := false
+	for _, myProject := range theProjects {
+		myCacheEntry, myCached := gDashboardCache[myProject.Id]
+		if !myCached || myNow.Sub(myCacheEntry.CachedAt) > kDashboardCacheDuration {
+			if theDockerConnected && !myDockerListed {
+				builder_register_docker_images()
+				builder_register_docker_containers()
+				myDockerListed = true
+			}
+			myCacheEntry = DashboardCacheEntry{GitBranch: builder_get_git_branch(builder_get_srcdirpath(myProject)), CachedAt: myNow}
+			if theDockerConnected {
+				myDockerContainer := builder_fetch_docker_container(myProject.ImageName)
+				if myDockerContainer.Id != "" {
+					myCacheEntry.ContainerUp = true
+					myCacheEntry.ContainerState = myDockerContainer.State+" ("+myDockerContainer.Status+")"
+				}
+				myCacheEntry.ImageAge = builder_fetch_docker_image(myProject.ImageName).CreatedSince
+			}
+			gDashboardCache[myProject.Id] = myCacheEntry
+		}
+		myCacheEntries[myProject.Id] = myCacheEntry
+	}
+
+	return myCacheEntries
+}
+
+func builder_get_projects_dashboard_info () []map[string]string {
+
+	var myDashboardInfo []map[string]string
+
+	myDockerConnected := builder_is_docker_connected()
+	myProjects := builder_get_ordered_projects()
+	myCacheEntries := builder_get_dashboard_cache_entries(myProjects, myDockerConnected)
+
+	for _, myProject := range myProjects {
+		myProjectId := myProject.Id
+
+		myInfoMap := make(map[string]string)
+
+		myLastBuildResult := ""
+		myLastBuildTime := ""
+		if myProject.LastBuild != nil {
+			myLastBuildResult = fmt.Sprintf("#%d %s", myProject.LastBuild.Number, myProject.LastBuild.Result)
+			myLastBuildTime = myProject.LastBuild.FinishedAt.Format("2006-01-02 15:04")
+		}
+
+		myComposeAvailable := myDockerConnected && builder_project_has_docker_compose(myProjectId)
+		myCacheEntry := myCacheEntries[myProjectId]
+		myContainerUp := myCacheEntry.ContainerUp
+
+		myBuildIconState, myUpIconState, myDownIconState := builder_get_project_icon_states(myProjectId, myComposeAvailable, myContainerUp)
+
+		myInfoMap["Id"] = myProjectId
+		myInfoMap["Engine"] = myProject.Engine
+		myInfoMap["Status"] = builder_get_project_dashboard_status(myProjectId, myComposeAvailable, myContainerUp)
+		myInfoMap["LastBuildResult"] = myLastBuildResult
+		myInfoMap["LastBuildTime"] = myLastBuildTime
+		myInfoMap["ContainerState"] = myCacheEntry.ContainerState
+		myInfoMap["ImageAge"] = myCacheEntry.ImageAge
+		myInfoMap["NextRun"] = builder_get_project_next_run_text(myProject)
+		myInfoMap["GitBranch"] = myCacheEntry.GitBranch
+		myInfoMap["BuildIconState"] = myBuildIconState
+		myInfoMap["UpIconState"] = myUpIconState
+		myInfoMap["DownIconState"] = myDownIconState
+
+		myDashboardInfo = append(myDashboardInfo, myInfoMap)
+	}
+
+	return myDashboardInfo
+}
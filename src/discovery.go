@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"go/build"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const kAutoDiscoverEnvName = "GO_BUILDER_AUTODISCOVER"
+const kIgnoreFileName = "builder.ignore"
+const kDiscoveryMaxDepth = 6
+const kMonorepoTargetsDirName = "bin"
+
+// Directories with a go.mod are registered as projects even without builder.settings.
+var gAutoDiscover = false
+
+//------------------------------------------------------------------------------
+
+func builder_is_discovery_skipped_dir (theDirName string) bool {
+	if strings.HasPrefix(theDirName, ".") || strings.HasPrefix(theDirName, "_") {
+		return true
+	}
+	switch theDirName {
+	case "vendor", "testdata", "node_modules":
+		return true
+	}
+	return false
+}
+
+// Walks theRootDirPath down to kDiscoveryMaxDepth, skipping vendor, testdata and hidden dirs.
+func builder_walk_discovery_dirs (theRootDirPath string, theVisitFunc func(theRelativeDirPath string) bool) {
+
+	filepath.WalkDir(theRootDirPath, func(theDirPath string, theDirEntry fs.DirEntry, theWalkErr error) error {
+		if theWalkErr != nil || !theDirEntry.IsDir() {
+			return nil
+		}
+		myRelativeDirPath, myRelErr := filepath.Rel(theRootDirPath, theDirPath)
+		if myRelErr != nil {
+			return filepath.SkipDir
+		}
+		if myRelativeDirPath != "." {
+			if builder_is_discovery_skipped_dir(theDirEntry.Name()) {
+				return filepath.SkipDir
+			}
+			if strings.Count(myRelativeDirPath, string(filepath.Separator)) >= kDiscoveryMaxDepth {
+				return filepath.SkipDir
+			}
+		}
+		if !theVisitFunc(myRelativeDirPath) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+func builder_is_discoverable_dir (theDirName string, theDirPath string) bool {
+
+	if builder_is_discovery_skipped_dir(theDirName) {
+		return false
+	}
+
+	myHasGoModule := false
+	builder_walk_discovery_dirs(theDirPath, func(theRelativeDirPath string) bool {
+		_, myStatErr := os.Stat(filepath.Join(theDirPath, theRelativeDirPath, "go.mod"))
+		myHasGoModule = (myStatErr == nil)
+		return !myHasGoModule
+	})
+	return myHasGoModule
+}
+
+// Returns the dirs of the main packages found under theRootDirPath, relative to it.
+// Nested modules are walked too, each main package is built from its own dir.
+func builder_find_main_packages (theRootDirPath string) []string {
+
+	var myMainPackages []string
+
+	builder_walk_discovery_dirs(theRootDirPath, func(theRelativeDirPath string) bool {
+		myPackage, myImportErr := build.Default.ImportDir(filepath.Join(theRootDirPath, theRelativeDirPath), 0)
+		if myImportErr == nil && myPackage.Name == "main" {
+			myMainPackages = append(myMainPackages, filepath.ToSlash(theRelativeDirPath))
+		}
+		return true
+	})
+
+	return myMainPackages
+}
+
+//------------------------------------------------------------------------------
+
+// builder.ignore, at the top of a projects root, lists, one per line, the ids (or id patterns, ex : "tools@*") of the projects to hide,
+// without the root label.
+func builder_load_ignore_patterns (theProjectsRootDirPath string) []string {
+
+	var myIgnorePatterns []string
+
+	myIgnoreFile, myOpenErr := os.Open(filepath.Join(theProjectsRootDirPath, kIgnoreFileName))
+	if myOpenErr != nil {
+		return myIgnorePatterns
+	}
+	defer myIgnoreFile.Close()
+
+	myScanner := bufio.NewScanner(myIgnoreFile)
+	for myScanner.Scan() {
+		myIgnoreLine := strings.TrimSpace(myScanner.Text())
+		if myIgnoreLine != "" && !strings.HasPrefix(myIgnoreLine, "#") {
+			myIgnorePatterns = append(myIgnorePatterns, myIgnoreLine)
+		}
+	}
+
+	return myIgnorePatterns
+}
+
+func builder_is_project_ignored (theProjectId string, theIgnorePatterns []string) bool {
+	for _, myIgnorePattern := range theIgnorePatterns {
+		myMatched, myMatchErr := path.Match(myIgnorePattern, theProjectId)
+		if myMatchErr == nil && myMatched {
+			return true
+		}
+	}
+	return false
+}
+
+//------------------------------------------------------------------------------
+
+// Returns the projects of a projects dir entry : the dir itself, or one project per main package
+// for monorepos (auto-discovered dirs, or "Targets=auto" in builder.settings).
+func builder_new_dir_projects (theProjectsRoot ProjectsRoot, theDirName string, theDirPath string, theHasSettings bool) []*Project {
+
+	myProjectId := builder_get_root_project_id(theProjectsRoot, theDirName)
+	mySettings := builder_load_project_settings(theDirPath)
+	myBaseProject := builder_new_project(myProjectId, theDirPath, builder_get_effective_project_settings(mySettings))
+	myBaseProject.Root = theProjectsRoot.Label
+	myBaseProject.TargetName = theDirName
+	myBaseProject.TargetFilePath = filepath.Join(theDirPath, theDirName)
+	if mySettings["ImageName"] == "" {
+		myBaseProject.ImageName = strings.ReplaceAll(myProjectId, kProjectsRootSeparator, "-")
+	}
+
+	if !theHasSettings && mySettings["SrcDir"] == "" {
+		myBaseProject.SrcDir = builder_get_discovered_srcdir(theDirPath)
+	}
+
+	var myProjects []*Project
+
+	myDiscoverTargets := !theHasSettings || strings.TrimSpace(mySettings["Targets"]) == "auto"
+	if !myDiscoverTargets {
+		myProjects = append(myProjects, myBaseProject)
+	} else {
+		myMainPackages := builder_find_main_packages(builder_join_srcdir(theDirPath, myBaseProject.SrcDir))
+		switch len(myMainPackages) {
+		case 0:
+			if theHasSettings {
+				myProjects = append(myProjects, myBaseProject)
+			}
+		case 1:
+			myBaseProject.SrcDir = builder_join_srcdir(myBaseProject.SrcDir, myMainPackages[0])
+			myProjects = append(myProjects, myBaseProject)
+		default:
+			myTargetNameCounts := make(map[string]int)
+			for _, myMainPackage := range myMainPackages {
+				myTargetNameCounts[builder_get_target_name(theDirName, myMainPackage)]++
+			}
+			for _, myMainPackage := range myMainPackages {
+				myTargetName := builder_get_target_name(theDirName, myMainPackage)
+				if myTargetNameCounts[myTargetName] > 1 {
+					myTargetName = strings.ReplaceAll(myMainPackage, "/", "-")
+				}
+				myTargetProject := *myBaseProject
+				myTargetProject.Id = myProjectId+"@"+myTargetName
+				myTargetProject.SrcDir = builder_join_srcdir(myBaseProject.SrcDir, myMainPackage)
+				myTargetProject.TargetName = myTargetName
+				myTargetProject.TargetFilePath = filepath.Join(theDirPath, kMonorepoTargetsDirName, myTargetName)
+				if mySettings["ImageName"] == "" {
+					myTargetProject.ImageName = strings.ToLower(myBaseProject.ImageName+"-"+myTargetName)
+				}
+				if mySettings["Dockerfile"] == "" {
+					myTargetProject.DockerBuild.Dockerfile = filepath.Join(myTargetProject.SrcDir, kDefaultDockerfile)
+				}
+				myProjects = append(myProjects, &myTargetProject)
+			}
+		}
+	}
+
+	for _, myProject := range myProjects {
+		builder_migrate_project_data_files(builder_get_unlabelled_project_id(theProjectsRoot, myProject.Id), myProject.Id)
+		myProject.LastBuild = builder_get_last_build_record(myProject.Id)
+		builder_set_project_build_command(myProject)
+	}
+
+	return myProjects
+}
+
+// Src dir of an auto-discovered dir : "src" when it holds the go.mod, else "" (the dir itself).
+func builder_get_discovered_srcdir (theDirPath string) string {
+	_, myStatErr := os.Stat(filepath.Join(theDirPath, "src", "go.mod"))
+	if myStatErr == nil {
+		return "src"
+	}
+	return ""
+}
+
+func builder_get_target_name (theDirName string, theMainPackage string) string {
+	if theMainPackage == "." {
+		return theDirName
+	}
+	return path.Base(theMainPackage)
+}
+
+func builder_join_srcdir (theDirPath string, theSubDirPath string) string {
+	if theSubDirPath == "" || theSubDirPath == "." {
+		return theDirPath
+	}
+	if theDirPath == "" {
+		return filepath.FromSlash(theSubDirPath)
+	}
+	return filepath.Join(theDirPath, filepath.FromSlash(theSubDirPath))
+}
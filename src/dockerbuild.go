@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const kEngineDocker = "docker"
+const kDefaultBuilderImage = "golang:1.23"
+const kDockerBuildsDirName = "builds"
+
+// Paths inside the build containers.
+const kDockerBuildProjectDirPath = "/project"
+const kDockerBuildOutputDirPath = "/out"
+const kDockerBuildCacheDirPath = "/cache"
+const kDockerBuildGoProxyDirPath = "/goproxy"
+const kDockerBuildToolchainDirPath = "/toolchain"
+
+const kDockerBuildGoVersionPrefix = "Go version : "
+
+var gBuilderImageRegexp = regexp.MustCompile(`^([A-Za-z0-9.-]+(:[0-9]+)?/)?[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+var gDockerNameUnsafeRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+//------------------------------------------------------------------------------
+
+// Output dir of the docker builds of a project, mounted writable as /out.
+func builder_get_docker_build_output_dirpath (theProjectId string) string {
+	return filepath.Join(gDataDirPath, kDockerBuildsDirName, gDockerNameUnsafeRegexp.ReplaceAllString(theProjectId, "_"))
+}
+
+// Installed toolchain of the project, mounted as /toolchain, empty for the Go of the builder image.
+func builder_get_docker_build_toolchain_dirpath (theProjectId string) string {
+	myGoVersion := builder_get_project_go_version(theProjectId)
+	if myGoVersion == "" || !builder_is_toolchain_installed(myGoVersion) {
+		return ""
+	}
+	return builder_get_toolchain_dirpath(myGoVersion)
+}
+
+func builder_get_docker_build_container_name (theProjectId string, theBuildNumber int) string {
+	return "go-builder-"+gDockerNameUnsafeRegexp.ReplaceAllString(theProjectId, "_")+"-"+strconv.Itoa(theBuildNumber)
+}
+
+// Returns the docker run arguments of a build : the project dir mounted read-only, the output dir
+// and the Go caches of the project writable, the modules of the other caches as GOPROXY, and the
+// toolchain of the project when one is installed.
+// The mounts use the paths of the builder, the same as the docker host ones when the builder runs in a container.
+func builder_get_docker_run_args (theProjectId string, theBuildNumber int, theOutputDirPath string) []string {
+
+	myProject := builder_get_project(theProjectId)
+	myGoCache := builder_get_project_go_cache(theProjectId)
+
+	myWorkDirPath := kDockerBuildProjectDirPath
+	if myProject.SrcDir != "" {
+		myWorkDirPath = kDockerBuildProjectDirPath+"/"+filepath.ToSlash(myProject.SrcDir)
+	}
+
+	// no --rm, the container is inspected for an out of memory kill, then removed
+	myArgs := []string{"run",
+		"--name", builder_get_docker_build_container_name(theProjectId, theBuildNumber),
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"--mount", "type=bind,source="+myProject.DirPath+",target="+kDockerBuildProjectDirPath+",readonly",
+		"--mount", "type=bind,source="+theOutputDirPath+",target="+kDockerBuildOutputDirPath,
+		"--mount", "type=bind,source="+myGoCache.GoCacheDirPath+",target="+kDockerBuildCacheDirPath+"/go-build",
+		"--mount", "type=bind,source="+myGoCache.GoModCacheDirPath+",target="+kDockerBuildCacheDirPath+"/mod",
+		"--workdir", myWorkDirPath,
+		"--env", "HOME=/tmp",
+		"--env", "GOCACHE="+kDockerBuildCacheDirPath+"/go-build",
+		"--env", "GOMODCACHE="+kDockerBuildCacheDirPath+"/mod",
+		// the project files belong to another user than the build, git would refuse them for the VCS stamping
+		"--env", "GIT_CONFIG_COUNT=1",
+		"--env", "GIT_CONFIG_KEY_0=safe.directory",
+		"--env", "GIT_CONFIG_VALUE_0=*",
+	}
+
+	var myGoProxies []string
+	for myProxyIndex, myProxyDirPath := range builder_get_go_proxy_dirpaths() {
+		_, myStatErr := os.Stat(myProxyDirPath)
+		if myStatErr != nil {
+			continue
+		}
+		myContainerProxyDirPath := kDockerBuildGoProxyDirPath+"/"+strconv.Itoa(myProxyIndex)
+		myArgs = append(myArgs, "--mount", "type=bind,source="+myProxyDirPath+",target="+myContainerProxyDirPath+",readonly")
+		myGoProxies = append(myGoProxies, "file://"+myContainerProxyDirPath)
+	}
+	if gServerConfig.GoProxy == kGoProxyOff {
+		// offline, go.sum still verifies the modules
+		myArgs = append(myArgs, "--network", "none", "--env", "GOSUMDB=off")
+	} else {
+		myGoProxies = append(myGoProxies, gServerConfig.GoProxy)
+	}
+	if len(myGoProxies) > 0 {
+		myArgs = append(myArgs, "--env", "GOPROXY="+strings.Join(myGoProxies, ","))
+	}
+
+	if myProject.Limits.CPU > 0 {
+		myArgs = append(myArgs, "--cpus", strconv.FormatFloat(myProject.Limits.CPU, 'f', -1, 64))
+	}
+	if myProject.Limits.Memory > 0 {
+		myMemory := strconv.FormatInt(myProject.Limits.Memory, 10)
+		myArgs = append(myArgs, "--memory", myMemory, "--memory-swap", myMemory)
+	}
+
+	myToolchainDirPath := builder_get_docker_build_toolchain_dirpath(theProjectId)
+	if myToolchainDirPath != "" {
+		myArgs = append(myArgs, "--mount", "type=bind,source="+myToolchainDirPath+",target="+kDockerBuildToolchainDirPath+",readonly",
+			"--env", "GOROOT="+kDockerBuildToolchainDirPath,
+			"--env", "GOTOOLCHAIN=local",
+		)
+	} else if builder_get_go_mod_toolchain(builder_get_project_srcdirpath(theProjectId)) != "" {
+		myArgs = append(myArgs, "--env", "GOTOOLCHAIN=local")
+	}
+
+	return myArgs
+}
+
+// Runs the build command of the project in a throwaway container of its builder image, within its limits,
+// then copies the built program from the output dir to the target path.
+func builder_run_docker_build (theProjectId string, theRecord *BuildRecord) ([]byte, error) {
+
+	myProject := builder_get_project(theProjectId)
+
+	if !builder_is_docker_connected() {
+		return nil, fmt.Errorf("docker not connected (%s)", gServerConfig.DockerHost)
+	}
+
+	myOutputDirPath := builder_get_docker_build_output_dirpath(theProjectId)
+	myRemoveErr := os.RemoveAll(myOutputDirPath)
+	if myRemoveErr != nil {
+		return nil, myRemoveErr
+	}
+	myGoCache := builder_get_project_go_cache(theProjectId)
+	for _, myDirPath := range []string{myOutputDirPath, myGoCache.GoCacheDirPath, myGoCache.GoModCacheDirPath} {
+		myMkdirErr := os.MkdirAll(myDirPath, 0755)
+		if myMkdirErr != nil {
+			return nil, myMkdirErr
+		}
+	}
+
+	// the PATH of the image is only known inside the container
+	myScript := "echo \""+kDockerBuildGoVersionPrefix+"$(go env GOVERSION)\" && "+myProject.BuildCommand
+	if builder_get_docker_build_toolchain_dirpath(theProjectId) != "" {
+		myScript = "export PATH="+kDockerBuildToolchainDirPath+"/bin:$PATH && "+myScript
+	}
+
+	myContext, myCancel := builder_new_build_context(myProject.Limits)
+	defer myCancel()
+	myContainerName := builder_get_docker_build_container_name(theProjectId, theRecord.Number)
+	myDockerArgs := builder_get_docker_run_args(theProjectId, theRecord.Number, myOutputDirPath)
+	// the scheduled builds pick up the updates of the builder image
+	if theRecord.Trigger == kTriggerScheduled {
+		myDockerArgs = append(myDockerArgs, "--pull", "always")
+	}
+	myDockerArgs = append(myDockerArgs, myProject.BuilderImage, "/bin/sh", "-c", myScript)
+	myDockerCommand := builder_new_job_command_context(myContext, "docker", myDockerArgs...)
+	myOutputBytes, myFailureReason, myDockerErr := builder_run_limited_command(myDockerCommand, myContext, myCancel, myProject.Limits)
+
+	// a cancelled docker CLI leaves its container running, removed here in any case
+	myInspectBytes, _ := exec.Command("docker", "inspect", "--format", "{{.State.OOMKilled}}", myContainerName).Output()
+	exec.Command("docker", "rm", "--force", myContainerName).Run()
+	if myDockerErr != nil && myFailureReason == "" && strings.TrimSpace(string(myInspectBytes)) == "true" {
+		myFailureReason = kFailureMemoryLimit
+	}
+	// 125 : docker failed to run the container, the other errors are the ones of the build
+	if myDockerCommand.ProcessState != nil && myDockerCommand.ProcessState.ExitCode() == 125 {
+		builder_record_docker_error("docker", []string{"run"}, myDockerErr)
+	}
+	theRecord.FailureReason = myFailureReason
+
+	for _, myOutputLine := range strings.Split(string(myOutputBytes), "\n") {
+		if strings.HasPrefix(myOutputLine, kDockerBuildGoVersionPrefix) {
+			theRecord.GoVersion = strings.TrimSpace(strings.TrimPrefix(myOutputLine, kDockerBuildGoVersionPrefix))
+			break
+		}
+	}
+
+	if myDockerErr != nil {
+		return myOutputBytes, myDockerErr
+	}
+
+	myCopyErr := builder_copy_docker_build_target(filepath.Join(myOutputDirPath, myProject.TargetName), myProject.TargetFilePath)
+	if myCopyErr != nil {
+		return myOutputBytes, myCopyErr
+	}
+
+	return myOutputBytes, nil
+}
+
+// Replaces the target with the built program, atomically.
+func builder_copy_docker_build_target (theBuiltFilePath string, theTargetFilePath string) error {
+
+	myBuiltFile, myOpenErr := os.Open(theBuiltFilePath)
+	if myOpenErr != nil {
+		return fmt.Errorf("built program not found in %s : %v", kDockerBuildOutputDirPath, myOpenErr)
+	}
+	defer myBuiltFile.Close()
+
+	myMkdirErr := os.MkdirAll(filepath.Dir(theTargetFilePath), 0755)
+	if myMkdirErr != nil {
+		return myMkdirErr
+	}
+	myTempFile, myTempErr := os.CreateTemp(filepath.Dir(theTargetFilePath), "."+filepath.Base(theTargetFilePath)+".*")
+	if myTempErr != nil {
+		return myTempErr
+	}
+	myTempFilePath := myTempFile.Name()
+	defer os.Remove(myTempFilePath)
+
+	_, myCopyErr := io.Copy(myTempFile, myBuiltFile)
+	if myCopyErr == nil {
+		myCopyErr = myTempFile.Chmod(0755)
+	}
+	myCloseErr := myTempFile.Close()
+	if myCopyErr != nil {
+		return myCopyErr
+	}
+	if myCloseErr != nil {
+		return myCloseErr
+	}
+
+	return os.Rename(myTempFilePath, theTargetFilePath)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const kGoProxyURLPath = "/goproxy/"
+const kGoProxyOff = "off"
+const kDepsDirName = "deps"
+
+var gGoModuleErrorRegexp = regexp.MustCompile(`^go: ([^@\s]+)@(\S+): (.+)$`)
+
+type GoModule struct {
+    Path string
+    Version string
+    Error string // why the module is missing from the cache, empty when present
+}
+
+// Modules of a project as listed by its last prefetch, the dependencies page only reads them.
+type DepsListing struct {
+    ListedAt time.Time
+    Modules []GoModule
+    Error string // why the modules could not be listed, empty when listed
+}
+
+//------------------------------------------------------------------------------
+
+// Module download dirs of every Go cache, laid out as a GOPROXY ($GOMODCACHE/cache/download).
+func builder_get_go_proxy_dirpaths () []string {
+	var myProxyDirPaths []string
+	for _, myGoCache := range builder_list_go_caches() {
+		myProxyDirPaths = append(myProxyDirPaths, filepath.Join(myGoCache.GoModCacheDirPath, "cache", "download"))
+	}
+	return myProxyDirPaths
+}
+
+// Environment of the go commands of a project build : its caches, its toolchain, and a GOPROXY
+// trying the modules already downloaded by the builder before the upstream proxy.
+func builder_get_go_env (theProjectId string) []string {
+
+	myGoEnv := append(builder_get_go_cache_env(theProjectId), builder_get_toolchain_env(theProjectId)...)
+
+	var myGoProxies []string
+	for _, myProxyDirPath := range builder_get_go_proxy_dirpaths() {
+		myGoProxies = append(myGoProxies, "file://"+filepath.ToSlash(myProxyDirPath))
+	}
+	if gServerConfig.GoProxy == kGoProxyOff {
+		// offline, go.sum still verifies the modules
+		myGoEnv = append(myGoEnv, "GOSUMDB=off")
+	} else {
+		myGoProxies = append(myGoProxies, gServerConfig.GoProxy)
+	}
+	myGoEnv = append(myGoEnv, "GOPROXY="+strings.Join(myGoProxies, ","))
+
+	return myGoEnv
+}
+
+// Serves the downloaded modules with the GOPROXY protocol, for the builds of other hosts or containers.
+func builder_serve_go_proxy (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+
+	if theHTTPRequest.Method != http.MethodGet && theHTTPRequest.Method != http.MethodHead {
+		theHTTPResponse.Header().Set("Allow", "GET, HEAD")
+		http.Error(theHTTPResponse, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	myModuleFilePath := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(theHTTPRequest.URL.Path, kGoProxyURLPath)), "/")
+	if myModuleFilePath == "" || !strings.Contains(myModuleFilePath, "/@v/") {
+		http.NotFound(theHTTPResponse, theHTTPRequest)
+		return
+	}
+
+	for _, myProxyDirPath := range builder_get_go_proxy_dirpaths() {
+		myFilePath := filepath.Join(myProxyDirPath, filepath.FromSlash(myModuleFilePath))
+		myFileInfo, myStatErr := os.Stat(myFilePath)
+		if myStatErr != nil || myFileInfo.IsDir() {
+			continue
+		}
+		switch path.Ext(myModuleFilePath) {
+		case ".info":
+			theHTTPResponse.Header().Set("Content-Type", "application/json")
+		case ".zip":
+			theHTTPResponse.Header().Set("Content-Type", "application/zip")
+		default:
+			theHTTPResponse.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		http.ServeFile(theHTTPResponse, theHTTPRequest, myFilePath)
+		return
+	}
+
+	// 404 lets the go command try the next proxy of its GOPROXY list
+	http.NotFound(theHTTPResponse, theHTTPRequest)
+}
+
+// Serves the downloaded modules on their own address only, as the GOPROXY protocol has no auth :
+// the listener of the pages may be public, GoProxyListenAddr is meant for a private network.
+func builder_serve_go_proxy_listener () {
+
+	myProxyMux := http.NewServeMux()
+	myProxyMux.HandleFunc(kGoProxyURLPath, builder_serve_go_proxy)
+
+	gLogger.Info("builder serving Go modules", "addr", gServerConfig.GoProxyListenAddr)
+	myServeErr := http.ListenAndServe(gServerConfig.GoProxyListenAddr, builder_log_requests(myProxyMux))
+	gLogger.Error("Go modules proxy stopped", "error", myServeErr)
+}
+
+//------------------------------------------------------------------------------
+
+func builder_project_has_go_mod (theProjectId string) bool {
+	_, myStatErr := os.Stat(filepath.Join(builder_get_project_srcdirpath(theProjectId), "go.mod"))
+	return myStatErr == nil
+}
+
+// Lists the modules required by the project, answered by the builder caches only, as an offline build would.
+func builder_list_project_go_modules (theProjectId string) ([]GoModule, error) {
+
+	var myGoModules []GoModule
+
+	if !builder_project_has_go_mod(theProjectId) {
+		return myGoModules, fmt.Errorf("no go.mod in %s", builder_get_project_srcdirpath(theProjectId))
+	}
+
+	var myGoProxies []string
+	for _, myProxyDirPath := range builder_get_go_proxy_dirpaths() {
+		myGoProxies = append(myGoProxies, "file://"+filepath.ToSlash(myProxyDirPath))
+	}
+	var myStdoutBuffer, myStderrBuffer bytes.Buffer
+	myDownloadCommand := builder_new_job_command(builder_get_project_go_command(theProjectId), "mod", "download", "-json")
+	myDownloadCommand.Dir = builder_get_project_srcdirpath(theProjectId)
+	myDownloadCommand.Env = append(append(os.Environ(), builder_get_go_env(theProjectId)...), "GOPROXY="+strings.Join(myGoProxies, ","), "GOSUMDB=off")
+	myDownloadCommand.Stdout = &myStdoutBuffer
+	myDownloadCommand.Stderr = &myStderrBuffer
+	myDownloadErr := myDownloadCommand.Run()
+
+	// the modules whose zip is missing come with an Error in the JSON
+	myDecoder := json.NewDecoder(&myStdoutBuffer)
+	for {
+		var myGoModule GoModule
+		myDecodeErr := myDecoder.Decode(&myGoModule)
+		if myDecodeErr == io.EOF {
+			break
+		}
+		if myDecodeErr != nil {
+			return myGoModules, fmt.Errorf("go mod download output : %v", myDecodeErr)
+		}
+		myGoModules = append(myGoModules, myGoModule)
+	}
+
+	// the modules whose go.mod is missing stop the module graph loading, with "go: <path>@<version>: <error>"
+	for _, myStderrLine := range strings.Split(myStderrBuffer.String(), "\n") {
+		myMatch := gGoModuleErrorRegexp.FindStringSubmatch(myStderrLine)
+		if myMatch != nil {
+			myGoModules = append(myGoModules, GoModule{Path: myMatch[1], Version: myMatch[2], Error: myMatch[3]})
+		}
+	}
+	if myDownloadErr != nil && len(myGoModules) == 0 {
+		return myGoModules, fmt.Errorf("go mod download failed : %v : %s", myDownloadErr, strings.TrimSpace(myStderrBuffer.String()))
+	}
+
+	sort.Slice(myGoModules, func(i, j int) bool {
+		if (myGoModules[i].Error != "") != (myGoModules[j].Error != "") {
+			return myGoModules[i].Error != ""
+		}
+		return myGoModules[i].Path < myGoModules[j].Path
+	})
+
+	return myGoModules, nil
+}
+
+// Downloads every module required by the project in its cache, so that its builds work offline.
+func builder_prefetch_project_deps (theProjectId string) []string {
+
+	var myReturnLines []string
+
+	myReturnLines = append(myReturnLines, "Prefetching Go modules : "+theProjectId)
+
+	if !builder_project_has_go_mod(theProjectId) {
+		myReturnLines = append(myReturnLines, "No go.mod in "+builder_get_project_srcdirpath(theProjectId))
+		return myReturnLines
+	}
+
+	myToolchainLines, myToolchainErr := builder_install_project_toolchain(theProjectId)
+	myReturnLines = append(myReturnLines, myToolchainLines...)
+	if myToolchainErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Prefetch failed : %v", myToolchainErr))
+		return myReturnLines
+	}
+
+	myDownloadCommand := builder_new_job_command(builder_get_project_go_command(theProjectId), "mod", "download")
+	myDownloadCommand.Dir = builder_get_project_srcdirpath(theProjectId)
+	myDownloadCommand.Env = append(os.Environ(), builder_get_go_env(theProjectId)...)
+	myDownloadOutputBytes, myDownloadErr := myDownloadCommand.CombinedOutput()
+	if myDownloadErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("go mod download failed : %v", myDownloadErr))
+	}
+	myReturnLines = builder_append_output_lines(myReturnLines, myDownloadOutputBytes)
+
+	myGoModules, myListErr := builder_list_project_go_modules(theProjectId)
+	mySaveErr := builder_save_deps_listing(theProjectId, myGoModules, myListErr)
+	if mySaveErr != nil {
+		gLogger.Error("deps listing not saved", "project", theProjectId, "error", mySaveErr)
+	}
+	if myListErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Modules check failed : %v", myListErr))
+		return myReturnLines
+	}
+	myMissingCount := 0
+	for _, myGoModule := range myGoModules {
+		if myGoModule.Error != "" {
+			myMissingCount++
+			myReturnLines = append(myReturnLines, "Missing : "+myGoModule.Path+"@"+myGoModule.Version+" : "+myGoModule.Error)
+		}
+	}
+	myReturnLines = append(myReturnLines, fmt.Sprintf("%d module(s) in cache, %d missing", len(myGoModules)-myMissingCount, myMissingCount))
+
+	return myReturnLines
+}
+
+func builder_get_deps_listing_filepath (theProjectId string) string {
+	return filepath.Join(gDataDirPath, kDepsDirName, theProjectId+".json")
+}
+
+func builder_save_deps_listing (theProjectId string, theGoModules []GoModule, theListErr error) error {
+
+	myDepsListing := DepsListing{ListedAt: time.Now(), Modules: theGoModules}
+	if theListErr != nil {
+		myDepsListing.Error = theListErr.Error()
+	}
+
+	myDepsListingFilePath := builder_get_deps_listing_filepath(theProjectId)
+	myMkdirErr := os.MkdirAll(filepath.Dir(myDepsListingFilePath), 0755)
+	if myMkdirErr != nil {
+		return myMkdirErr
+	}
+	myDepsListingBytes, _ := json.Marshal(myDepsListing)
+	return os.WriteFile(myDepsListingFilePath, myDepsListingBytes, 0644)
+}
+
+// Loads the listing of the last prefetch, nil when the project was never prefetched.
+func builder_load_deps_listing (theProjectId string) *DepsListing {
+	myDepsListingBytes, myReadErr := os.ReadFile(builder_get_deps_listing_filepath(theProjectId))
+	if myReadErr != nil {
+		return nil
+	}
+	var myDepsListing DepsListing
+	if json.Unmarshal(myDepsListingBytes, &myDepsListing) != nil {
+		return nil
+	}
+	return &myDepsListing
+}
+
+func builder_get_project_deps_info (theProjectId string) map[string]interface{} {
+
+	myDepsInfo := map[string]interface{}{
+		"Id": theProjectId,
+		"Status": builder_get_project(theProjectId).Status,
+		"Listed": false,
+		"ListedAt": "",
+		"Modules": []GoModule{},
+		"MissingCount": 0,
+		"Error": "",
+	}
+
+	myDepsListing := builder_load_deps_listing(theProjectId)
+	if myDepsListing == nil {
+		return myDepsInfo
+	}
+	myMissingCount := 0
+	for _, myGoModule := range myDepsListing.Modules {
+		if myGoModule.Error != "" {
+			myMissingCount++
+		}
+	}
+	myDepsInfo["Listed"] = true
+	myDepsInfo["ListedAt"] = myDepsListing.ListedAt.Format("2006-01-02 15:04")
+	myDepsInfo["Modules"] = myDepsListing.Modules
+	myDepsInfo["MissingCount"] = myMissingCount
+	myDepsInfo["Error"] = myDepsListing.Error
+	return myDepsInfo
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const kHistoryDirName = "history"
+
+type BuildRecord struct {
+    ProjectId string
+    Number int // incremented for every build of the project, starting at 1
+    StartedAt time.Time
+    FinishedAt time.Time
+    Result string // "ok", "failed", "interrupted", "cancelled" (pending when the builder stopped)
+    FailureReason string // "time limit", "memory limit", "output limit" or "vulnerabilities" for the builds failed by them
+    Trigger string // "manual", "requeue", "scheduled"
+    Refresh bool // the dependencies were updated before the build
+    Commit string // short git commit of the sources, if any
+    GitTag string // git tag pointing at the commit, if any
+    GoVersion string // go env GOVERSION of the build, ex : "go1.22.3"
+    ImageTags []string // local image tags applied by the docker build
+    PushedTags []string // fully qualified references pushed to the registry
+    VersionTag string // "build-<number>" local tag kept for rollbacks
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_history_filepath (theProjectId string) string {
+	return filepath.Join(gDataDirPath, kHistoryDirName, theProjectId+".jsonl")
+}
+
+func builder_load_build_history (theProjectId string) []BuildRecord {
+
+	var myRecords []BuildRecord
+
+	myHistoryFile, myOpenErr := os.Open(builder_get_history_filepath(theProjectId))
+	if myOpenErr != nil {
+		return myRecords
+	}
+	defer myHistoryFile.Close()
+
+	myScanner := bufio.NewScanner(myHistoryFile)
+	myScanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for myScanner.Scan() {
+		var myRecord BuildRecord
+		myUnmarshalErr := json.Unmarshal(myScanner.Bytes(), &myRecord)
+		if myUnmarshalErr == nil {
+			myRecords = append(myRecords, myRecord)
+		}
+	}
+
+	return myRecords
+}
+
+func builder_get_last_build_record (theProjectId string) *BuildRecord {
+	myRecords := builder_load_build_history(theProjectId)
+	if len(myRecords) == 0 {
+		return nil
+	}
+	return &myRecords[len(myRecords)-1]
+}
+
+func builder_get_next_build_number (theProjectId string) int {
+	myLastRecord := builder_get_last_build_record(theProjectId)
+	if myLastRecord == nil {
+		return 1
+	}
+	return myLastRecord.Number + 1
+}
+
+func builder_append_build_record (theRecord *BuildRecord) error {
+
+	myHistoryFilePath := builder_get_history_filepath(theRecord.ProjectId)
+	myMkdirErr := os.MkdirAll(filepath.Dir(myHistoryFilePath), 0755)
+	if myMkdirErr != nil {
+		return myMkdirErr
+	}
+
+	myRecordBytes, myMarshalErr := json.Marshal(theRecord)
+	if myMarshalErr != nil {
+		return myMarshalErr
+	}
+
+	myHistoryFile, myOpenErr := os.OpenFile(myHistoryFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if myOpenErr != nil {
+		return myOpenErr
+	}
+	defer myHistoryFile.Close()
+
+	_, myWriteErr := myHistoryFile.Write(append(myRecordBytes, '\n'))
+	return myWriteErr
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const kDefaultImageTags = "latest"
+const kDefaultDockerfile = "Dockerfile"
+const kDefaultDockerContext = "."
+const kRegistryCredentialsFileName = "registry.credentials"
+
+var gImageTagRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+type DockerBuildOptions struct {
+    Dockerfile string // relative to the project dir, default : "Dockerfile"
+    Context string // relative to the project dir, default : "."
+    BuildArgs []string // "KEY=VALUE"
+    Target string // target stage, default : last stage
+    Platforms string // buildx platforms, ex : "linux/amd64,linux/arm64"
+    CacheFrom []string // buildx cache sources, ex : "type=registry,ref=localhost:5000/app:cache"
+    CacheTo []string // buildx cache exports
+    Secrets []string // buildkit secrets, ex : "id=npmrc,src=/opt/dev/.npmrc"
+    Labels []string // "KEY=VALUE", in addition to the OCI labels
+    NoCache bool
+}
+
+//------------------------------------------------------------------------------
+
+func builder_run_command (theDirPath string, theName string, theArgs ...string) ([]byte, error) {
+	myCommand := builder_new_job_command(theName, theArgs...)
+	myCommand.Dir = theDirPath
+	myOutputBytes, myCommandErr := myCommand.CombinedOutput()
+	if myCommandErr != nil && (theName == "docker" || theName == "docker-compose") {
+		builder_record_docker_error(theName, theArgs, myCommandErr)
+	}
+	return myOutputBytes, myCommandErr
+}
+
+// Runs a command whose failure is an answer, ex : docker image inspect of a missing image,
+// neither logged nor counted as a docker error.
+func builder_run_probe_command (theDirPath string, theName string, theArgs ...string) ([]byte, error) {
+	myCommand := builder_new_job_command(theName, theArgs...)
+	myCommand.Dir = theDirPath
+	return myCommand.CombinedOutput()
+}
+
+func builder_append_output_lines (theLines []string, theOutputBytes []byte) []string {
+	myOutputLines := bytes.Split(theOutputBytes, []byte("\n"))
+	for _, myOutputLine := range myOutputLines {
+		theLines = append(theLines, string(myOutputLine))
+	}
+	return theLines
+}
+
+func builder_split_setting_list (theSettingValue string) []string {
+	var myItems []string
+	for _, myItem := range strings.Split(theSettingValue, ";") {
+		myItem = strings.TrimSpace(myItem)
+		if myItem != "" {
+			myItems = append(myItems, myItem)
+		}
+	}
+	return myItems
+}
+
+func builder_parse_setting_bool (theSettingValue string) bool {
+	switch strings.ToLower(strings.TrimSpace(theSettingValue)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_git_commit (theDirPath string) string {
+	myOutputBytes, myGitErr := builder_run_command(theDirPath, "git", "rev-parse", "--short", "HEAD")
+	if myGitErr != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(myOutputBytes))
+}
+
+func builder_get_git_tag (theDirPath string) string {
+	myOutputBytes, myGitErr := builder_run_command(theDirPath, "git", "describe", "--tags", "--exact-match", "HEAD")
+	if myGitErr != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(myOutputBytes))
+}
+
+// Expands the ImageTags templates of a project for a given build.
+// Supported placeholders : {commit}, {tag}, {build}, {date}.
+// A tag whose placeholders expand to nothing (no git tag, no commit) is skipped.
+func builder_expand_image_tags (theTemplates []string, theRecord *BuildRecord) []string {
+
+	var myTags []string
+
+	for _, myTemplate := range theTemplates {
+		if strings.Contains(myTemplate, "{commit}") && theRecord.Commit == "" {
+			continue
+		}
+		if strings.Contains(myTemplate, "{tag}") && theRecord.GitTag == "" {
+			continue
+		}
+		myTag := myTemplate
+		myTag = strings.ReplaceAll(myTag, "{commit}", theRecord.Commit)
+		myTag = strings.ReplaceAll(myTag, "{tag}", theRecord.GitTag)
+		myTag = strings.ReplaceAll(myTag, "{build}", strconv.Itoa(theRecord.Number))
+		myTag = strings.ReplaceAll(myTag, "{date}", theRecord.StartedAt.Format("20060102"))
+		if !gImageTagRegexp.MatchString(myTag) {
+			continue
+		}
+		myAlreadyListed := false
+		for _, myListedTag := range myTags {
+			if myListedTag == myTag {
+				myAlreadyListed = true
+			}
+		}
+		if !myAlreadyListed {
+			myTags = append(myTags, myTag)
+		}
+	}
+
+	return myTags
+}
+
+//------------------------------------------------------------------------------
+
+func builder_load_docker_build_options (theSettings map[string]string) DockerBuildOptions {
+
+	myOptions := DockerBuildOptions{Dockerfile: kDefaultDockerfile,
+		Context: kDefaultDockerContext,
+	}
+
+	if theSettings["Dockerfile"] != "" {
+		myOptions.Dockerfile = strings.TrimSpace(theSettings["Dockerfile"])
+	}
+	if theSettings["DockerContext"] != "" {
+		myOptions.Context = strings.TrimSpace(theSettings["DockerContext"])
+	}
+	myOptions.BuildArgs = builder_split_setting_list(theSettings["DockerBuildArgs"])
+	myOptions.Target = strings.TrimSpace(theSettings["DockerTarget"])
+	myOptions.Platforms = strings.TrimSpace(theSettings["DockerPlatforms"])
+	myOptions.CacheFrom = builder_split_setting_list(theSettings["DockerCacheFrom"])
+	myOptions.CacheTo = builder_split_setting_list(theSettings["DockerCacheTo"])
+	myOptions.Secrets = builder_split_setting_list(theSettings["DockerSecrets"])
+	myOptions.Labels = builder_split_setting_list(theSettings["DockerLabels"])
+	myOptions.NoCache = builder_parse_setting_bool(theSettings["DockerNoCache"])
+
+	return myOptions
+}
+
+// buildx is only needed for the features the classic builder lacks.
+func builder_docker_build_needs_buildx (theOptions DockerBuildOptions) bool {
+	return theOptions.Platforms != "" || len(theOptions.CacheFrom) > 0 || len(theOptions.CacheTo) > 0
+}
+
+// Several platforms can't be loaded in the local image store, the images are pushed by buildx itself.
+func builder_docker_build_is_multiplatform (theOptions DockerBuildOptions) bool {
+	return strings.Contains(theOptions.Platforms, ",")
+}
+
+func builder_get_docker_build_args (theProjectId string, theRecord *BuildRecord) []string {
+
+	myProject := builder_get_project(theProjectId)
+	myOptions := myProject.DockerBuild
+	myProjectDirPath := builder_get_project_dirpath(theProjectId)
+
+	var myDockerArgs []string
+	if builder_docker_build_needs_buildx(myOptions) {
+		myDockerArgs = append(myDockerArgs, "buildx", "build")
+	} else {
+		myDockerArgs = append(myDockerArgs, "build")
+	}
+
+	myDockerArgs = append(myDockerArgs, "-f", filepath.Join(myProjectDirPath, myOptions.Dockerfile))
+
+	myRegistry := strings.TrimSuffix(myProject.PushRegistry, "/")
+	for _, myTag := range theRecord.ImageTags {
+		if builder_docker_build_is_multiplatform(myOptions) {
+			myDockerArgs = append(myDockerArgs, "-t", myRegistry+"/"+myProject.ImageName+":"+myTag)
+		} else {
+			myDockerArgs = append(myDockerArgs, "-t", myProject.ImageName+":"+myTag)
+		}
+	}
+
+	for _, myBuildArg := range myOptions.BuildArgs {
+		myDockerArgs = append(myDockerArgs, "--build-arg", myBuildArg)
+	}
+	if myOptions.Target != "" {
+		myDockerArgs = append(myDockerArgs, "--target", myOptions.Target)
+	}
+	if myOptions.Platforms != "" {
+		myDockerArgs = append(myDockerArgs, "--platform", myOptions.Platforms)
+	}
+	for _, myCacheFrom := range myOptions.CacheFrom {
+		myDockerArgs = append(myDockerArgs, "--cache-from", myCacheFrom)
+	}
+	for _, myCacheTo := range myOptions.CacheTo {
+		myDockerArgs = append(myDockerArgs, "--cache-to", myCacheTo)
+	}
+	for _, mySecret := range myOptions.Secrets {
+		myDockerArgs = append(myDockerArgs, "--secret", mySecret)
+	}
+	if myOptions.NoCache {
+		myDockerArgs = append(myDockerArgs, "--no-cache")
+	}
+	// the scheduled builds pick up the updates of the base images
+	if theRecord.Trigger == kTriggerScheduled {
+		myDockerArgs = append(myDockerArgs, "--pull")
+	}
+
+	myDockerArgs = append(myDockerArgs, "--label", "org.opencontainers.image.title="+myProject.ImageName)
+	myDockerArgs = append(myDockerArgs, "--label", "org.opencontainers.image.created="+theRecord.StartedAt.UTC().Format(time.RFC3339))
+	if theRecord.Commit != "" {
+		myDockerArgs = append(myDockerArgs, "--label", "org.opencontainers.image.revision="+theRecord.Commit)
+	}
+	if theRecord.GitTag != "" {
+		myDockerArgs = append(myDockerArgs, "--label", "org.opencontainers.image.version="+theRecord.GitTag)
+	}
+	for _, myLabel := range myOptions.Labels {
+		myDockerArgs = append(myDockerArgs, "--label", myLabel)
+	}
+
+	if builder_docker_build_needs_buildx(myOptions) {
+		if builder_docker_build_is_multiplatform(myOptions) {
+			myDockerArgs = append(myDockerArgs, "--push")
+		} else {
+			myDockerArgs = append(myDockerArgs, "--load")
+		}
+	}
+
+	myDockerArgs = append(myDockerArgs, filepath.Join(myProjectDirPath, myOptions.Context))
+
+	return myDockerArgs
+}
+
+//------------------------------------------------------------------------------
+
+// Registry credentials are kept in the data dir, never in builder.settings.
+// One line per registry : "localhost:5000=user:password".
+func builder_load_registry_credentials (theRegistryHost string) (string, string) {
+	myCredentialsFilePath := filepath.Join(gDataDirPath, kRegistryCredentialsFileName)
+	myCredentials := builder_load_settings_file(myCredentialsFilePath)
+	myUserPassword, myCredentialsExist := myCredentials[theRegistryHost]
+	if !myCredentialsExist {
+		return "", ""
+	}
+	myUserPasswordParts := strings.SplitN(strings.TrimSpace(myUserPassword), ":", 2)
+	if len(myUserPasswordParts) != 2 {
+		return "", ""
+	}
+	return myUserPasswordParts[0], myUserPasswordParts[1]
+}
+
+func builder_docker_login (theRegistryHost string) []string {
+
+	var myReturnLines []string
+
+	myUser, myPassword := builder_load_registry_credentials(theRegistryHost)
+	if myUser == "" {
+		return myReturnLines
+	}
+
+	myLoginCommand := builder_new_job_command("docker", "login", "--username", myUser, "--password-stdin", theRegistryHost)
+	myLoginCommand.Stdin = strings.NewReader(myPassword)
+	myLoginOutputBytes, myLoginErr := myLoginCommand.CombinedOutput()
+	if myLoginErr != nil {
+		builder_record_docker_error("docker", []string{"login"}, myLoginErr)
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker login to %s failed : %v", theRegistryHost, myLoginErr))
+	} else {
+		myReturnLines = append(myReturnLines, "Docker login OK for "+theRegistryHost)
+	}
+	myReturnLines = builder_append_output_lines(myReturnLines, myLoginOutputBytes)
+
+	return myReturnLines
+}
+
+func builder_push_project_image (theProjectId string, theRecord *BuildRecord) []string {
+
+	var myReturnLines []string
+
+	myRegistry := strings.TrimSuffix(builder_get_project(theProjectId).PushRegistry, "/")
+	if myRegistry == "" {
+		return myReturnLines
+	}
+	myRegistryHost := strings.SplitN(myRegistry, "/", 2)[0]
+
+	myReturnLines = append(myReturnLines, builder_docker_login(myRegistryHost)...)
+
+	myImageName := builder_get_project(theProjectId).ImageName
+	for _, myTag := range theRecord.ImageTags {
+		myLocalRef := myImageName+":"+myTag
+		myRemoteRef := myRegistry+"/"+myImageName+":"+myTag
+
+		myTagOutputBytes, myTagErr := builder_run_command("", "docker", "tag", myLocalRef, myRemoteRef)
+		if myTagErr != nil {
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Docker tag %s failed : %v", myRemoteRef, myTagErr))
+			myReturnLines = builder_append_output_lines(myReturnLines, myTagOutputBytes)
+			continue
+		}
+
+		myReturnLines = append(myReturnLines, "Docker push : "+myRemoteRef)
+		myPushOutputBytes, myPushErr := builder_run_command("", "docker", "push", myRemoteRef)
+		if myPushErr != nil {
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Docker push %s failed : %v", myRemoteRef, myPushErr))
+		} else {
+			theRecord.PushedTags = append(theRecord.PushedTags, myRemoteRef)
+		}
+		myReturnLines = builder_append_output_lines(myReturnLines, myPushOutputBytes)
+	}
+
+	if len(theRecord.PushedTags) != len(theRecord.ImageTags) {
+		theRecord.Result = "failed"
+	}
+
+	return myReturnLines
+}
+
+func builder_build_project_image (theProjectId string, theRecord *BuildRecord) []string {
+
+	var myReturnLines []string
+
+	myProject := builder_get_project(theProjectId)
+	myProjectDirPath := builder_get_project_dirpath(theProjectId)
+	myDockerfilePath := filepath.Join(myProjectDirPath, myProject.DockerBuild.Dockerfile)
+	_, myDockerfileStatErr := os.Stat(myDockerfilePath)
+	if myDockerfileStatErr != nil {
+		return myReturnLines
+	}
+
+	theRecord.ImageTags = builder_expand_image_tags(myProject.ImageTags, theRecord)
+	if len(theRecord.ImageTags) == 0 {
+		theRecord.ImageTags = []string{kDefaultImageTags}
+	}
+
+	myMultiPlatform := builder_docker_build_is_multiplatform(myProject.DockerBuild)
+	if myMultiPlatform && myProject.PushRegistry == "" {
+		theRecord.Result = "failed"
+		theRecord.ImageTags = nil
+		myReturnLines = append(myReturnLines, "Docker build failed : several platforms require a PushRegistry")
+		return myReturnLines
+	}
+	if myMultiPlatform {
+		myRegistryHost := strings.SplitN(myProject.PushRegistry, "/", 2)[0]
+		myReturnLines = append(myReturnLines, builder_docker_login(myRegistryHost)...)
+	}
+
+	myDockerArgs := builder_get_docker_build_args(theProjectId, theRecord)
+
+	myReturnLines = append(myReturnLines, "Docker image BuildCommand : docker "+strings.Join(myDockerArgs, " "))
+	myBuildCommand := builder_new_job_command("docker", myDockerArgs...)
+	myBuildCommand.Dir = myProjectDirPath
+	myBuildCommand.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	myBuildOutputBytes, myBuildErr := myBuildCommand.CombinedOutput()
+	if myBuildErr != nil {
+		builder_record_docker_error("docker", myDockerArgs, myBuildErr)
+		theRecord.Result = "failed"
+		theRecord.ImageTags = nil
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker build failed : %v", myBuildErr))
+		myReturnLines = builder_append_output_lines(myReturnLines, myBuildOutputBytes)
+		return myReturnLines
+	}
+	myReturnLines = append(myReturnLines, fmt.Sprintf("Docker build OK for %s (tags : %s)", theProjectId, strings.Join(theRecord.ImageTags, ", ")))
+	myReturnLines = builder_append_output_lines(myReturnLines, myBuildOutputBytes)
+
+	if myMultiPlatform {
+		myRegistry := strings.TrimSuffix(myProject.PushRegistry, "/")
+		for _, myTag := range theRecord.ImageTags {
+			theRecord.PushedTags = append(theRecord.PushedTags, myRegistry+"/"+myProject.ImageName+":"+myTag)
+		}
+		return myReturnLines
+	}
+
+	myReturnLines = append(myReturnLines, builder_register_project_image_version(theProjectId, theRecord)...)
+	myReturnLines = append(myReturnLines, builder_push_project_image(theProjectId, theRecord)...)
+
+	return myReturnLines
+}
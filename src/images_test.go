@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuilderExpandImageTags (theTest *testing.T) {
+
+	myRecord := BuildRecord{Number: 42, Commit: "abc1234", GitTag: "v1.2.0", StartedAt: time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)}
+	myUntaggedRecord := BuildRecord{Number: 7, StartedAt: time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC)}
+
+	myTestCases := []struct {
+		Templates []string
+		Record BuildRecord
+		Tags []string
+	}{
+		{Templates: []string{"latest"}, Record: myRecord, Tags: []string{"latest"}},
+		{Templates: []string{"latest", "{build}", "{commit}", "{tag}", "{date}"}, Record: myRecord, Tags: []string{"latest", "42", "abc1234", "v1.2.0", "20240315"}},
+		{Templates: []string{"build-{build}-{commit}", "{date}.{build}"}, Record: myRecord, Tags: []string{"build-42-abc1234", "20240315.42"}},
+		// no commit nor git tag : their templates are skipped
+		{Templates: []string{"latest", "{commit}", "{tag}", "{build}"}, Record: myUntaggedRecord, Tags: []string{"latest", "7"}},
+		// duplicates are listed once, invalid tags are skipped
+		{Templates: []string{"latest", "latest", "{build}", "42"}, Record: myRecord, Tags: []string{"latest", "42"}},
+		{Templates: []string{"bad tag", "-{build}", "{build}"}, Record: myRecord, Tags: []string{"42"}},
+		{Templates: nil, Record: myRecord, Tags: nil},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myTags := builder_expand_image_tags(myTestCase.Templates, &myTestCase.Record)
+		if !reflect.DeepEqual(myTags, myTestCase.Tags) {
+			theTest.Errorf("%q : %q, expected %q", myTestCase.Templates, myTags, myTestCase.Tags)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const kJobsDirName = "jobs"
+
+// Pending or running job, saved in the data dir until it ends,
+// so that the jobs interrupted by a stop of the builder are known at the next start.
+type JobRecord struct {
+    ProjectId string
+    Job string // "build", "refresh", "up", "down", "deploy", "prefetch"
+    DeployBuild int
+    Trigger string
+    State string // "pending", "running"
+    BuildNumber int // number of the running build
+    StartedAt time.Time
+}
+
+var gRunningJobs = 0 // guarded by gProjectsMutex
+
+//------------------------------------------------------------------------------
+
+func builder_get_job_record_filepath (theProjectId string) string {
+	return filepath.Join(gDataDirPath, kJobsDirName, theProjectId+".json")
+}
+
+func builder_save_job_record (theJobRecord JobRecord) {
+
+	myJobRecordFilePath := builder_get_job_record_filepath(theJobRecord.ProjectId)
+	myMkdirErr := os.MkdirAll(filepath.Dir(myJobRecordFilePath), 0755)
+	if myMkdirErr != nil {
+		gLogger.Error("job record not saved", "project", theJobRecord.ProjectId, "error", myMkdirErr)
+		return
+	}
+	myJobRecordBytes, _ := json.Marshal(theJobRecord)
+	myWriteErr := os.WriteFile(myJobRecordFilePath, myJobRecordBytes, 0644)
+	if myWriteErr != nil {
+		gLogger.Error("job record not saved", "project", theJobRecord.ProjectId, "error", myWriteErr)
+	}
+}
+
+func builder_remove_job_record (theProjectId string) {
+	os.Remove(builder_get_job_record_filepath(theProjectId))
+}
+
+//------------------------------------------------------------------------------
+
+// Marks a job ("build", "refresh", "up", "down", "deploy", "prefetch") as pending, unless the project is busy or the builder is stopping.
+func builder_queue_project_job (theProjectId string, theJob string, theDeployBuild int, theTrigger string) bool {
+
+	gProjectsMutex.Lock()
+	defer gProjectsMutex.Unlock()
+
+	myProject, myProjectExists := gProjects[theProjectId]
+	if !myProjectExists || myProject.Status != "" || gShuttingDown {
+		return false
+	}
+	if theJob == "deploy" {
+		myProject.DeployBuild = theDeployBuild
+	}
+	myProject.JobTrigger = theTrigger
+	myProject.Status = theJob+"-pending"
+	gLogger.Info("job queued", "project", theProjectId, "job", theJob, "trigger", theTrigger)
+	builder_save_job_record(JobRecord{ProjectId: theProjectId, Job: theJob, DeployBuild: theDeployBuild, Trigger: theTrigger, State: "pending"})
+	return true
+}
+
+func builder_set_project_job_result (theProjectId string, theOutputLines []string, theRecord *BuildRecord) {
+
+	builder_invalidate_dashboard_cache(theProjectId)
+
+	gProjectsMutex.Lock()
+	defer gProjectsMutex.Unlock()
+
+	gRunningJobs--
+	// interrupted jobs keep their record for the next start
+	if gJobsContext.Err() == nil {
+		builder_remove_job_record(theProjectId)
+	}
+	myProject, myProjectExists := gProjects[theProjectId]
+	if !myProjectExists {
+		return
+	}
+	if theRecord != nil {
+		myProject.LastBuild = theRecord
+	}
+	myProject.BuildOutput = strings.Join(theOutputLines, "\n")
+	myProject.Status = ""
+}
+
+func builder_run_project_job (theProjectId string, theJob string, theDeployBuild int, theTrigger string) {
+
+	myJobRecord := JobRecord{ProjectId: theProjectId, Job: theJob, DeployBuild: theDeployBuild, Trigger: theTrigger, State: "running", StartedAt: time.Now()}
+
+	// one copy of the project for the whole job, the projects are registered again meanwhile
+	myProject := builder_get_project(theProjectId)
+	if myProject == nil {
+		gLogger.Error("job of an unregistered project", "project", theProjectId, "job", theJob)
+		builder_set_project_job_result(theProjectId, []string{"Project not registered : "+theProjectId}, nil)
+		return
+	}
+
+	switch theJob {
+
+	// a refresh is a build updating the dependencies first
+	case "build", "refresh":
+		myRecord := BuildRecord{ProjectId: theProjectId,
+			Number: builder_get_next_build_number(theProjectId),
+			StartedAt: myJobRecord.StartedAt,
+			Trigger: theTrigger,
+			Refresh: theJob == "refresh",
+		}
+		myJobRecord.BuildNumber = myRecord.Number
+		builder_save_job_record(myJobRecord)
+		gLogger.Info("job started", "project", theProjectId, "job", theJob, "trigger", theTrigger, "build", myRecord.Number)
+		myOutputLines := builder_build_project(myProject, &myRecord)
+		myRecord.FinishedAt = time.Now()
+		if gJobsContext.Err() != nil {
+			myRecord.Result = "interrupted"
+			myOutputLines = append(myOutputLines, "Build interrupted by the builder stop")
+		}
+		myRecordErr := builder_append_build_record(&myRecord)
+		if myRecordErr != nil {
+			myOutputLines = append(myOutputLines, fmt.Sprintf("Build history update failed : %v", myRecordErr))
+		}
+		gLogger.Info("job finished", "project", theProjectId, "job", theJob, "build", myRecord.Number, "result", myRecord.Result, "reason", myRecord.FailureReason, "duration", myRecord.FinishedAt.Sub(myRecord.StartedAt))
+		builder_record_build_metrics(&myRecord)
+		builder_set_project_job_result(theProjectId, myOutputLines, &myRecord)
+		if myRecord.Result != "interrupted" {
+			builder_notify_job_result(theProjectId, "build", myRecord.Result, myOutputLines, &myRecord)
+		}
+
+	case "up", "down", "deploy":
+		builder_save_job_record(myJobRecord)
+		if theJob == "deploy" {
+			gLogger.Info("job started", "project", theProjectId, "job", theJob, "trigger", theTrigger, "build", theDeployBuild)
+		} else {
+			gLogger.Info("job started", "project", theProjectId, "job", theJob, "trigger", theTrigger)
+		}
+		var myOutputLines []string
+		var myComposeErr error
+		switch theJob {
+		case "up":
+			myOutputLines, myComposeErr = builder_docker_compose_up(myProject, false)
+		case "down":
+			myOutputLines, myComposeErr = builder_docker_compose_down(myProject)
+		case "deploy":
+			myOutputLines, myComposeErr = builder_deploy_project_version(myProject, theDeployBuild)
+		}
+		builder_set_project_job_result(theProjectId, myOutputLines, nil)
+		myResult := "ok"
+		if myComposeErr != nil {
+			myResult = "failed"
+			gLogger.Warn("docker-compose failed", "project", theProjectId, "job", theJob, "error", myComposeErr)
+		}
+		gLogger.Info("job finished", "project", theProjectId, "job", theJob, "result", myResult, "duration", time.Since(myJobRecord.StartedAt))
+		builder_record_compose_metrics(theProjectId, theJob, myResult)
+		if gJobsContext.Err() == nil {
+			builder_notify_job_result(theProjectId, theJob, myResult, myOutputLines, nil)
+		}
+
+	case "prefetch":
+		builder_save_job_record(myJobRecord)
+		gLogger.Info("job started", "project", theProjectId, "job", theJob, "trigger", theTrigger)
+		builder_set_project_job_result(theProjectId, builder_prefetch_project_deps(theProjectId), nil)
+		gLogger.Info("job finished", "project", theProjectId, "job", theJob, "duration", time.Since(myJobRecord.StartedAt))
+
+	default:
+		gLogger.Error("unknown job", "project", theProjectId, "job", theJob)
+		builder_set_project_job_result(theProjectId, []string{"Unknown job : "+theJob}, nil)
+	}
+}
+
+// Cancels the pending jobs once the builder is stopping : their projects go back to idle, and the
+// pending builds get a "cancelled" history record. With RequeueInterrupted, their job records stay
+// for the next start, which queues them again.
+func builder_cancel_pending_jobs () {
+
+	var myCancelledJobs []JobRecord
+	gProjectsMutex.Lock()
+	for _, myProjectId := range gOrderedProjectIds {
+		myProject := gProjects[myProjectId]
+		if strings.HasSuffix(myProject.Status, "-pending") {
+			myJob := strings.TrimSuffix(myProject.Status, "-pending")
+			myProject.Status = ""
+			myProject.BuildOutput = "Job "+myJob+" cancelled by the builder stop"
+			myCancelledJobs = append(myCancelledJobs, JobRecord{ProjectId: myProjectId, Job: myJob, Trigger: myProject.JobTrigger})
+		}
+	}
+	gProjectsMutex.Unlock()
+
+	for _, myCancelledJob := range myCancelledJobs {
+		gLogger.Warn("job cancelled by the stop", "project", myCancelledJob.ProjectId, "job", myCancelledJob.Job)
+		if !gServerConfig.RequeueInterrupted {
+			builder_remove_job_record(myCancelledJob.ProjectId)
+		}
+		if myCancelledJob.Job != "build" && myCancelledJob.Job != "refresh" {
+			continue
+		}
+		myRecord := BuildRecord{ProjectId: myCancelledJob.ProjectId,
+			Number: builder_get_next_build_number(myCancelledJob.ProjectId),
+			StartedAt: time.Now(),
+			FinishedAt: time.Now(),
+			Result: "cancelled",
+			Trigger: myCancelledJob.Trigger,
+			Refresh: myCancelledJob.Job == "refresh",
+		}
+		myRecordErr := builder_append_build_record(&myRecord)
+		if myRecordErr != nil {
+			gLogger.Error("build history update failed", "project", myCancelledJob.ProjectId, "error", myRecordErr)
+			continue
+		}
+		gProjectsMutex.Lock()
+		myProject, myProjectExists := gProjects[myCancelledJob.ProjectId]
+		if myProjectExists {
+			myProject.LastBuild = &myRecord
+		}
+		gProjectsMutex.Unlock()
+	}
+}
+
+// Starts the pending jobs, at most gServerConfig.Workers at the same time.
+// No job starts once the builder is stopping, or while a Go cache is cleared.
+func builder_run_scheduler () {
+	for {
+		gProjectsMutex.Lock()
+		for _, myProjectId := range gOrderedProjectIds {
+			if gRunningJobs >= gServerConfig.Workers || gShuttingDown || gGoCacheClearing {
+				break
+			}
+			myProject := gProjects[myProjectId]
+			if strings.HasSuffix(myProject.Status, "-pending") {
+				myJob := strings.TrimSuffix(myProject.Status, "-pending")
+				myProject.Status = myJob+"-running"
+				gRunningJobs++
+				go builder_run_project_job(myProjectId, myJob, myProject.DeployBuild, myProject.JobTrigger)
+			}
+		}
+		gProjectsMutex.Unlock()
+		time.Sleep(gServerConfig.PollInterval)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Records the jobs left by the last stop of the builder : the running builds get an "interrupted"
+// history record, unless the stop already wrote it, and every job is queued again with RequeueInterrupted.
+func builder_recover_interrupted_jobs () {
+
+	myJobsDirPath := filepath.Join(gDataDirPath, kJobsDirName)
+	myDirEntries, myReadDirErr := os.ReadDir(myJobsDirPath)
+	if myReadDirErr != nil {
+		return
+	}
+
+	for _, myDirEntry := range myDirEntries {
+		if myDirEntry.IsDir() || !strings.HasSuffix(myDirEntry.Name(), ".json") {
+			continue
+		}
+		myJobRecordFilePath := filepath.Join(myJobsDirPath, myDirEntry.Name())
+		myJobRecordBytes, myReadErr := os.ReadFile(myJobRecordFilePath)
+		var myJobRecord JobRecord
+		if myReadErr != nil || json.Unmarshal(myJobRecordBytes, &myJobRecord) != nil || myJobRecord.ProjectId == "" {
+			os.Remove(myJobRecordFilePath)
+			continue
+		}
+		builder_remove_job_record(myJobRecord.ProjectId)
+
+		_, myProjectExists := gProjects[myJobRecord.ProjectId]
+		if !myProjectExists {
+			continue
+		}
+
+		if myJobRecord.State == "running" {
+			gLogger.Warn("job interrupted by the last stop", "project", myJobRecord.ProjectId, "job", myJobRecord.Job)
+			if myJobRecord.Job == "build" || myJobRecord.Job == "refresh" {
+				myLastRecord := builder_get_last_build_record(myJobRecord.ProjectId)
+				if myLastRecord == nil || myLastRecord.Number < myJobRecord.BuildNumber {
+					myRecord := BuildRecord{ProjectId: myJobRecord.ProjectId,
+						Number: myJobRecord.BuildNumber,
+						StartedAt: myJobRecord.StartedAt,
+						FinishedAt: myJobRecord.StartedAt,
+						Result: "interrupted",
+						Trigger: myJobRecord.Trigger,
+						Refresh: myJobRecord.Job == "refresh",
+					}
+					myFileInfo, myStatErr := myDirEntry.Info()
+					if myStatErr == nil {
+						myRecord.FinishedAt = myFileInfo.ModTime()
+					}
+					myRecordErr := builder_append_build_record(&myRecord)
+					if myRecordErr != nil {
+						gLogger.Error("build history update failed", "project", myJobRecord.ProjectId, "error", myRecordErr)
+					}
+					gProjects[myJobRecord.ProjectId].LastBuild = &myRecord
+				}
+			}
+			gProjects[myJobRecord.ProjectId].BuildOutput = "Job "+myJobRecord.Job+" interrupted by the builder stop"
+		}
+
+		if gServerConfig.RequeueInterrupted {
+			gLogger.Info("job queued again", "project", myJobRecord.ProjectId, "job", myJobRecord.Job)
+			builder_queue_project_job(myJobRecord.ProjectId, myJobRecord.Job, myJobRecord.DeployBuild, "requeue")
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kCgroupCPUPeriod = 100000 // microseconds, cpu.max quota of one core
+
+// Failure reasons of the builds breaking a limit.
+const kFailureTimeLimit = "time limit"
+const kFailureMemoryLimit = "memory limit"
+const kFailureOutputLimit = "output limit"
+
+type BuildLimits struct {
+    CPU float64 // cores, 0 = no limit
+    Memory int64 // bytes, 0 = no limit
+    Time time.Duration // wall time of the build command, 0 = no limit
+    Output int64 // bytes of build command output, 0 = no limit
+}
+
+// Output of a build command, the command is cancelled once it writes more than Limit bytes.
+type LimitedOutputWriter struct {
+    Buffer bytes.Buffer
+    Limit int64 // 0 = no limit
+    Exceeded bool
+    Cancel context.CancelFunc
+    Mutex sync.Mutex // stdout and stderr are written concurrently
+}
+
+var gBuildCgroupsOnce sync.Once
+var gBuildCgroupsDirPath string
+var gBuildCgroupsErr error
+
+//------------------------------------------------------------------------------
+
+func (theWriter *LimitedOutputWriter) Write (theBytes []byte) (int, error) {
+
+	theWriter.Mutex.Lock()
+	defer theWriter.Mutex.Unlock()
+
+	if theWriter.Exceeded {
+		return len(theBytes), nil
+	}
+	if theWriter.Limit > 0 && int64(theWriter.Buffer.Len()+len(theBytes)) > theWriter.Limit {
+		theWriter.Buffer.Write(theBytes[:theWriter.Limit-int64(theWriter.Buffer.Len())])
+		theWriter.Buffer.WriteString(fmt.Sprintf("\n[output truncated at %s]\n", builder_format_size(theWriter.Limit)))
+		theWriter.Exceeded = true
+		theWriter.Cancel()
+		return len(theBytes), nil
+	}
+	return theWriter.Buffer.Write(theBytes)
+}
+
+//------------------------------------------------------------------------------
+
+// Parses a size, ex : "512m", "2G", "100kb", "1048576".
+func builder_parse_size (theSize string) (int64, error) {
+
+	mySize := strings.ToLower(strings.TrimSpace(theSize))
+	mySize = strings.TrimSuffix(mySize, "b")
+	myMultiplier := int64(1)
+	if mySize != "" {
+		mySuffixMultiplier, myHasSuffix := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}[mySize[len(mySize)-1]]
+		if myHasSuffix {
+			mySize = mySize[:len(mySize)-1]
+			myMultiplier = mySuffixMultiplier
+		}
+	}
+	myValue, myParseErr := strconv.ParseInt(mySize, 10, 64)
+	if myParseErr != nil || myValue <= 0 || myValue > math.MaxInt64/myMultiplier {
+		return 0, fmt.Errorf("positive size expected, ex : 512m, got \"%s\"", theSize)
+	}
+	return myValue * myMultiplier, nil
+}
+
+// Returns one message per invalid limit setting, empty values are valid (no limit).
+func builder_validate_build_limits (theValues map[string]string) []string {
+
+	var myErrors []string
+
+	myCPU := strings.TrimSpace(theValues["LimitCPU"])
+	if myCPU != "" {
+		myCPUCores, myParseErr := strconv.ParseFloat(myCPU, 64)
+		if myParseErr != nil || myCPUCores < 0.01 {
+			myErrors = append(myErrors, "LimitCPU : number of cores expected, ex : 2 or 0.5")
+		}
+	}
+	for _, mySizeKey := range []string{"LimitMemory", "LimitOutput"} {
+		mySize := strings.TrimSpace(theValues[mySizeKey])
+		if mySize != "" {
+			_, mySizeErr := builder_parse_size(mySize)
+			if mySizeErr != nil {
+				myErrors = append(myErrors, mySizeKey+" : "+mySizeErr.Error())
+			}
+		}
+	}
+	myTime := strings.TrimSpace(theValues["LimitTime"])
+	if myTime != "" {
+		myDuration, myParseErr := time.ParseDuration(myTime)
+		if myParseErr != nil || myDuration <= 0 {
+			myErrors = append(myErrors, "LimitTime : positive duration expected, ex : 10m")
+		}
+	}
+
+	return myErrors
+}
+
+// Invalid values are ignored, as no limit.
+func builder_load_build_limits (theSettings map[string]string) BuildLimits {
+
+	var myLimits BuildLimits
+
+	myCPUCores, myParseErr := strconv.ParseFloat(strings.TrimSpace(theSettings["LimitCPU"]), 64)
+	if myParseErr == nil && myCPUCores >= 0.01 {
+		myLimits.CPU = myCPUCores
+	}
+	myMemory, mySizeErr := builder_parse_size(theSettings["LimitMemory"])
+	if mySizeErr == nil {
+		myLimits.Memory = myMemory
+	}
+	myOutput, mySizeErr := builder_parse_size(theSettings["LimitOutput"])
+	if mySizeErr == nil {
+		myLimits.Output = myOutput
+	}
+	myDuration, myDurationErr := time.ParseDuration(strings.TrimSpace(theSettings["LimitTime"]))
+	if myDurationErr == nil && myDuration > 0 {
+		myLimits.Time = myDuration
+	}
+
+	return myLimits
+}
+
+func builder_get_build_limits_text (theLimits BuildLimits) string {
+	var myLimitTexts []string
+	if theLimits.CPU > 0 {
+		myLimitTexts = append(myLimitTexts, fmt.Sprintf("cpu %g", theLimits.CPU))
+	}
+	if theLimits.Memory > 0 {
+		myLimitTexts = append(myLimitTexts, "memory "+builder_format_size(theLimits.Memory))
+	}
+	if theLimits.Time > 0 {
+		myLimitTexts = append(myLimitTexts, "time "+theLimits.Time.String())
+	}
+	if theLimits.Output > 0 {
+		myLimitTexts = append(myLimitTexts, "output "+builder_format_size(theLimits.Output))
+	}
+	return strings.Join(myLimitTexts, ", ")
+}
+
+//------------------------------------------------------------------------------
+
+// Returns the cgroup v2 dir under which every build gets its own cgroup, set up on first use.
+func builder_get_build_cgroups_dirpath () (string, error) {
+	gBuildCgroupsOnce.Do(func() {
+		gBuildCgroupsDirPath, gBuildCgroupsErr = builder_init_build_cgroups()
+	})
+	return gBuildCgroupsDirPath, gBuildCgroupsErr
+}
+
+// Enables the cpu and memory controllers for the children of BuildCgroupDir. Without BuildCgroupDir
+// the builds get no cgroup : the cgroup of the builder belongs to its service manager, not to the builder.
+// A cgroup holding processes can't enable controllers for its children : its processes are first moved to a "builder" leaf.
+func builder_init_build_cgroups () (string, error) {
+
+	myCgroupsDirPath := gServerConfig.BuildCgroupDir
+	if myCgroupsDirPath == "" {
+		gLogger.Warn("no cgroup for the builds, BuildCgroupDir not set, LimitCPU and LimitMemory not enforced by a cgroup")
+		return "", fmt.Errorf("BuildCgroupDir not set")
+	}
+
+	myControllersBytes, myReadErr := os.ReadFile(filepath.Join(myCgroupsDirPath, "cgroup.controllers"))
+	if myReadErr != nil {
+		return "", fmt.Errorf("no cgroup v2 in %s", myCgroupsDirPath)
+	}
+	myControllers := strings.Fields(string(myControllersBytes))
+	for _, myController := range []string{"cpu", "memory"} {
+		if !builder_contains_string(myControllers, myController) {
+			return "", fmt.Errorf("%s controller not available in %s", myController, myCgroupsDirPath)
+		}
+	}
+
+	mySubtreeControlBytes, _ := os.ReadFile(filepath.Join(myCgroupsDirPath, "cgroup.subtree_control"))
+	mySubtreeControllers := strings.Fields(string(mySubtreeControlBytes))
+	if builder_contains_string(mySubtreeControllers, "cpu") && builder_contains_string(mySubtreeControllers, "memory") {
+		return myCgroupsDirPath, nil
+	}
+
+	myProcsBytes, _ := os.ReadFile(filepath.Join(myCgroupsDirPath, "cgroup.procs"))
+	myProcessIds := strings.Fields(string(myProcsBytes))
+	if len(myProcessIds) > 0 {
+		myLeafDirPath := filepath.Join(myCgroupsDirPath, "builder")
+		myMkdirErr := os.Mkdir(myLeafDirPath, 0755)
+		if myMkdirErr != nil && !os.IsExist(myMkdirErr) {
+			return "", myMkdirErr
+		}
+		for _, myProcessId := range myProcessIds {
+			myMoveErr := os.WriteFile(filepath.Join(myLeafDirPath, "cgroup.procs"), []byte(myProcessId), 0644)
+			if myMoveErr != nil && !os.IsNotExist(myMoveErr) {
+				return "", fmt.Errorf("moving the builder processes to %s : %v", myLeafDirPath, myMoveErr)
+			}
+		}
+	}
+	myEnableErr := os.WriteFile(filepath.Join(myCgroupsDirPath, "cgroup.subtree_control"), []byte("+cpu +memory"), 0644)
+	if myEnableErr != nil {
+		return "", fmt.Errorf("enabling the cpu and memory controllers in %s : %v", myCgroupsDirPath, myEnableErr)
+	}
+
+	return myCgroupsDirPath, nil
+}
+
+func builder_contains_string (theValues []string, theValue string) bool {
+	for _, myValue := range theValues {
+		if myValue == theValue {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates the cgroup of a build with its cpu and memory limits, opened for SysProcAttr.CgroupFD.
+func builder_create_build_cgroup (theProjectId string, theBuildNumber int, theLimits BuildLimits) (string, *os.File, error) {
+
+	myCgroupsDirPath, myCgroupsErr := builder_get_build_cgroups_dirpath()
+	if myCgroupsErr != nil {
+		return "", nil, myCgroupsErr
+	}
+
+	myCgroupDirPath := filepath.Join(myCgroupsDirPath, "build-"+gDockerNameUnsafeRegexp.ReplaceAllString(theProjectId, "_")+"-"+strconv.Itoa(theBuildNumber))
+	myMkdirErr := os.Mkdir(myCgroupDirPath, 0755)
+	if myMkdirErr != nil && !os.IsExist(myMkdirErr) {
+		return "", nil, myMkdirErr
+	}
+
+	var myWriteErr error
+	if theLimits.CPU > 0 {
+		myWriteErr = os.WriteFile(filepath.Join(myCgroupDirPath, "cpu.max"), []byte(fmt.Sprintf("%d %d", int64(theLimits.CPU*kCgroupCPUPeriod), kCgroupCPUPeriod)), 0644)
+	}
+	if myWriteErr == nil && theLimits.Memory > 0 {
+		myWriteErr = os.WriteFile(filepath.Join(myCgroupDirPath, "memory.max"), []byte(strconv.FormatInt(theLimits.Memory, 10)), 0644)
+		// no swap, so that the limit is the memory used, swap accounting may be disabled
+		os.WriteFile(filepath.Join(myCgroupDirPath, "memory.swap.max"), []byte("0"), 0644)
+	}
+	if myWriteErr != nil {
+		os.Remove(myCgroupDirPath)
+		return "", nil, myWriteErr
+	}
+
+	myCgroupDir, myOpenErr := os.Open(myCgroupDirPath)
+	if myOpenErr != nil {
+		os.Remove(myCgroupDirPath)
+		return "", nil, myOpenErr
+	}
+	return myCgroupDirPath, myCgroupDir, nil
+}
+
+// Kills what is left in the cgroup of a build and removes it, tells whether the memory limit killed a process.
+func builder_remove_build_cgroup (theCgroupDirPath string) bool {
+
+	myOOMKilled := false
+	myEventsBytes, _ := os.ReadFile(filepath.Join(theCgroupDirPath, "memory.events"))
+	for _, myEventLine := range strings.Split(string(myEventsBytes), "\n") {
+		myFields := strings.Fields(myEventLine)
+		if len(myFields) == 2 && myFields[0] == "oom_kill" && myFields[1] != "0" {
+			myOOMKilled = true
+		}
+	}
+
+	os.WriteFile(filepath.Join(theCgroupDirPath, "cgroup.kill"), []byte("1"), 0644)
+	for myAttempt := 0; myAttempt < 50; myAttempt++ {
+		if os.Remove(theCgroupDirPath) == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return myOOMKilled
+}
+
+//------------------------------------------------------------------------------
+
+// Context of a build command, cancelled past the time limit, or by gJobsContext.
+func builder_new_build_context (theLimits BuildLimits) (context.Context, context.CancelFunc) {
+	if theLimits.Time > 0 {
+		return context.WithTimeout(gJobsContext, theLimits.Time)
+	}
+	return context.WithCancel(gJobsContext)
+}
+
+// Runs a build command created with the build context, cancelled once it breaks the time or output limit.
+// Returns its output and the limit it broke, if any.
+func builder_run_limited_command (theCommand *exec.Cmd, theContext context.Context, theCancel context.CancelFunc, theLimits BuildLimits) ([]byte, string, error) {
+
+	myOutputWriter := &LimitedOutputWriter{Limit: theLimits.Output, Cancel: theCancel}
+	theCommand.Stdout = myOutputWriter
+	theCommand.Stderr = myOutputWriter
+	myRunErr := theCommand.Run()
+
+	myFailureReason := ""
+	if myOutputWriter.Exceeded {
+		myFailureReason = kFailureOutputLimit
+	} else if theContext.Err() == context.DeadlineExceeded && gJobsContext.Err() == nil {
+		myFailureReason = kFailureTimeLimit
+	}
+	return myOutputWriter.Buffer.Bytes(), myFailureReason, myRunErr
+}
+
+// Runs the build command of a project on the builder host, within the limits of the project :
+// cpu and memory with a cgroup v2 when possible, else the memory only with ulimit.
+func builder_run_host_build (theProjectId string, theRecord *BuildRecord, theSrcDirPath string) ([]string, []byte, error) {
+
+	var myReturnLines []string
+
+	myProject := builder_get_project(theProjectId)
+	myLimits := myProject.Limits
+	myShellCommandLine := "cd "+theSrcDirPath+" && "+myProject.BuildCommand
+
+	myCgroupDirPath := ""
+	var myCgroupDir *os.File
+	if myLimits.CPU > 0 || myLimits.Memory > 0 {
+		var myCgroupErr error
+		myCgroupDirPath, myCgroupDir, myCgroupErr = builder_create_build_cgroup(theProjectId, theRecord.Number, myLimits)
+		if myCgroupErr != nil {
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Cgroup limits unavailable : %v", myCgroupErr))
+			if myLimits.Memory > 0 {
+				myShellCommandLine = fmt.Sprintf("ulimit -v %d && ", myLimits.Memory/1024)+myShellCommandLine
+				myReturnLines = append(myReturnLines, "Memory limited with ulimit -v, a breach fails the build without memory limit reason")
+			}
+			if myLimits.CPU > 0 {
+				myReturnLines = append(myReturnLines, "LimitCPU not enforced")
+			}
+		} else {
+			defer myCgroupDir.Close()
+		}
+	}
+
+	myContext, myCancel := builder_new_build_context(myLimits)
+	defer myCancel()
+	myBuildCommand := builder_new_job_command_context(myContext, "/bin/sh", "-c", myShellCommandLine)
+	myBuildCommand.Env = append(os.Environ(), builder_get_go_env(theProjectId)...)
+	if myCgroupDir != nil {
+		myBuildCommand.SysProcAttr.UseCgroupFD = true
+		myBuildCommand.SysProcAttr.CgroupFD = int(myCgroupDir.Fd())
+	}
+	myBuildOutputBytes, myFailureReason, myBuildErr := builder_run_limited_command(myBuildCommand, myContext, myCancel, myLimits)
+	if myCgroupDir != nil && builder_remove_build_cgroup(myCgroupDirPath) && myBuildErr != nil && myFailureReason == "" {
+		myFailureReason = kFailureMemoryLimit
+	}
+	theRecord.FailureReason = myFailureReason
+
+	return myReturnLines, myBuildOutputBytes, myBuildErr
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuilderParseSize (theTest *testing.T) {
+
+	myTestCases := []struct {
+		Size string
+		Valid bool
+		Bytes int64
+	}{
+		{Size: "1048576", Valid: true, Bytes: 1048576},
+		{Size: "100k", Valid: true, Bytes: 100 << 10},
+		{Size: "100kb", Valid: true, Bytes: 100 << 10},
+		{Size: "512m", Valid: true, Bytes: 512 << 20},
+		{Size: "512MB", Valid: true, Bytes: 512 << 20},
+		{Size: " 2G ", Valid: true, Bytes: 2 << 30},
+		{Size: "10b", Valid: true, Bytes: 10},
+		{Size: "", Valid: false},
+		{Size: "m", Valid: false},
+		{Size: "0", Valid: false},
+		{Size: "-1m", Valid: false},
+		{Size: "1.5g", Valid: false},
+		{Size: "1t", Valid: false},
+		{Size: "1mk", Valid: false},
+		{Size: "2g2", Valid: false},
+		{Size: "9223372036854775807g", Valid: false},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myBytes, mySizeErr := builder_parse_size(myTestCase.Size)
+		if (mySizeErr == nil) != myTestCase.Valid || myBytes != myTestCase.Bytes {
+			theTest.Errorf("%q : %d %v, expected %d valid %v", myTestCase.Size, myBytes, mySizeErr, myTestCase.Bytes, myTestCase.Valid)
+		}
+	}
+}
+
+func TestBuilderValidateBuildLimits (theTest *testing.T) {
+
+	myTestCases := []struct {
+		Values map[string]string
+		Errors []string // setting keys of the expected errors
+	}{
+		{Values: map[string]string{}},
+		{Values: map[string]string{"LimitCPU": "2", "LimitMemory": "2g", "LimitTime": "10m", "LimitOutput": "1m"}},
+		{Values: map[string]string{"LimitCPU": "0.5", "LimitMemory": " ", "LimitTime": "1h30m"}},
+		{Values: map[string]string{"LimitCPU": "0"}, Errors: []string{"LimitCPU"}},
+		{Values: map[string]string{"LimitCPU": "two"}, Errors: []string{"LimitCPU"}},
+		{Values: map[string]string{"LimitMemory": "lots"}, Errors: []string{"LimitMemory"}},
+		{Values: map[string]string{"LimitOutput": "-1"}, Errors: []string{"LimitOutput"}},
+		{Values: map[string]string{"LimitTime": "10"}, Errors: []string{"LimitTime"}},
+		{Values: map[string]string{"LimitTime": "-5m"}, Errors: []string{"LimitTime"}},
+		{Values: map[string]string{"LimitCPU": "-1", "LimitMemory": "0", "LimitTime": "soon", "LimitOutput": "1x"}, Errors: []string{"LimitCPU", "LimitMemory", "LimitOutput", "LimitTime"}},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myErrors := builder_validate_build_limits(myTestCase.Values)
+		if len(myErrors) != len(myTestCase.Errors) {
+			theTest.Errorf("%v : errors %q, expected on %v", myTestCase.Values, myErrors, myTestCase.Errors)
+			continue
+		}
+		for myIndex, myError := range myErrors {
+			if !strings.HasPrefix(myError, myTestCase.Errors[myIndex]+" : ") {
+				theTest.Errorf("%v : error %q, expected on %s", myTestCase.Values, myError, myTestCase.Errors[myIndex])
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const kLogFormatText = "text"
+const kLogFormatJSON = "json"
+
+// Server logger, writing to gLogWriter once the config is applied.
+var gLogger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+// Response writer keeping the status and size of the response, for the request log.
+type LoggedResponseWriter struct {
+    http.ResponseWriter
+    Status int
+    Size int64
+}
+
+//------------------------------------------------------------------------------
+
+func builder_parse_log_level (theLevel string) (slog.Level, error) {
+	var myLevel slog.Level
+	myUnmarshalErr := myLevel.UnmarshalText([]byte(strings.TrimSpace(theLevel)))
+	if myUnmarshalErr != nil {
+		return myLevel, fmt.Errorf("expected debug, info, warn or error, got \"%s\"", theLevel)
+	}
+	return myLevel, nil
+}
+
+func builder_init_logger (theFormat string, theLevel string) error {
+
+	myLevel, myLevelErr := builder_parse_log_level(theLevel)
+	if myLevelErr != nil {
+		return fmt.Errorf("LogLevel : %v", myLevelErr)
+	}
+	myHandlerOptions := &slog.HandlerOptions{Level: myLevel}
+
+	switch theFormat {
+	case kLogFormatText:
+		gLogger = slog.New(slog.NewTextHandler(gLogWriter, myHandlerOptions))
+	case kLogFormatJSON:
+		gLogger = slog.New(slog.NewJSONHandler(gLogWriter, myHandlerOptions))
+	default:
+		return fmt.Errorf("LogFormat : expected %s or %s, got \"%s\"", kLogFormatText, kLogFormatJSON, theFormat)
+	}
+
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func (theWriter *LoggedResponseWriter) WriteHeader (theStatus int) {
+	if theWriter.Status == 0 {
+		theWriter.Status = theStatus
+	}
+	theWriter.ResponseWriter.WriteHeader(theStatus)
+}
+
+func (theWriter *LoggedResponseWriter) Write (theBytes []byte) (int, error) {
+	if theWriter.Status == 0 {
+		theWriter.Status = http.StatusOK
+	}
+	myCount, myWriteErr := theWriter.ResponseWriter.Write(theBytes)
+	theWriter.Size += int64(myCount)
+	return myCount, myWriteErr
+}
+
+// Logs every request once served : the successful GET and HEAD at debug level, as the pages poll
+// the project infos, the other requests at info level, and the server errors at error level.
+func builder_log_requests (theHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+
+		myStartTime := time.Now()
+		myLoggedResponse := &LoggedResponseWriter{ResponseWriter: theHTTPResponse}
+		theHandler.ServeHTTP(myLoggedResponse, theHTTPRequest)
+		if myLoggedResponse.Status == 0 {
+			myLoggedResponse.Status = http.StatusOK
+		}
+
+		myLevel := slog.LevelInfo
+		if myLoggedResponse.Status >= 500 {
+			myLevel = slog.LevelError
+		} else if myLoggedResponse.Status < 400 && (theHTTPRequest.Method == http.MethodGet || theHTTPRequest.Method == http.MethodHead) {
+			myLevel = slog.LevelDebug
+		}
+		gLogger.Log(theHTTPRequest.Context(), myLevel, "request",
+			"method", theHTTPRequest.Method,
+			"path", theHTTPRequest.URL.Path,
+			"status", myLoggedResponse.Status,
+			"size", myLoggedResponse.Size,
+			"duration", time.Since(myStartTime),
+			"remote", theHTTPRequest.RemoteAddr,
+			"user", builder_get_request_admin_user(theHTTPRequest),
+		)
+	})
+}
@@ -9,11 +9,14 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,20 +26,45 @@ var gEmbeddedAssets embed.FS
 const kDefaultProjectsDirPath = "/opt/dev"
 const kProjectSettingsFileName = "builder.settings"
 
-const kDockerSock = "/var/run/docker.sock"
+const kDefaultDockerHost = "unix:///var/run/docker.sock"
 
-var gProjectsDirPath = kDefaultProjectsDirPath
+const kDefaultDataDirPath = "/opt/dev/.go-builder"
+
+var gDataDirPath = kDefaultDataDirPath
 
 type Project struct {
-    Id string // parent folder name
+    Id string // parent folder name, "<folder>@<target>" for the targets of a monorepo, prefixed by "<root label>:"
+    Root string // label of the projects root
+    DirPath string // project folder, holding builder.settings, Dockerfile and docker-compose.yml
+    TargetName string // built program name, default = same as Id
+    TargetFilePath string // built program path, default : <DirPath>/<TargetName>
     ImageName string // container name, default = same as Id
-    Status string // ""(idle, default), "build-pending", "build-running"
+    Status string // ""(idle, default), "build-pending", "build-running", "up-...", "down-...", "deploy-...", "prefetch-...", "refresh-..."
     SrcDir string // default : "src"
-    Engine string // "go"(default), "cgo"
+    Engine string // "go"(default), "cgo", "docker", "custom"
     BuildCommand string // generated from Engine etc
     BuildOutput string
+    BuilderImage string // image of the build containers of the docker engine, default : "golang:1.23"
+    ImageTags []string // tag templates, default : "latest"
+    PushRegistry string // registry to push the tagged images to, default : none
+    DockerBuild DockerBuildOptions
+    Cgo CgoOptions // C toolchain and link mode of the cgo engine
+    GoArch string // GOARCH of the go and cgo engines, default : the arch of the builder
+    Scan bool // scan the modules of the program for known vulnerabilities after the build
+    ScanFailOn string // severity failing the build, "any", "low", "moderate", "high", "critical", default : none
+    KeepImages int // number of image versions kept for rollbacks, default : 5
+    GoCache string // "shared"(default) or "project", GOCACHE and GOMODCACHE of the builds
+    Limits BuildLimits // cpu, memory, time and output limits of the build command
+    Notify NotifyOptions // notifiers of the build, up and down events
+    Schedule []*CronSchedule // periodic builds
+    RefreshSchedule []*CronSchedule // periodic dependency refresh builds
+    GoVersion string // toolchain, ex : "go1.22.3", default : toolchain directive of go.mod, else the Go of the builder
+    DeployBuild int // build number of the version to deploy
+    JobTrigger string // trigger of the pending or running job, "manual", "requeue", "scheduled"
+    LastBuild *BuildRecord
 }
 var gProjects map[string]*Project
+var gProjectsMutex sync.Mutex // guards gProjects, gOrderedProjectIds and the projects runtime fields (Status, BuildOutput, LastBuild, ...)
 var gOrderedProjectIds []string
 
 type DockerContainer struct {
@@ -61,84 +89,229 @@ var gDockerImages map[string]DockerImage
 
 func builder_register_projects () {
 
-	gProjects = make(map[string]*Project)
+	myProjects := make(map[string]*Project)
+
+	for _, myProjectsRoot := range gProjectsRoots {
+		builder_register_root_projects(myProjectsRoot, myProjects)
+	}
 
-	myProjectsDirEntries, myReadDirErr := ioutil.ReadDir(gProjectsDirPath)
+	// keep the runtime state of already registered projects
+	gProjectsMutex.Lock()
+	for myProjectId, myProject := range myProjects {
+		myPreviousProject, myPreviousProjectExists := gProjects[myProjectId]
+		if myPreviousProjectExists {
+			myProject.Status = myPreviousProject.Status
+			myProject.BuildOutput = myPreviousProject.BuildOutput
+			myProject.DeployBuild = myPreviousProject.DeployBuild
+			myProject.JobTrigger = myPreviousProject.JobTrigger
+		}
+	}
+	// a busy project stays registered until the end of its job, even when its dir is gone
+	for myProjectId, myPreviousProject := range gProjects {
+		_, myProjectExists := myProjects[myProjectId]
+		if !myProjectExists && myPreviousProject.Status != "" {
+			myProjects[myProjectId] = myPreviousProject
+		}
+	}
+
+	myOrderedProjectIds := make([]string, 0, len(myProjects))
+	for myProjectId, _ := range myProjects {
+        myOrderedProjectIds = append(myOrderedProjectIds, myProjectId)
+    }
+	sort.Strings(myOrderedProjectIds)
+
+	gProjects = myProjects
+	gOrderedProjectIds = myOrderedProjectIds
+	gProjectsMutex.Unlock()
+
+}
+
+// Returns a copy of the registered project, taken under gProjectsMutex, nil when unknown.
+// The projects are registered again by every projects list, the jobs and the pages only read them through these copies.
+func builder_get_project (theProjectId string) *Project {
+	gProjectsMutex.Lock()
+	defer gProjectsMutex.Unlock()
+	myProject, myProjectExists := gProjects[theProjectId]
+	if !myProjectExists {
+		return nil
+	}
+	myProjectCopy := *myProject
+	return &myProjectCopy
+}
+
+// Returns copies of the registered projects, in display order.
+func builder_get_ordered_projects () []*Project {
+	gProjectsMutex.Lock()
+	defer gProjectsMutex.Unlock()
+	var myProjects []*Project
+	for _, myProjectId := range gOrderedProjectIds {
+		myProjectCopy := *gProjects[myProjectId]
+		myProjects = append(myProjects, &myProjectCopy)
+	}
+	return myProjects
+}
+
+func builder_register_root_projects (theProjectsRoot ProjectsRoot, theProjects map[string]*Project) {
+
+	myIgnorePatterns := builder_load_ignore_patterns(theProjectsRoot.DirPath)
+
+	myProjectsDirEntries, myReadDirErr := ioutil.ReadDir(theProjectsRoot.DirPath)
 	if myReadDirErr == nil {
 		for _, myProjectsDirEntry := range myProjectsDirEntries {
-			myProjectId := myProjectsDirEntry.Name()
-			myProjectDirPath := filepath.Join(gProjectsDirPath, myProjectId)
+			myProjectDirName := myProjectsDirEntry.Name()
+			myProjectDirPath := filepath.Join(theProjectsRoot.DirPath, myProjectDirName)
 			myEntryFileInfo, myEntryStatErr := os.Stat(myProjectDirPath)
 			if myEntryStatErr == nil {
 				if myEntryFileInfo.IsDir() {
 					myProjectSettingsFilePath := filepath.Join(myProjectDirPath, kProjectSettingsFileName)
 					mySettingsFileInfo, mySettingsStatErr := os.Stat(myProjectSettingsFilePath)
-					if mySettingsStatErr == nil {
-						if !mySettingsFileInfo.IsDir() {
-							myEntrySettings := builder_load_project_settings(myProjectId)
-							myProject := Project{Id: myProjectId,
-								ImageName: myProjectId,
-								Status: "",
-								SrcDir: "src",
-								Engine: "go",
-								BuildCommand: "",
-								BuildOutput: "",
-							}
-
-							if myEntrySettings["ImageName"] != "" {
-								myProject.ImageName = strings.TrimSpace(myEntrySettings["ImageName"])
-							}
-							if myEntrySettings["SrcDir"] != "" {
-								myProject.SrcDir = strings.TrimSpace(myEntrySettings["SrcDir"])
-							}
-							if myEntrySettings["Engine"] != "" {
-								myProject.Engine = strings.TrimSpace(myEntrySettings["Engine"])
+					myHasSettings := mySettingsStatErr == nil && !mySettingsFileInfo.IsDir()
+					if myHasSettings || (gAutoDiscover && builder_is_discoverable_dir(myProjectDirName, myProjectDirPath)) {
+						for _, myProject := range builder_new_dir_projects(theProjectsRoot, myProjectDirName, myProjectDirPath, myHasSettings) {
+							if builder_is_project_ignored(builder_get_unlabelled_project_id(theProjectsRoot, myProject.Id), myIgnorePatterns) {
+								continue
 							}
-							if myEntrySettings["BuildCommand"] != "" {
-								myProject.BuildCommand = strings.TrimSpace(myEntrySettings["BuildCommand"])
-							}
-
-							if myProject.Engine != "" {
-
-								switch myProject.Engine {
-								case "go":
-									myBuildCommand := "CGO_ENABLED=0 GOOS=linux go build"
-									myBuildCommand += " -o "+filepath.Join(myProjectDirPath, myProjectId)
-									myProject.BuildCommand = myBuildCommand
-								case "cgo":
-									myBuildCommand := "CGO_ENABLED=1 GOOS=linux go build -ldflags '-linkmode external -extldflags \"-static\"'"
-									myBuildCommand += " -o "+filepath.Join(myProjectDirPath, myProjectId)
-									myProject.BuildCommand = myBuildCommand
-								}
-
-							}
-							gProjects[myProjectId] = &myProject
+							theProjects[myProject.Id] = myProject
 						}
 					}
 				}
 			}
 		}
 	}
+}
 
-	gOrderedProjectIds = make([]string, 0, len(gProjects))
-	for myProjectId, _ := range gProjects {
-        gOrderedProjectIds = append(gOrderedProjectIds, myProjectId)
-    }
-	sort.Strings(gOrderedProjectIds)
+func builder_new_project (theProjectId string, theProjectDirPath string, theSettings map[string]string) *Project {
+
+	myProject := Project{Id: theProjectId,
+		DirPath: theProjectDirPath,
+		TargetName: theProjectId,
+		TargetFilePath: filepath.Join(theProjectDirPath, theProjectId),
+		ImageName: theProjectId,
+		Status: "",
+		SrcDir: "src",
+		Engine: "go",
+		BuildCommand: "",
+		BuildOutput: "",
+		BuilderImage: kDefaultBuilderImage,
+		ImageTags: []string{kDefaultImageTags},
+		PushRegistry: "",
+		DockerBuild: builder_load_docker_build_options(theSettings),
+		Limits: builder_load_build_limits(theSettings),
+		Cgo: builder_load_cgo_options(theSettings),
+		Notify: builder_load_notify_options(theSettings),
+		Schedule: builder_load_cron_schedules(theSettings["Schedule"]),
+		RefreshSchedule: builder_load_cron_schedules(theSettings["RefreshSchedule"]),
+		KeepImages: kDefaultKeepImages,
+		GoCache: kGoCacheModeShared,
+	}
 
+	if theSettings["ImageName"] != "" {
+		myProject.ImageName = strings.TrimSpace(theSettings["ImageName"])
+	}
+	if theSettings["SrcDir"] != "" {
+		myProject.SrcDir = strings.TrimSpace(theSettings["SrcDir"])
+	}
+	if theSettings["Engine"] != "" {
+		myProject.Engine = strings.TrimSpace(theSettings["Engine"])
+	}
+	if theSettings["BuildCommand"] != "" {
+		myProject.BuildCommand = strings.TrimSpace(theSettings["BuildCommand"])
+	}
+	if theSettings["BuilderImage"] != "" {
+		myProject.BuilderImage = strings.TrimSpace(theSettings["BuilderImage"])
+	}
+	if theSettings["ImageTags"] != "" {
+		myProject.ImageTags = builder_split_setting_list(theSettings["ImageTags"])
+	}
+	if theSettings["PushRegistry"] != "" {
+		myProject.PushRegistry = strings.TrimSpace(theSettings["PushRegistry"])
+	}
+	if theSettings["GoArch"] != "" {
+		myProject.GoArch = strings.TrimSpace(theSettings["GoArch"])
+	}
+	myProject.Scan = builder_parse_setting_bool(theSettings["Scan"])
+	if theSettings["ScanFailOn"] != "" {
+		myProject.ScanFailOn = strings.ToLower(strings.TrimSpace(theSettings["ScanFailOn"]))
+	}
+	if theSettings["GoVersion"] != "" {
+		myProject.GoVersion = builder_normalize_go_version(theSettings["GoVersion"])
+	}
+	if theSettings["GoCache"] != "" {
+		myProject.GoCache = strings.TrimSpace(theSettings["GoCache"])
+	}
+	if theSettings["KeepImages"] != "" {
+		myKeepImages, myAtoiErr := strconv.Atoi(strings.TrimSpace(theSettings["KeepImages"]))
+		if myAtoiErr == nil && myKeepImages > 0 {
+			myProject.KeepImages = myKeepImages
+		}
+	}
+
+	return &myProject
+}
+
+func builder_set_project_build_command (theProject *Project) {
+
+	if theProject.Engine != "" {
+
+		switch theProject.Engine {
+		case "go":
+			myBuildCommand := "CGO_ENABLED=0 GOOS=linux"
+			if theProject.GoArch != "" {
+				myBuildCommand += " GOARCH="+theProject.GoArch
+			}
+			myBuildCommand += " go build -o "+theProject.TargetFilePath
+			theProject.BuildCommand = myBuildCommand
+		case "cgo":
+			theProject.BuildCommand = builder_get_cgo_build_command(theProject)
+		case kEngineDocker:
+			// runs in the build container, the program is copied from /out to the target path
+			if theProject.BuildCommand == "" {
+				theProject.BuildCommand = "go build -o "+kDockerBuildOutputDirPath+"/"+theProject.TargetName
+			}
+		}
+
+	}
 }
 
+// The project helpers below return "" once the project is not registered anymore.
 func builder_get_project_dirpath (theProjectId string) string {
-	return filepath.Join(gProjectsDirPath, theProjectId)
+	myProject := builder_get_project(theProjectId)
+	if myProject == nil {
+		return ""
+	}
+	return myProject.DirPath
 }
 
 func builder_get_project_srcdir (theProjectId string) string {
-	return gProjects[theProjectId].SrcDir
+	myProject := builder_get_project(theProjectId)
+	if myProject == nil {
+		return ""
+	}
+	return myProject.SrcDir
+}
+
+func builder_get_project_srcdirpath (theProjectId string) string {
+	myProject := builder_get_project(theProjectId)
+	if myProject == nil {
+		return ""
+	}
+	return builder_get_srcdirpath(myProject)
+}
+
+// Src dir path of a project copy, its dir when SrcDir is empty.
+func builder_get_srcdirpath (theProject *Project) string {
+	if theProject.SrcDir == "" {
+		return theProject.DirPath
+	}
+	return filepath.Join(theProject.DirPath, theProject.SrcDir)
 }
 
 func builder_get_project_target_filepath (theProjectId string) string {
-	myProjectDirPath := builder_get_project_dirpath(theProjectId)
-	return filepath.Join(myProjectDirPath, theProjectId)
+	myProject := builder_get_project(theProjectId)
+	if myProject == nil {
+		return ""
+	}
+	return myProject.TargetFilePath
 }
 func builder_get_project_target_lastmod (theProjectId string) string {
 	myTargetFilePath := builder_get_project_target_filepath(theProjectId)
@@ -159,12 +332,16 @@ func builder_project_has_docker_compose (theProjectId string) bool {
 
 //------------------------------------------------------------------------------
 
-func builder_load_project_settings (theProjectId string) map[string]string {
+func builder_load_project_settings (theProjectDirPath string) map[string]string {
+	mySettingsFilePath := filepath.Join(theProjectDirPath, kProjectSettingsFileName)
+	return builder_load_settings_file(mySettingsFilePath)
+}
+
+func builder_load_settings_file (theSettingsFilePath string) map[string]string {
 
 	var mySettings = make(map[string]string)
 
-	mySettingsFilePath := filepath.Join(gProjectsDirPath, theProjectId, kProjectSettingsFileName)
-	mySettingsFileText, myReadFileError := os.ReadFile(mySettingsFilePath)
+	mySettingsFileText, myReadFileError := os.ReadFile(theSettingsFilePath)
 	if myReadFileError != nil {
 		return mySettings
 	}
@@ -186,129 +363,201 @@ func builder_load_project_settings (theProjectId string) map[string]string {
 
 //------------------------------------------------------------------------------
 
-func builder_build_project (theProjectId string) []string {
+// Builds the project. A refresh build first updates go.mod and go.sum, written back as they were unless
+// the build succeeds : a refresh never leaves dependencies that don't build in the sources.
+func builder_build_project (theProject *Project, theRecord *BuildRecord) []string {
+
+	if !theRecord.Refresh {
+		return builder_build_project_program(theProject, theRecord)
+	}
+
+	myGoModBackup := builder_backup_go_mod(builder_get_srcdirpath(theProject))
+	myReturnLines := builder_build_project_program(theProject, theRecord)
+	if theRecord.Result != "ok" {
+		myRestoreErr := builder_restore_go_mod(myGoModBackup)
+		if myRestoreErr != nil {
+			gLogger.Error("go.mod not restored after a failed refresh", "project", theProject.Id, "error", myRestoreErr)
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Refresh : go.mod and go.sum not restored : %v", myRestoreErr))
+		} else {
+			myReturnLines = append(myReturnLines, "Refresh : build failed, go.mod and go.sum left as before the refresh")
+		}
+	}
+	return myReturnLines
+}
+
+// Builds the program of a project copy, taken once for the whole job.
+func builder_build_project_program (theProject *Project, theRecord *BuildRecord) []string {
 
 	var myReturnLines []string
 
-	myReturnLines = append(myReturnLines, "Building Project : "+theProjectId)
+	myReturnLines = append(myReturnLines, fmt.Sprintf("Building Project : %s (build #%d)", theProject.Id, theRecord.Number))
 
-	myProjectDirPath := filepath.Join(gProjectsDirPath, theProjectId)
-	myReturnLines = append(myReturnLines, "Project DirPath : "+myProjectDirPath)
+	myReturnLines = append(myReturnLines, "Project DirPath : "+theProject.DirPath)
 
-	myProjectSrcDirPath := myProjectDirPath
-	myProjectSrcDir := builder_get_project_srcdir(theProjectId)
-	if myProjectSrcDir != "" {
-		myProjectSrcDirPath = filepath.Join(myProjectDirPath, myProjectSrcDir)
-	}
+	myProjectSrcDirPath := builder_get_srcdirpath(theProject)
+
+	theRecord.Commit = builder_get_git_commit(myProjectSrcDirPath)
+	theRecord.GitTag = builder_get_git_tag(myProjectSrcDirPath)
 
-	myProjectBuildCommand := gProjects[theProjectId].BuildCommand
+	myProjectBuildCommand := theProject.BuildCommand
 	if myProjectBuildCommand == "" {
+		theRecord.Result = "failed"
 		myReturnLines = append(myReturnLines, "Build Command undefined")
 		return myReturnLines
 	}
 	myReturnLines = append(myReturnLines, "Project BuildCommand : "+myProjectBuildCommand)
 
-	myBuildCommand := exec.Command("/bin/sh", "-c", "cd "+myProjectSrcDirPath+" && "+myProjectBuildCommand)
-    myBuildOutputBytes, myBuildErr := myBuildCommand.CombinedOutput()
-	if myBuildErr != nil {
-		myReturnLines = append(myReturnLines, fmt.Sprintf("Build failed : %v", myBuildErr))
+	if theProject.Engine != "custom" {
+		myToolchainLines, myToolchainErr := builder_install_project_toolchain(theProject.Id)
+		myReturnLines = append(myReturnLines, myToolchainLines...)
+		if myToolchainErr != nil {
+			theRecord.Result = "failed"
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Build failed : %v", myToolchainErr))
+			return myReturnLines
+		}
+		if theRecord.Refresh {
+			myRefreshLines, myRefreshErr := builder_refresh_project_deps(theProject.Id, myProjectSrcDirPath)
+			myReturnLines = append(myReturnLines, myRefreshLines...)
+			if myRefreshErr != nil {
+				theRecord.Result = "failed"
+				myReturnLines = append(myReturnLines, fmt.Sprintf("Build failed : refresh %v", myRefreshErr))
+				return myReturnLines
+			}
+		}
+		if theProject.Engine != kEngineDocker {
+			theRecord.GoVersion = builder_get_project_go_version_used(theProject.Id)
+			myReturnLines = append(myReturnLines, "Go version : "+theRecord.GoVersion)
+		}
+	}
+
+	myLimitsText := builder_get_build_limits_text(theProject.Limits)
+	if myLimitsText != "" {
+		myReturnLines = append(myReturnLines, "Build limits : "+myLimitsText)
+	}
+
+	var myBuildOutputBytes []byte
+	var myBuildErr error
+	if theProject.Engine == kEngineDocker {
+		myReturnLines = append(myReturnLines, "Builder image : "+theProject.BuilderImage)
+		myBuildOutputBytes, myBuildErr = builder_run_docker_build(theProject.Id, theRecord)
 	} else {
-		myReturnLines = append(myReturnLines, fmt.Sprintf("Build OK for %s", theProjectId))
-	}
-	myBuildOutputLines := bytes.Split(myBuildOutputBytes, []byte("\n"))
-	for _, myBuildOutputLine := range myBuildOutputLines {
-		myReturnLines = append(myReturnLines, string(myBuildOutputLine))
-	}
-
-	myDockerfilePath := filepath.Join(myProjectDirPath, "Dockerfile")
-	_, myDockerfileStatErr := os.Stat(myDockerfilePath)
-	if myDockerfileStatErr == nil {
-		myDockerImageBuildCommand := "docker build -f "+myDockerfilePath+" -t "+gProjects[theProjectId].ImageName+" "+myProjectDirPath
-		myReturnLines = append(myReturnLines, "Docker image BuildCommand : "+myDockerImageBuildCommand)
-		myBuildCommand := exec.Command("/bin/sh", "-c", "cd "+myProjectSrcDirPath+" && "+myDockerImageBuildCommand)
-		myBuildOutputBytes, myBuildErr = myBuildCommand.CombinedOutput()
-		if myBuildErr != nil {
-			myReturnLines = append(myReturnLines, fmt.Sprintf("Docker build failed : %v", myBuildErr))
+		var myLimitsLines []string
+		myLimitsLines, myBuildOutputBytes, myBuildErr = builder_run_host_build(theProject.Id, theRecord, myProjectSrcDirPath)
+		myReturnLines = append(myReturnLines, myLimitsLines...)
+	}
+	if myBuildErr != nil {
+		theRecord.Result = "failed"
+		if theRecord.FailureReason != "" {
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Build failed : %s exceeded (%v)", theRecord.FailureReason, myBuildErr))
 		} else {
-			myReturnLines = append(myReturnLines, fmt.Sprintf("Docker build OK for %s", theProjectId))
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Build failed : %v", myBuildErr))
 		}
-		myBuildOutputLines := bytes.Split(myBuildOutputBytes, []byte("\n"))
-		for _, myBuildOutputLine := range myBuildOutputLines {
-			myReturnLines = append(myReturnLines, string(myBuildOutputLine))
+		myReturnLines = builder_append_output_lines(myReturnLines, myBuildOutputBytes)
+		return myReturnLines
+	}
+	theRecord.Result = "ok"
+	myReturnLines = append(myReturnLines, fmt.Sprintf("Build OK for %s", theProject.Id))
+	myReturnLines = builder_append_output_lines(myReturnLines, myBuildOutputBytes)
+
+	if theProject.Engine == "cgo" {
+		myCheckLines, myCheckErr := builder_check_cgo_program(theProject.Id)
+		myReturnLines = append(myReturnLines, myCheckLines...)
+		if myCheckErr != nil {
+			theRecord.Result = "failed"
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Link check failed : %v", myCheckErr))
+			return myReturnLines
 		}
-    }
+	}
+
+	if theProject.Scan {
+		myScanLines, myScanErr := builder_scan_project(theProject.Id)
+		myReturnLines = append(myReturnLines, myScanLines...)
+		if myScanErr != nil {
+			theRecord.Result = "failed"
+			theRecord.FailureReason = kFailureVulnerabilities
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Scan failed : %v", myScanErr))
+			return myReturnLines
+		}
+	}
+
+	// only the programs passing the checks, the size diff is against the last good build
+	mySnapshotErr := builder_save_binary_snapshot(theProject.Id, theRecord.Number)
+	if mySnapshotErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Program sizes not saved : %v", mySnapshotErr))
+	}
+
+	myReturnLines = append(myReturnLines, builder_build_project_image(theProject.Id, theRecord)...)
 
 	return myReturnLines
 }
 
-func builder_docker_compose_up (theProjectId string) []string {
+// Returns the output lines of docker-compose up, and its error.
+func builder_docker_compose_up (theProject *Project, theForceRecreate bool) ([]string, error) {
 
 	var myReturnLines []string
 
-	myReturnLines = append(myReturnLines, "Docker compose UP : "+theProjectId)
+	myReturnLines = append(myReturnLines, "Docker compose UP : "+theProject.Id)
 
-	myProjectDirPath := filepath.Join(gProjectsDirPath, theProjectId)
-	myReturnLines = append(myReturnLines, "Project DirPath : "+myProjectDirPath)
+	myReturnLines = append(myReturnLines, "Project DirPath : "+theProject.DirPath)
 
-	myProjectSrcDirPath := myProjectDirPath
-	myProjectSrcDir := builder_get_project_srcdir(theProjectId)
-	if myProjectSrcDir != "" {
-		myProjectSrcDirPath = filepath.Join(myProjectDirPath, myProjectSrcDir)
-	}
+	myProjectSrcDirPath := builder_get_srcdirpath(theProject)
 
 	myDCCommandLine := "docker-compose up -d"
-	
-	myDCCommand := exec.Command("/bin/sh", "-c", "cd "+myProjectSrcDirPath+" && "+myDCCommandLine)
+	if theForceRecreate {
+		myDCCommandLine += " --force-recreate"
+	}
+
+	myDCCommand := builder_new_job_command("/bin/sh", "-c", "cd "+myProjectSrcDirPath+" && "+myDCCommandLine)
     myDCCommandOutputBytes, myDCCommandErr := myDCCommand.CombinedOutput()
 	if myDCCommandErr != nil {
 		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker compose UP failed : %v", myDCCommandErr))
 	} else {
-		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker compose UP OK for %s", theProjectId))
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker compose UP OK for %s", theProject.Id))
 	}
 	myDCCommandOutputLines := bytes.Split(myDCCommandOutputBytes, []byte("\n"))
 	for _, myDCCommandOutputLine := range myDCCommandOutputLines {
 		myReturnLines = append(myReturnLines, string(myDCCommandOutputLine))
 	}
 
-	return myReturnLines
+	return myReturnLines, myDCCommandErr
 }
 
-func builder_docker_compose_down (theProjectId string) []string {
+// Returns the output lines of docker-compose down, and its error.
+func builder_docker_compose_down (theProject *Project) ([]string, error) {
 
 	var myReturnLines []string
 
-	myReturnLines = append(myReturnLines, "Docker compose DOWN : "+theProjectId)
+	myReturnLines = append(myReturnLines, "Docker compose DOWN : "+theProject.Id)
 
-	myProjectDirPath := filepath.Join(gProjectsDirPath, theProjectId)
-	myReturnLines = append(myReturnLines, "Project DirPath : "+myProjectDirPath)
+	myReturnLines = append(myReturnLines, "Project DirPath : "+theProject.DirPath)
 
-	myProjectSrcDirPath := myProjectDirPath
-	myProjectSrcDir := builder_get_project_srcdir(theProjectId)
-	if myProjectSrcDir != "" {
-		myProjectSrcDirPath = filepath.Join(myProjectDirPath, myProjectSrcDir)
-	}
+	myProjectSrcDirPath := builder_get_srcdirpath(theProject)
 
 	myDCCommandLine := "docker-compose down"
 	
-	myDCCommand := exec.Command("/bin/sh", "-c", "cd "+myProjectSrcDirPath+" && "+myDCCommandLine)
+	myDCCommand := builder_new_job_command("/bin/sh", "-c", "cd "+myProjectSrcDirPath+" && "+myDCCommandLine)
     myDCCommandOutputBytes, myDCCommandErr := myDCCommand.CombinedOutput()
 	if myDCCommandErr != nil {
 		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker compose DOWN failed : %v", myDCCommandErr))
 	} else {
-		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker compose DOWN OK for %s", theProjectId))
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker compose DOWN OK for %s", theProject.Id))
 	}
 	myDCCommandOutputLines := bytes.Split(myDCCommandOutputBytes, []byte("\n"))
 	for _, myDCCommandOutputLine := range myDCCommandOutputLines {
 		myReturnLines = append(myReturnLines, string(myDCCommandOutputLine))
 	}
 
-	return myReturnLines
+	return myReturnLines, myDCCommandErr
 }
 
 //------------------------------------------------------------------------------
 
+// Only a unix socket docker host can be checked without running the docker CLI.
 func builder_is_docker_connected () bool {
-	_, myStatErr := os.Stat(kDockerSock)
+	if !strings.HasPrefix(gServerConfig.DockerHost, "unix://") {
+		return gServerConfig.DockerHost != ""
+	}
+	_, myStatErr := os.Stat(strings.TrimPrefix(gServerConfig.DockerHost, "unix://"))
 	return !os.IsNotExist(myStatErr)
 }
 
@@ -323,6 +572,7 @@ func builder_register_docker_images () {
 	myDockerImagesCommand := exec.Command("docker", "images", "--format", "{{json .}}")
 	myCommandOutput, myCommandErr := myDockerImagesCommand.Output()
 	if myCommandErr != nil {
+		builder_record_docker_error("docker", []string{"images"}, myCommandErr)
 		return
 	}
 	myOutputString := string(myCommandOutput)
@@ -356,6 +606,7 @@ func builder_register_docker_containers () {
 	myDockerPSCommand := exec.Command("docker", "ps", "--format", "{{json .}}")
 	myCommandOutput, myCommandErr := myDockerPSCommand.Output()
 	if myCommandErr != nil {
+		builder_record_docker_error("docker", []string{"ps"}, myCommandErr)
 		return
 	}
 	myOutputString := string(myCommandOutput)
@@ -383,42 +634,30 @@ func builder_fetch_docker_container (theContainerName string) DockerContainer {
 
 //------------------------------------------------------------------------------
 
-func builder_get_project_info (theProjectId string) map[string]string {
-
-	myInfoMap := make(map[string]string)
-
-	myTargetModTime := builder_get_project_target_lastmod(theProjectId)
-	myTargetInfo := "Last Program build : "+myTargetModTime
-
-	myImageName := gProjects[theProjectId].ImageName
-	myImageInfo := ""
+// Returns the states ("active", "running", "disabled" or "" when hidden) of the Build, Up and Down tools.
+func builder_get_project_icon_states (theProjectId string, theComposeAvailable bool, theContainerUp bool) (string, string, string) {
 
-	myBuildOutput := ""
 	myBuildIconState := ""
 	myUpIconState := ""
 	myDownIconState := ""
 
-	myProjectStatus := gProjects[theProjectId].Status
-
-	myProjectHasDockerCompose := builder_project_has_docker_compose(theProjectId)
+	myProjectStatus := builder_get_project(theProjectId).Status
 
 	switch myProjectStatus {
 	case "build-pending":
 	case "build-running":
 		myBuildIconState = "running"
-		myBuildOutput = ""
+	case "refresh-pending", "refresh-running":
+		myBuildIconState = "running"
+	case "prefetch-pending", "prefetch-running":
+		myBuildIconState = "disabled"
 	default:
 		myBuildIconState = "active"
-		myBuildOutput = ""
 	}
 
-	if builder_is_docker_connected() && myProjectHasDockerCompose {
-		builder_register_docker_images()
-		builder_register_docker_containers()
-		myDockerContainer := builder_fetch_docker_container(myImageName)
-		myDockercontainerUp := (myDockerContainer.Id != "")
+	if theComposeAvailable {
 
-		if myDockercontainerUp {
+		if theContainerUp {
 			myUpIconState = "disabled"
 			myDownIconState = "active"
 		} else {
@@ -431,6 +670,12 @@ func builder_get_project_info (theProjectId string) map[string]string {
 		case "build-running":
 			myUpIconState = "disabled"
 			myDownIconState = "disabled"
+		case "refresh-pending", "refresh-running":
+			myUpIconState = "disabled"
+			myDownIconState = "disabled"
+		case "prefetch-pending", "prefetch-running":
+			myUpIconState = "disabled"
+			myDownIconState = "disabled"
 		case "up-pending":
 		case "up-running":
 			myUpIconState = "running"
@@ -441,118 +686,243 @@ func builder_get_project_info (theProjectId string) map[string]string {
 			myUpIconState = "disabled"
 			myDownIconState = "running"
 			myBuildIconState = "disabled"
+		case "deploy-pending", "deploy-running":
+			myUpIconState = "running"
+			myDownIconState = "disabled"
+			myBuildIconState = "disabled"
+		}
+	}
+
+	return myBuildIconState, myUpIconState, myDownIconState
+}
+
+// The info values are plain text, the pages insert them escaped.
+func builder_get_project_info (theProjectId string) map[string]interface{} {
+
+	myInfoMap := make(map[string]interface{})
+
+	myProject := builder_get_project(theProjectId)
+	myTargetModTime := builder_get_project_target_lastmod(theProjectId)
+	myTargetInfo := "Last Program build : "+myTargetModTime
+
+	myImageName := myProject.ImageName
+	myImageInfo := ""
+	myTagsInfo := ""
+	myVersionsInfo := []map[string]interface{}{}
+
+	myLastBuild := myProject.LastBuild
+	if myLastBuild != nil {
+		myBuildText := fmt.Sprintf("build #%d", myLastBuild.Number)
+		if myLastBuild.Refresh {
+			myBuildText = fmt.Sprintf("refresh build #%d", myLastBuild.Number)
+		}
+		if myLastBuild.Trigger == kTriggerScheduled {
+			myBuildText = "scheduled "+myBuildText
 		}
+		if myLastBuild.FailureReason != "" {
+			myTargetInfo += fmt.Sprintf(" (%s, %s : %s)", myBuildText, myLastBuild.Result, myLastBuild.FailureReason)
+		} else {
+			myTargetInfo += fmt.Sprintf(" (%s, %s)", myBuildText, myLastBuild.Result)
+		}
+		if myLastBuild.GoVersion != "" {
+			myTargetInfo += " with "+myLastBuild.GoVersion
+		}
+		if len(myLastBuild.PushedTags) > 0 {
+			myTagsInfo = "Pushed tags : "+strings.Join(myLastBuild.PushedTags, ", ")
+		} else if len(myLastBuild.ImageTags) > 0 {
+			myTagsInfo = "Image tags : "+strings.Join(myLastBuild.ImageTags, ", ")
+		}
+	}
 
-	myDockerImage := builder_fetch_docker_image(myImageName)
-	myImageInfo = "Last Image build ("+myImageName+") : "+myDockerImage.CreatedSince
+	myScheduleInfo := ""
+	myNextRunText := builder_get_project_next_run_text(myProject)
+	if myNextRunText != "" {
+		myScheduleInfo = "Next scheduled build : "+myNextRunText
 	}
 
-	myBuildIconString := ""
-	switch myBuildIconState {
-	case "running":
-		myBuildIconString = "<div><img src=\"/assets/project/build-running.gif\"></div>"
-		myBuildIconString += "<div style=\"font-size:0.8em\">Build</div>"
-	case "active":
-		myBuildIconString = "<div><a href=\"/"+theProjectId+"/build\">"
-		myBuildIconString += "<img src=\"/assets/project/build.svg\"></a></div>"
-		myBuildIconString += "<div style=\"font-size:0.8em\">Build</div>"
-	default:
-		myBuildIconString = "<div><img src=\"/assets/project/build-disabled.svg\"></div>"
-		myBuildIconString += "<div style=\"font-size:0.8em\">Build</div>"
-	}
-
-	myUpIconString := ""
-	switch myUpIconState {
-	case "running":
-		myUpIconString = "<div><img src=\"/assets/project/up-running.gif\"></div>"
-		myUpIconString += "<div style=\"font-size:0.8em\">Up</div>"
-	case "active":
-		myUpIconString = "<div><a href=\"/"+theProjectId+"/up\">"
-		myUpIconString += "<img src=\"/assets/project/up.svg\"></a></div>"
-		myUpIconString += "<div style=\"font-size:0.8em\">Up</div>"
-	default:
-		myUpIconString = "<div><img src=\"/assets/project/up-disabled.svg\"></div>"
-		myUpIconString += "<div style=\"font-size:0.8em\">Up</div>"
-	}
-
-	myDownIconString := ""
-	switch myDownIconState {
-	case "running":
-		myDownIconString = "<div><img src=\"/assets/project/down-running.gif\"></div>"
-		myDownIconString += "<div style=\"font-size:0.8em\">Down</div>"
-	case "active":
-		myDownIconString = "<div><a href=\"/"+theProjectId+"/down\">"
-		myDownIconString += "<img src=\"/assets/project/down.svg\"></a></div>"
-		myDownIconString += "<div style=\"font-size:0.8em\">Down</div>"
-	default:
-		myDownIconString = "<div><img src=\"/assets/project/down-disabled.svg\"></div>"
-		myDownIconString += "<div style=\"font-size:0.8em\">Down</div>"
+	myBuildOutput := myProject.BuildOutput
+
+	myProjectStatus := myProject.Status
+
+	myComposeAvailable := builder_is_docker_connected() && builder_project_has_docker_compose(theProjectId)
+	myDockercontainerUp := false
+
+	if myComposeAvailable {
+		builder_register_docker_images()
+		builder_register_docker_containers()
+		myDockerContainer := builder_fetch_docker_container(myImageName)
+		myDockercontainerUp = (myDockerContainer.Id != "")
+
+		myVersionsInfo = builder_get_project_versions_info(theProjectId, myProjectStatus == "")
+
+		myDockerImage := builder_fetch_docker_image(myImageName)
+		myImageInfo = "Last Image build ("+myImageName+") : "+myDockerImage.CreatedSince
 	}
 
+	myBuildIconState, myUpIconState, myDownIconState := builder_get_project_icon_states(theProjectId, myComposeAvailable, myDockercontainerUp)
+
 	myInfoMap["TargetInfo"] = myTargetInfo
 	myInfoMap["ImageInfo"] = myImageInfo
+	myInfoMap["TagsInfo"] = myTagsInfo
+	myInfoMap["ScheduleInfo"] = myScheduleInfo
+	myInfoMap["Versions"] = myVersionsInfo
 	myInfoMap["ProjectStatus"] = myProjectStatus
 	myInfoMap["BuildOutput"] = myBuildOutput
-	myInfoMap["BuildIconTool"] = myBuildIconString
-	myInfoMap["UpIconTool"] = myUpIconString
-	myInfoMap["DownIconTool"] = myDownIconString
+	myInfoMap["BuildIconState"] = myBuildIconState
+	myInfoMap["UpIconState"] = myUpIconState
+	myInfoMap["DownIconState"] = myDownIconState
 
 	return myInfoMap
 }
 
 //------------------------------------------------------------------------------
 
-func builder_load_assets_html (theRelativeFilePath string) string {
+func main () {
 
-	myRequestFilePath := "assets/"+theRelativeFilePath
-	myIndexFileBytes, myReadErr := gEmbeddedAssets.ReadFile(myRequestFilePath)
-    if myReadErr != nil {
-        return ""
-    }
-	return string(myIndexFileBytes)
-}
+	myConfigErr := builder_load_server_config()
+	if myConfigErr != nil {
+		fmt.Fprintf(os.Stderr, "Invalid config : %v\n", myConfigErr)
+		os.Exit(2)
+	}
+	builder_log_server_config()
 
-//------------------------------------------------------------------------------
+	builder_register_page_templates()
+	builder_register_projects()
+	builder_recover_interrupted_jobs()
 
-func main () {
+	go builder_run_scheduler()
+	go builder_run_cron_scheduler()
 
-	builder_register_projects()
+	myWebMux := http.NewServeMux()
+
+	myAssetsFiles, _ := fs.Sub(gEmbeddedAssets, "assets")
+	myWebMux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.FS(myAssetsFiles))))
+
+	// the generated CA, to be trusted by the browsers
+	myWebMux.HandleFunc("/ca.crt", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+		if !gServerConfig.TLSAutoCert {
+			http.NotFound(theHTTPResponse, theHTTPRequest)
+			return
+		}
+		theHTTPResponse.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		http.ServeFile(theHTTPResponse, theHTTPRequest, filepath.Join(builder_get_tls_dirpath(), kCACertFileName))
+	})
+
+	myWebMux.HandleFunc("/projects.json", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+
+		myDashboardJSONResultBytes, myJSONErr := json.Marshal(builder_get_projects_dashboard_info())
+		if myJSONErr != nil {
+			myDashboardJSONResultBytes = []byte("[]")
+		}
+
+		theHTTPResponse.Header().Set("Content-Type", "application/json")
+		theHTTPResponse.Write(myDashboardJSONResultBytes)
+	})
+
+	myWebMux.HandleFunc(kMetricsURLPath, builder_serve_metrics)
 
-	go func() {
-		for {
-			for _, myProject := range gProjects {
-				switch myProject.Status {
-
-				case "build-pending":
-					gProjects[myProject.Id].Status = "build-running"
-					fmt.Fprintf(os.Stdout, "Building project \"%s\"...\n", myProject.Id)
-					myOutputLines := builder_build_project(myProject.Id)
-					fmt.Fprintf(os.Stdout, "Project \"%s\" built\n", myProject.Id)
-					gProjects[myProject.Id].BuildOutput = strings.Join(myOutputLines, "\n")
-					gProjects[myProject.Id].Status = ""
-
-				case "up-pending":
-					gProjects[myProject.Id].Status = "up-running"
-					myOutputLines := builder_docker_compose_up(myProject.Id)
-					gProjects[myProject.Id].BuildOutput = strings.Join(myOutputLines, "\n")
-					gProjects[myProject.Id].Status = ""
-
-				case "down-pending":
-					gProjects[myProject.Id].Status = "down-running"
-					myOutputLines := builder_docker_compose_down(myProject.Id)
-					gProjects[myProject.Id].BuildOutput = strings.Join(myOutputLines, "\n")
-					gProjects[myProject.Id].Status = ""
+	myWebMux.HandleFunc("/status", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+		builder_render_page(theHTTPResponse, theHTTPRequest, "status", builder_get_status_info(nil, ""))
+	})
+
+	myWebMux.HandleFunc("/status/clear-cache", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+
+		myAdminUser, myAdminOK := builder_check_admin(theHTTPResponse, theHTTPRequest)
+		if !myAdminOK {
+			return
+		}
+		if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+			return
+		}
+
+		myGoCache, myGoCacheExists := builder_find_go_cache(theHTTPRequest.PostFormValue("Cache"))
+		if !myGoCacheExists {
+			http.Error(theHTTPResponse, "Unknown cache", http.StatusNotFound)
+			return
+		}
+		myOutputLines, myClearErr := builder_clear_go_cache(myGoCache)
+		if myClearErr != nil {
+			builder_render_page_status(theHTTPResponse, theHTTPRequest, http.StatusConflict, "status", builder_get_status_info([]string{fmt.Sprintf("Cache %s not cleared : %v", myGoCache.Key, myClearErr)}, strings.Join(myOutputLines, "\n")))
+			return
+		}
+		builder_audit(theHTTPRequest, myAdminUser, "clear-cache", "", myGoCache.Key)
+		http.Redirect(theHTTPResponse, theHTTPRequest, "/status", http.StatusSeeOther)
+	})
+
+	myWebMux.HandleFunc("/audit", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
 
+		_, myAdminOK := builder_check_admin(theHTTPResponse, theHTTPRequest)
+		if !myAdminOK {
+			return
+		}
+
+		myProjectId := strings.TrimSpace(theHTTPRequest.URL.Query().Get("project"))
+		myAuditEntries, myAuditErr := builder_load_audit_entries(myProjectId)
+		myPageData := map[string]interface{}{
+			"Project": myProjectId,
+			"Entries": myAuditEntries,
+			"Error": "",
+		}
+		if myAuditErr != nil {
+			myPageData["Error"] = fmt.Sprintf("Audit log not read : %v", myAuditErr)
+		}
+		builder_render_page(theHTTPResponse, theHTTPRequest, "audit", myPageData)
+	})
+
+	myWebMux.HandleFunc("/new-project", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+
+		myAdminUser, myAdminOK := builder_check_admin(theHTTPResponse, theHTTPRequest)
+		if !myAdminOK {
+			return
+		}
+
+		myPageData := map[string]interface{}{
+			"Templates": gScaffoldTemplates,
+			"Template": gScaffoldTemplates[0].Id,
+			"Roots": gProjectsRoots,
+			"Root": gProjectsRoots[0].Label,
+		}
+
+		if theHTTPRequest.Method == http.MethodGet || theHTTPRequest.Method == http.MethodHead {
+			builder_render_page(theHTTPResponse, theHTTPRequest, "new-project", myPageData)
+			return
+		}
+		if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+			return
+		}
+
+		theHTTPRequest.ParseForm()
+		myRootLabel := strings.TrimSpace(theHTTPRequest.PostForm.Get("Root"))
+		myName := strings.TrimSpace(theHTTPRequest.PostForm.Get("Name"))
+		myTemplateId := strings.TrimSpace(theHTTPRequest.PostForm.Get("Template"))
+		myGitURL := strings.TrimSpace(theHTTPRequest.PostForm.Get("GitURL"))
+		myPageData["Root"] = myRootLabel
+		myPageData["Name"] = myName
+		myPageData["Template"] = myTemplateId
+		myPageData["GitURL"] = myGitURL
+
+		myErrors := builder_validate_new_project(myRootLabel, myName, myTemplateId, myGitURL)
+		if len(myErrors) == 0 {
+			myProjectsRoot, _ := builder_find_projects_root(myRootLabel)
+			myOutputLines, myCreateErr := builder_create_project(myProjectsRoot, myName, myTemplateId, myGitURL)
+			if myCreateErr == nil {
+				myProjectId := builder_get_root_project_id(myProjectsRoot, myName)
+				myDetails := "template "+myTemplateId
+				if myGitURL != "" {
+					myDetails = "git "+myGitURL
 				}
+				builder_audit(theHTTPRequest, myAdminUser, "new-project", myProjectId, myDetails)
+				builder_register_projects()
+				http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId), http.StatusSeeOther)
+				return
 			}
-			time.Sleep(1 * time.Second)
+			myErrors = append(myErrors, fmt.Sprintf("Project creation failed : %v", myCreateErr))
+			myPageData["Output"] = strings.Join(myOutputLines, "\n")
 		}
-	}()
-
-	myWebMux := http.NewServeMux()
 
-	myAssetsFiles, _ := fs.Sub(gEmbeddedAssets, "assets")
-	myWebMux.Handle("/assets/", http.StripPrefix("/assets/", http.FileServer(http.FS(myAssetsFiles))))
+		myPageData["Errors"] = myErrors
+		builder_render_page_status(theHTTPResponse, theHTTPRequest, http.StatusBadRequest, "new-project", myPageData)
+	})
 
 	myWebMux.HandleFunc("/", func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
 
@@ -568,22 +938,130 @@ func main () {
 		if len(myQueryStringParts) >= 2 {
 			myProjectVerb = myQueryStringParts[1]
 		}
+		myProjectVerbArg := ""
+		if len(myQueryStringParts) >= 3 {
+			myProjectVerbArg = myQueryStringParts[2]
+		}
 
 		if myProjectId != "" {
+			myProject := builder_get_project(myProjectId)
+			if myProject == nil {
+				http.NotFound(theHTTPResponse, theHTTPRequest)
+				return
+			}
+
 			if myProjectVerb != "" {
 				switch myProjectVerb {
 
 				case "build":
-					gProjects[myProjectId].Status = "build-pending"
-					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+myProjectId, http.StatusFound)
+					if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+						return
+					}
+					if !builder_check_accepting_jobs(theHTTPResponse) {
+						return
+					}
+					myQueued := builder_queue_project_job(myProjectId, "build", 0, "manual")
+					builder_audit_job(theHTTPRequest, "build", myProjectId, "", myQueued)
+					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId), http.StatusSeeOther)
 
 				case "up":
-					gProjects[myProjectId].Status = "up-pending"
-					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+myProjectId, http.StatusFound)
+					if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+						return
+					}
+					if !builder_check_accepting_jobs(theHTTPResponse) {
+						return
+					}
+					myQueued := builder_queue_project_job(myProjectId, "up", 0, "manual")
+					builder_audit_job(theHTTPRequest, "up", myProjectId, "", myQueued)
+					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId), http.StatusSeeOther)
 
 				case "down":
-					gProjects[myProjectId].Status = "down-pending"
-					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+myProjectId, http.StatusFound)
+					if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+						return
+					}
+					if !builder_check_accepting_jobs(theHTTPResponse) {
+						return
+					}
+					myQueued := builder_queue_project_job(myProjectId, "down", 0, "manual")
+					builder_audit_job(theHTTPRequest, "down", myProjectId, "", myQueued)
+					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId), http.StatusSeeOther)
+
+				case "deploy":
+					if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+						return
+					}
+					myBuildNumber, myAtoiErr := strconv.Atoi(myProjectVerbArg)
+					if myAtoiErr != nil || myBuildNumber <= 0 {
+						http.Error(theHTTPResponse, "Invalid build number", http.StatusBadRequest)
+						return
+					}
+					if !builder_check_accepting_jobs(theHTTPResponse) {
+						return
+					}
+					myQueued := builder_queue_project_job(myProjectId, "deploy", myBuildNumber, "manual")
+					builder_audit_job(theHTTPRequest, "deploy", myProjectId, fmt.Sprintf("build #%d", myBuildNumber), myQueued)
+					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId), http.StatusSeeOther)
+
+				case "settings":
+					myAdminUser, myAdminOK := builder_check_admin(theHTTPResponse, theHTTPRequest)
+					if !myAdminOK {
+						return
+					}
+
+					if theHTTPRequest.Method == http.MethodGet || theHTTPRequest.Method == http.MethodHead {
+						builder_render_page(theHTTPResponse, theHTTPRequest, "settings", map[string]interface{}{
+							"Id": myProjectId,
+							"Settings": builder_get_editable_settings(myProjectId, nil),
+						})
+						return
+					}
+					if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+						return
+					}
+
+					theHTTPRequest.ParseForm()
+					mySettingValues := make(map[string]string)
+					for _, mySettingKey := range gEditableSettingKeys {
+						mySettingValues[mySettingKey] = strings.TrimSpace(theHTTPRequest.PostForm.Get(mySettingKey))
+					}
+
+					mySettingsErrors := builder_validate_project_settings(myProjectId, mySettingValues)
+					if len(mySettingsErrors) > 0 {
+						builder_render_page_status(theHTTPResponse, theHTTPRequest, http.StatusBadRequest, "settings", map[string]interface{}{
+							"Id": myProjectId,
+							"Settings": builder_get_editable_settings(myProjectId, mySettingValues),
+							"Errors": mySettingsErrors,
+						})
+						return
+					}
+
+					mySettingsChanges, mySaveErr := builder_save_project_settings(myProjectId, mySettingValues)
+					if mySaveErr != nil {
+						http.Error(theHTTPResponse, fmt.Sprintf("Settings update failed : %v", mySaveErr), http.StatusInternalServerError)
+						return
+					}
+					if len(mySettingsChanges) > 0 {
+						builder_audit(theHTTPRequest, myAdminUser, "settings", myProjectId, strings.Join(mySettingsChanges, "; "))
+						builder_register_projects()
+					}
+					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId), http.StatusSeeOther)
+
+				case "prefetch":
+					if !builder_check_post(theHTTPResponse, theHTTPRequest) {
+						return
+					}
+					if !builder_check_accepting_jobs(theHTTPResponse) {
+						return
+					}
+					myQueued := builder_queue_project_job(myProjectId, "prefetch", 0, "manual")
+					builder_audit_job(theHTTPRequest, "prefetch", myProjectId, "", myQueued)
+					http.Redirect(theHTTPResponse, theHTTPRequest, "/"+url.PathEscape(myProjectId)+"/deps", http.StatusSeeOther)
+
+				case "deps":
+					builder_render_page(theHTTPResponse, theHTTPRequest, "deps", builder_get_project_deps_info(myProjectId))
+
+				case "binary":
+					builder_render_page(theHTTPResponse, theHTTPRequest, "binary", builder_get_project_binary_info(myProjectId))
 
 				case "info":
 
@@ -594,45 +1072,51 @@ func main () {
 						myInfoJSONResultBytes = []byte("{}")
 					}
 
+					theHTTPResponse.Header().Set("Content-Type", "application/json")
 					theHTTPResponse.Write(myInfoJSONResultBytes)
 				}
 
 				return
 			}
 
-			myPageText := builder_load_assets_html("index.header.html")
-
-			myInfoContentString := builder_load_assets_html("project/index.html")
-			myInfoContentString = strings.ReplaceAll(myInfoContentString, "[PROJECTID]", myProjectId)
-			myPageText += myInfoContentString
-			myPageText += builder_load_assets_html("index.footer.html")
-			theHTTPResponse.Write([]byte(myPageText))
+			builder_render_page(theHTTPResponse, theHTTPRequest, "project", myProject)
 			return
 		}
 
 		builder_register_projects()
 
-		myPageText := builder_load_assets_html("index.header.html")
-		myPageContent := builder_load_assets_html("projects/index.html")
-
-		myProjectTemplate := builder_load_assets_html("projects/project.html")
-		myProjectsString := ""
-
-		for _, myProjectId := range gOrderedProjectIds {
-			myProject := gProjects[myProjectId]
-			myProjectString := strings.ReplaceAll(myProjectTemplate, "[PROJECTID]", myProject.Id)
-			myProjectString = strings.ReplaceAll(myProjectString, "[PROJECTENGINE]", myProject.Engine)
-			myProjectsString += myProjectString
-		}
-		myPageContent = strings.ReplaceAll(myPageContent, "[PROJECTS]", myProjectsString)
+		builder_render_page(theHTTPResponse, theHTTPRequest, "projects", builder_get_ordered_projects())
+	})
 
-		myPageText += myPageContent
+	if gServerConfig.GoProxyListenAddr != "" {
+		go builder_serve_go_proxy_listener()
+	}
 
-		myPageText += builder_load_assets_html("index.footer.html")
-		theHTTPResponse.Write([]byte(myPageText))
-	})
+	myServer := &http.Server{Addr: gServerConfig.ListenAddr, Handler: builder_log_requests(myWebMux)}
+	go builder_handle_shutdown_signals(myServer)
 
-	fmt.Fprintf(os.Stdout, "Builder listening\n")
-	http.ListenAndServe(":80", myWebMux)
+	var myServeErr error
+	if gServerConfig.TLSEnabled {
+		myTLSConfig, myTLSErr := builder_get_tls_config()
+		if myTLSErr != nil {
+			gLogger.Error("TLS setup failed", "error", myTLSErr)
+			os.Exit(2)
+		}
+		myServer.TLSConfig = myTLSConfig
+		if gServerConfig.HTTPRedirectAddr != "" {
+			go builder_serve_http_redirect()
+		}
+		gLogger.Info("builder listening", "addr", gServerConfig.ListenAddr, "tls", true)
+		myServeErr = myServer.ListenAndServeTLS("", "")
+	} else {
+		gLogger.Info("builder listening", "addr", gServerConfig.ListenAddr, "tls", false)
+		myServeErr = myServer.ListenAndServe()
+	}
+	if myServeErr == http.ErrServerClosed {
+		// builder_handle_shutdown_signals exits once done
+		select {}
+	}
+	gLogger.Error("builder stopped", "error", myServeErr)
+	os.Exit(1)
 
 }
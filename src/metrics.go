@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kMetricsURLPath = "/metrics"
+
+// Upper bounds, in seconds, of the build duration histogram buckets.
+var gBuildDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+type BuildDurationHistogram struct {
+    Counts []int64 // builds of every bucket, not cumulated, the last one for +Inf
+    Sum float64 // seconds
+    Count int64
+}
+
+// Counters since the start of the builder, guarded by gMetricsMutex.
+var gMetricsMutex sync.Mutex
+var gBuildsTotal = make(map[[2]string]int64) // by project and result
+var gBuildDurations = make(map[string]*BuildDurationHistogram) // by project
+var gComposeOperationsTotal = make(map[[3]string]int64) // by project, operation ("up", "down", "deploy") and result
+var gDockerErrorsTotal = make(map[string]int64) // by command, ex : "docker push"
+var gLastSuccessTimes = make(map[string]time.Time) // by project, loaded from the history on the first scrape
+var gLastSuccessLoaded = make(map[string]bool)
+
+//------------------------------------------------------------------------------
+
+func builder_record_build_metrics (theRecord *BuildRecord) {
+
+	gMetricsMutex.Lock()
+	defer gMetricsMutex.Unlock()
+
+	gBuildsTotal[[2]string{theRecord.ProjectId, theRecord.Result}]++
+
+	myHistogram, myHistogramExists := gBuildDurations[theRecord.ProjectId]
+	if !myHistogramExists {
+		myHistogram = &BuildDurationHistogram{Counts: make([]int64, len(gBuildDurationBuckets)+1)}
+		gBuildDurations[theRecord.ProjectId] = myHistogram
+	}
+	myDuration := theRecord.FinishedAt.Sub(theRecord.StartedAt).Seconds()
+	myBucketIndex := sort.SearchFloat64s(gBuildDurationBuckets, myDuration)
+	myHistogram.Counts[myBucketIndex]++
+	myHistogram.Sum += myDuration
+	myHistogram.Count++
+
+	if theRecord.Result == "ok" {
+		gLastSuccessTimes[theRecord.ProjectId] = theRecord.FinishedAt
+		gLastSuccessLoaded[theRecord.ProjectId] = true
+	}
+}
+
+func builder_record_compose_metrics (theProjectId string, theOperation string, theResult string) {
+	gMetricsMutex.Lock()
+	gComposeOperationsTotal[[3]string{theProjectId, theOperation, theResult}]++
+	gMetricsMutex.Unlock()
+}
+
+// Docker commands grouping subcommands, whose label keeps the subcommand, ex : "docker image inspect".
+var gDockerManagementCommands = []string{"buildx", "builder", "compose", "container", "image", "network", "system", "volume"}
+
+// Logs and counts a failed docker or docker-compose command, by command name and subcommand.
+func builder_record_docker_error (theName string, theArgs []string, theErr error) {
+	myCommand := theName
+	if len(theArgs) > 0 {
+		myCommand += " "+theArgs[0]
+		if len(theArgs) > 1 && builder_contains_string(gDockerManagementCommands, theArgs[0]) {
+			myCommand += " "+theArgs[1]
+		}
+	}
+	gLogger.Warn("docker command failed", "command", myCommand, "error", theErr)
+	gMetricsMutex.Lock()
+	gDockerErrorsTotal[myCommand]++
+	gMetricsMutex.Unlock()
+}
+
+//------------------------------------------------------------------------------
+
+func builder_escape_metric_label (theValue string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(theValue)
+}
+
+func builder_write_metric_header (theHTTPResponse http.ResponseWriter, theName string, theType string, theHelp string) {
+	fmt.Fprintf(theHTTPResponse, "# HELP %s %s\n# TYPE %s %s\n", theName, theHelp, theName, theType)
+}
+
+// Serves the metrics in the Prometheus text format : the counters since the start of the builder,
+// the jobs and the per-project gauges read at every scrape.
+func builder_serve_metrics (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+
+	gProjectsMutex.Lock()
+	myProjectIds := append([]string{}, gOrderedProjectIds...)
+	myTargetFilePaths := make(map[string]string)
+	myPendingJobs := 0
+	for _, myProjectId := range myProjectIds {
+		if strings.HasSuffix(gProjects[myProjectId].Status, "-pending") {
+			myPendingJobs++
+		}
+		myTargetFilePaths[myProjectId] = gProjects[myProjectId].TargetFilePath
+	}
+	myRunningJobs := gRunningJobs
+	gProjectsMutex.Unlock()
+
+	// the last success of the builds before the start of the builder
+	for _, myProjectId := range myProjectIds {
+		gMetricsMutex.Lock()
+		myLoaded := gLastSuccessLoaded[myProjectId]
+		gMetricsMutex.Unlock()
+		if myLoaded {
+			continue
+		}
+		var myLastSuccessTime time.Time
+		for _, myRecord := range builder_load_build_history(myProjectId) {
+			if myRecord.Result == "ok" && myRecord.FinishedAt.After(myLastSuccessTime) {
+				myLastSuccessTime = myRecord.FinishedAt
+			}
+		}
+		gMetricsMutex.Lock()
+		if !gLastSuccessLoaded[myProjectId] {
+			gLastSuccessLoaded[myProjectId] = true
+			if !myLastSuccessTime.IsZero() {
+				gLastSuccessTimes[myProjectId] = myLastSuccessTime
+			}
+		}
+		gMetricsMutex.Unlock()
+	}
+
+	theHTTPResponse.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_jobs_pending", "gauge", "Jobs waiting for a worker.")
+	fmt.Fprintf(theHTTPResponse, "go_builder_jobs_pending %d\n", myPendingJobs)
+	builder_write_metric_header(theHTTPResponse, "go_builder_jobs_running", "gauge", "Jobs running.")
+	fmt.Fprintf(theHTTPResponse, "go_builder_jobs_running %d\n", myRunningJobs)
+	builder_write_metric_header(theHTTPResponse, "go_builder_workers", "gauge", "Jobs run at the same time at most.")
+	fmt.Fprintf(theHTTPResponse, "go_builder_workers %d\n", gServerConfig.Workers)
+
+	gMetricsMutex.Lock()
+	defer gMetricsMutex.Unlock()
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_builds_total", "counter", "Builds since the start of the builder, by project and result.")
+	var myBuildKeys [][2]string
+	for myBuildKey := range gBuildsTotal {
+		myBuildKeys = append(myBuildKeys, myBuildKey)
+	}
+	sort.Slice(myBuildKeys, func(i, j int) bool {
+		return myBuildKeys[i][0]+"\x00"+myBuildKeys[i][1] < myBuildKeys[j][0]+"\x00"+myBuildKeys[j][1]
+	})
+	for _, myBuildKey := range myBuildKeys {
+		fmt.Fprintf(theHTTPResponse, "go_builder_builds_total{project=\"%s\",result=\"%s\"} %d\n", builder_escape_metric_label(myBuildKey[0]), myBuildKey[1], gBuildsTotal[myBuildKey])
+	}
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_build_duration_seconds", "histogram", "Duration of the builds, by project.")
+	var myHistogramProjectIds []string
+	for myProjectId := range gBuildDurations {
+		myHistogramProjectIds = append(myHistogramProjectIds, myProjectId)
+	}
+	sort.Strings(myHistogramProjectIds)
+	for _, myProjectId := range myHistogramProjectIds {
+		myHistogram := gBuildDurations[myProjectId]
+		myProjectLabel := builder_escape_metric_label(myProjectId)
+		var myCumulatedCount int64
+		for myBucketIndex, myBucketCount := range myHistogram.Counts {
+			myCumulatedCount += myBucketCount
+			myUpperBound := "+Inf"
+			if myBucketIndex < len(gBuildDurationBuckets) {
+				myUpperBound = fmt.Sprint(gBuildDurationBuckets[myBucketIndex])
+			}
+			fmt.Fprintf(theHTTPResponse, "go_builder_build_duration_seconds_bucket{project=\"%s\",le=\"%s\"} %d\n", myProjectLabel, myUpperBound, myCumulatedCount)
+		}
+		fmt.Fprintf(theHTTPResponse, "go_builder_build_duration_seconds_sum{project=\"%s\"} %g\n", myProjectLabel, myHistogram.Sum)
+		fmt.Fprintf(theHTTPResponse, "go_builder_build_duration_seconds_count{project=\"%s\"} %d\n", myProjectLabel, myHistogram.Count)
+	}
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_compose_operations_total", "counter", "Compose up and down since the start of the builder, by project, operation and result.")
+	var myComposeKeys [][3]string
+	for myComposeKey := range gComposeOperationsTotal {
+		myComposeKeys = append(myComposeKeys, myComposeKey)
+	}
+	sort.Slice(myComposeKeys, func(i, j int) bool {
+		return strings.Join(myComposeKeys[i][:], "\x00") < strings.Join(myComposeKeys[j][:], "\x00")
+	})
+	for _, myComposeKey := range myComposeKeys {
+		fmt.Fprintf(theHTTPResponse, "go_builder_compose_operations_total{project=\"%s\",operation=\"%s\",result=\"%s\"} %d\n", builder_escape_metric_label(myComposeKey[0]), myComposeKey[1], myComposeKey[2], gComposeOperationsTotal[myComposeKey])
+	}
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_docker_errors_total", "counter", "Failed docker and docker-compose commands since the start of the builder, by command.")
+	var myDockerCommands []string
+	for myDockerCommand := range gDockerErrorsTotal {
+		myDockerCommands = append(myDockerCommands, myDockerCommand)
+	}
+	sort.Strings(myDockerCommands)
+	for _, myDockerCommand := range myDockerCommands {
+		fmt.Fprintf(theHTTPResponse, "go_builder_docker_errors_total{command=\"%s\"} %d\n", builder_escape_metric_label(myDockerCommand), gDockerErrorsTotal[myDockerCommand])
+	}
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_last_success_timestamp_seconds", "gauge", "End time of the last successful build, by project.")
+	for _, myProjectId := range myProjectIds {
+		myLastSuccessTime, myHasSucceeded := gLastSuccessTimes[myProjectId]
+		if myHasSucceeded {
+			fmt.Fprintf(theHTTPResponse, "go_builder_last_success_timestamp_seconds{project=\"%s\"} %d\n", builder_escape_metric_label(myProjectId), myLastSuccessTime.Unix())
+		}
+	}
+
+	builder_write_metric_header(theHTTPResponse, "go_builder_artifact_size_bytes", "gauge", "Size of the built program, by project.")
+	for _, myProjectId := range myProjectIds {
+		myTargetFileInfo, myStatErr := os.Stat(myTargetFilePaths[myProjectId])
+		if myStatErr == nil {
+			fmt.Fprintf(theHTTPResponse, "go_builder_artifact_size_bytes{project=\"%s\"} %d\n", builder_escape_metric_label(myProjectId), myTargetFileInfo.Size())
+		}
+	}
+}
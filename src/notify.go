@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kNotifyDirName = "notify"
+const kDefaultNotifyOn = "failure;recovery"
+const kNotifyTimeout = 10 * time.Second
+const kNotifyOutputLines = 20 // last output lines sent with the webhook and email notifications
+
+var gNotifyJobs = []string{"build", "up", "down", "deploy"}
+var gNotifyEvents = []string{"success", "failure", "recovery"}
+
+// Notifiers of a project, and the job events they are sent for.
+type NotifyOptions struct {
+    Webhooks []string // generic webhooks, receiving the event as a JSON POST
+    Chats []string // Slack or Mattermost incoming webhooks
+    Emails []string // addresses mailed through SMTPAddr
+    On []string // "<event>" or "<job>:<event>" filters, default : "failure;recovery"
+}
+
+// Event sent to the notifiers, the JSON body of the generic webhooks.
+type NotifyEvent struct {
+    Project string `json:"project"`
+    Job string `json:"job"` // "build", "up", "down", "deploy"
+    Event string `json:"event"` // "success", "failure", "recovery" (a success after a failure)
+    Result string `json:"result"` // "ok", "failed"
+    Build int `json:"build,omitempty"`
+    FailureReason string `json:"failureReason,omitempty"`
+    Trigger string `json:"trigger,omitempty"`
+    GoVersion string `json:"goVersion,omitempty"`
+    Time time.Time `json:"time"`
+    Output []string `json:"output"` // last output lines
+}
+
+var gNotifyMutex sync.Mutex // guards the last results files
+
+//------------------------------------------------------------------------------
+
+func builder_load_notify_options (theSettings map[string]string) NotifyOptions {
+
+	myOptions := NotifyOptions{Webhooks: builder_split_setting_list(theSettings["NotifyWebhook"]),
+		Chats: builder_split_setting_list(theSettings["NotifyChat"]),
+		Emails: builder_split_setting_list(theSettings["NotifyEmail"]),
+		On: builder_split_setting_list(kDefaultNotifyOn),
+	}
+	if strings.TrimSpace(theSettings["NotifyOn"]) != "" {
+		myOptions.On = builder_split_setting_list(strings.ToLower(theSettings["NotifyOn"]))
+	}
+
+	return myOptions
+}
+
+// Returns one message per invalid notify setting.
+func builder_validate_notify_settings (theValues map[string]string) []string {
+
+	var myErrors []string
+
+	for _, myURLKey := range []string{"NotifyWebhook", "NotifyChat"} {
+		for _, myURL := range builder_split_setting_list(theValues[myURLKey]) {
+			if !strings.HasPrefix(myURL, "http://") && !strings.HasPrefix(myURL, "https://") {
+				myErrors = append(myErrors, myURLKey+" : http(s) URL expected, got \""+myURL+"\"")
+			}
+		}
+	}
+
+	for _, myEmail := range builder_split_setting_list(theValues["NotifyEmail"]) {
+		if !strings.Contains(myEmail, "@") || strings.ContainsAny(myEmail, " ,<>") {
+			myErrors = append(myErrors, "NotifyEmail : invalid address \""+myEmail+"\"")
+		}
+	}
+	if theValues["NotifyEmail"] != "" && gServerConfig.SMTPAddr == "" {
+		myErrors = append(myErrors, "NotifyEmail : no SMTPAddr in the server config")
+	}
+
+	for _, myFilter := range builder_split_setting_list(strings.ToLower(theValues["NotifyOn"])) {
+		myJob, myEvent, myHasJob := strings.Cut(myFilter, ":")
+		if !myHasJob {
+			myJob, myEvent = "", myFilter
+		}
+		if (myHasJob && !builder_contains_string(gNotifyJobs, myJob)) || !builder_contains_string(gNotifyEvents, myEvent) {
+			myErrors = append(myErrors, "NotifyOn : invalid filter \""+myFilter+"\", expected <event> or <job>:<event>, events "+strings.Join(gNotifyEvents, ", ")+", jobs "+strings.Join(gNotifyJobs, ", "))
+		}
+	}
+
+	return myErrors
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_notify_results_filepath (theProjectId string) string {
+	return filepath.Join(gDataDirPath, kNotifyDirName, theProjectId+".json")
+}
+
+// Saves the result of a job and returns the one of the job before, empty when unknown.
+func builder_swap_job_last_result (theProjectId string, theJob string, theResult string) string {
+
+	gNotifyMutex.Lock()
+	defer gNotifyMutex.Unlock()
+
+	myResultsFilePath := builder_get_notify_results_filepath(theProjectId)
+	myLastResults := make(map[string]string)
+	myResultsBytes, myReadErr := os.ReadFile(myResultsFilePath)
+	if myReadErr == nil {
+		json.Unmarshal(myResultsBytes, &myLastResults)
+	}
+	myLastResult := myLastResults[theJob]
+	myLastResults[theJob] = theResult
+
+	myWriteErr := os.MkdirAll(filepath.Dir(myResultsFilePath), 0755)
+	if myWriteErr == nil {
+		myResultsBytes, _ = json.Marshal(myLastResults)
+		myWriteErr = os.WriteFile(myResultsFilePath, myResultsBytes, 0644)
+	}
+	if myWriteErr != nil {
+		gLogger.Error("last job results not saved", "project", theProjectId, "error", myWriteErr)
+	}
+
+	return myLastResult
+}
+
+func builder_is_notify_event_selected (theFilters []string, theJob string, theEvent string) bool {
+	for _, myFilter := range theFilters {
+		if myFilter == theEvent || myFilter == theJob+":"+theEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifies the end of a build, up, down or deploy job, "ok" or "failed", to the notifiers of the project
+// whose filters select it. A recovery is also a success : "success" selects it too.
+// The notifiers are called in the background, their failures are only logged.
+func builder_notify_job_result (theProjectId string, theJob string, theResult string, theOutputLines []string, theRecord *BuildRecord) {
+
+	myLastResult := builder_swap_job_last_result(theProjectId, theJob, theResult)
+
+	gProjectsMutex.Lock()
+	myProject, myProjectExists := gProjects[theProjectId]
+	var myOptions NotifyOptions
+	if myProjectExists {
+		myOptions = myProject.Notify
+	}
+	gProjectsMutex.Unlock()
+	if len(myOptions.Webhooks)+len(myOptions.Chats)+len(myOptions.Emails) == 0 {
+		return
+	}
+
+	myEvent := NotifyEvent{Project: theProjectId, Job: theJob, Result: theResult, Time: time.Now(), Event: "failure"}
+	if theResult == "ok" {
+		myEvent.Event = "success"
+		if myLastResult == "failed" {
+			myEvent.Event = "recovery"
+		}
+	}
+	if !builder_is_notify_event_selected(myOptions.On, theJob, myEvent.Event) &&
+		!(myEvent.Event == "recovery" && builder_is_notify_event_selected(myOptions.On, theJob, "success")) {
+		return
+	}
+	if theRecord != nil {
+		myEvent.Build = theRecord.Number
+		myEvent.FailureReason = theRecord.FailureReason
+		myEvent.Trigger = theRecord.Trigger
+		myEvent.GoVersion = theRecord.GoVersion
+	}
+	for len(theOutputLines) > 0 && strings.TrimSpace(theOutputLines[len(theOutputLines)-1]) == "" {
+		theOutputLines = theOutputLines[:len(theOutputLines)-1]
+	}
+	myEvent.Output = theOutputLines[max(len(theOutputLines)-kNotifyOutputLines, 0):]
+
+	go builder_send_notifications(myOptions, myEvent)
+}
+
+// One line summary of an event, ex : "hello : build #12 failed (time limit)".
+func builder_get_notify_summary (theEvent NotifyEvent) string {
+	mySummary := theEvent.Project+" : "+theEvent.Job
+	if theEvent.Build > 0 {
+		mySummary += fmt.Sprintf(" #%d", theEvent.Build)
+	}
+	switch theEvent.Event {
+	case "recovery":
+		mySummary += " recovered"
+	case "success":
+		mySummary += " succeeded"
+	default:
+		mySummary += " failed"
+		if theEvent.FailureReason != "" {
+			mySummary += " ("+theEvent.FailureReason+")"
+		}
+	}
+	return mySummary
+}
+
+//------------------------------------------------------------------------------
+
+func builder_send_notifications (theOptions NotifyOptions, theEvent NotifyEvent) {
+
+	mySummary := builder_get_notify_summary(theEvent)
+
+	myEventBytes, _ := json.Marshal(theEvent)
+	for _, myWebhookURL := range theOptions.Webhooks {
+		builder_log_notify_error(theEvent, "webhook", builder_post_notify_json(myWebhookURL, myEventBytes))
+	}
+
+	// the text payload of the Slack incoming webhooks, also accepted by Mattermost
+	myChatBytes, _ := json.Marshal(map[string]string{"text": mySummary})
+	for _, myChatURL := range theOptions.Chats {
+		builder_log_notify_error(theEvent, "chat", builder_post_notify_json(myChatURL, myChatBytes))
+	}
+
+	if len(theOptions.Emails) > 0 {
+		myBody := mySummary+"\n\n"
+		if theEvent.Trigger != "" {
+			myBody += "Trigger : "+theEvent.Trigger+"\n"
+		}
+		if theEvent.GoVersion != "" {
+			myBody += "Go version : "+theEvent.GoVersion+"\n"
+		}
+		myBody += "Time : "+theEvent.Time.Format(time.RFC1123)+"\n\n"+strings.Join(theEvent.Output, "\n")+"\n"
+		builder_log_notify_error(theEvent, "email", builder_send_notify_email(theOptions.Emails, "[go-builder] "+mySummary, myBody))
+	}
+}
+
+func builder_log_notify_error (theEvent NotifyEvent, theNotifier string, theErr error) {
+	if theErr != nil {
+		gLogger.Warn("notification not sent", "project", theEvent.Project, "job", theEvent.Job, "notifier", theNotifier, "error", theErr)
+	}
+}
+
+func builder_post_notify_json (theURL string, theBodyBytes []byte) error {
+
+	myClient := http.Client{Timeout: kNotifyTimeout}
+	myResponse, myPostErr := myClient.Post(theURL, "application/json", bytes.NewReader(theBodyBytes))
+	if myPostErr != nil {
+		return myPostErr
+	}
+	defer myResponse.Body.Close()
+	if myResponse.StatusCode < 200 || myResponse.StatusCode > 299 {
+		return fmt.Errorf("%s answered %s", theURL, myResponse.Status)
+	}
+	return nil
+}
+
+// Sends a plain text email through SMTPAddr, with PLAIN auth when SMTPUser is set.
+func builder_send_notify_email (theRecipients []string, theSubject string, theBody string) error {
+
+	if gServerConfig.SMTPAddr == "" {
+		return fmt.Errorf("no SMTPAddr in the server config")
+	}
+
+	var myAuth smtp.Auth
+	if gServerConfig.SMTPUser != "" {
+		mySMTPHost := strings.Split(gServerConfig.SMTPAddr, ":")[0]
+		myAuth = smtp.PlainAuth("", gServerConfig.SMTPUser, gServerConfig.SMTPPassword, mySMTPHost)
+	}
+
+	myMessage := "From: "+gServerConfig.SMTPFrom+"\r\n"+
+		"To: "+strings.Join(theRecipients, ", ")+"\r\n"+
+		"Subject: "+theSubject+"\r\n"+
+		"Date: "+time.Now().Format(time.RFC1123Z)+"\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+strings.ReplaceAll(theBody, "\n", "\r\n")
+
+	return smtp.SendMail(gServerConfig.SMTPAddr, myAuth, gServerConfig.SMTPFrom, theRecipients, []byte(myMessage))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const kProjectsRootsEnvName = "GO_BUILDER_PROJECTS_ROOTS"
+const kProjectsRootSeparator = ":"
+
+type ProjectsRoot struct {
+    Label string // prefix of the project ids, optional when there is a single root
+    DirPath string
+}
+
+// Projects dirs, "/opt/dev" unless configured.
+var gProjectsRoots []ProjectsRoot
+
+var gProjectsRootLabelRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+//------------------------------------------------------------------------------
+
+// Parses "label=/path;other=/other/path", a single root may be a plain path.
+func builder_parse_projects_roots (theRootsValue string) ([]ProjectsRoot, error) {
+
+	var myProjectsRoots []ProjectsRoot
+
+	for _, myRootValue := range builder_split_setting_list(theRootsValue) {
+		myProjectsRoot := ProjectsRoot{DirPath: myRootValue}
+		myEqualPos := strings.Index(myRootValue, "=")
+		if myEqualPos >= 0 {
+			myProjectsRoot.Label = strings.TrimSpace(myRootValue[0:myEqualPos])
+			myProjectsRoot.DirPath = strings.TrimSpace(myRootValue[myEqualPos+1:])
+		}
+		myProjectsRoots = append(myProjectsRoots, myProjectsRoot)
+	}
+
+	return myProjectsRoots, builder_validate_projects_roots(myProjectsRoots)
+}
+
+func builder_validate_projects_roots (theProjectsRoots []ProjectsRoot) error {
+
+	if len(theProjectsRoots) == 0 {
+		return fmt.Errorf("no projects root")
+	}
+
+	myLabels := make(map[string]bool)
+	for _, myProjectsRoot := range theProjectsRoots {
+		if !filepath.IsAbs(myProjectsRoot.DirPath) {
+			return fmt.Errorf("projects root \"%s\" : absolute path expected", myProjectsRoot.DirPath)
+		}
+		if myProjectsRoot.Label == "" && len(theProjectsRoots) > 1 {
+			return fmt.Errorf("projects root \"%s\" : a label is required when there are several roots", myProjectsRoot.DirPath)
+		}
+		if myProjectsRoot.Label != "" && !gProjectsRootLabelRegexp.MatchString(myProjectsRoot.Label) {
+			return fmt.Errorf("projects root \"%s\" : invalid label \"%s\"", myProjectsRoot.DirPath, myProjectsRoot.Label)
+		}
+		if myLabels[myProjectsRoot.Label] {
+			return fmt.Errorf("projects root \"%s\" : duplicate label \"%s\"", myProjectsRoot.DirPath, myProjectsRoot.Label)
+		}
+		myLabels[myProjectsRoot.Label] = true
+	}
+
+	return nil
+}
+
+func builder_find_projects_root (theLabel string) (ProjectsRoot, bool) {
+	for _, myProjectsRoot := range gProjectsRoots {
+		if myProjectsRoot.Label == theLabel {
+			return myProjectsRoot, true
+		}
+	}
+	return ProjectsRoot{}, false
+}
+
+// Namespaces a project dir name with the label of its root : "<label>:<dir>".
+func builder_get_root_project_id (theProjectsRoot ProjectsRoot, theDirName string) string {
+	if theProjectsRoot.Label == "" {
+		return theDirName
+	}
+	return theProjectsRoot.Label+kProjectsRootSeparator+theDirName
+}
+
+// Id of a project within its root, without the root label : the id of builder.ignore, and the id before the roots were labelled.
+func builder_get_unlabelled_project_id (theProjectsRoot ProjectsRoot, theProjectId string) string {
+	if theProjectsRoot.Label == "" {
+		return theProjectId
+	}
+	return strings.TrimPrefix(theProjectId, theProjectsRoot.Label+kProjectsRootSeparator)
+}
+
+// Renames the data files (history, deps, notify results, job record, binary snapshots) of a project
+// registered under an unlabelled id, once its root gets a label. The first root holding the project wins.
+func builder_migrate_project_data_files (theOldProjectId string, theProjectId string) {
+
+	if theOldProjectId == theProjectId {
+		return
+	}
+
+	myFilePathFuncs := []func(string) string{
+		builder_get_history_filepath,
+		builder_get_deps_listing_filepath,
+		builder_get_notify_results_filepath,
+		builder_get_job_record_filepath,
+		func(theId string) string { return builder_get_binary_snapshot_filepath(theId, false) },
+		func(theId string) string { return builder_get_binary_snapshot_filepath(theId, true) },
+	}
+	for _, myFilePathFunc := range myFilePathFuncs {
+		myOldFilePath := myFilePathFunc(theOldProjectId)
+		myFilePath := myFilePathFunc(theProjectId)
+		_, myOldStatErr := os.Stat(myOldFilePath)
+		_, myStatErr := os.Stat(myFilePath)
+		if myOldStatErr != nil || myStatErr == nil {
+			continue
+		}
+		myRenameErr := os.Rename(myOldFilePath, myFilePath)
+		if myRenameErr != nil {
+			gLogger.Warn("project data file not migrated", "project", theProjectId, "file", myOldFilePath, "error", myRenameErr)
+			continue
+		}
+		gLogger.Info("project data file migrated", "project", theProjectId, "from", myOldFilePath, "to", myFilePath)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Repeatable -projects-root flag, "label=/path" or "/path".
+type ProjectsRootsFlag []string
+
+func (theFlag *ProjectsRootsFlag) String () string {
+	return strings.Join(*theFlag, ";")
+}
+
+func (theFlag *ProjectsRootsFlag) Set (theValue string) error {
+	*theFlag = append(*theFlag, theValue)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilderParseProjectsRoots (theTest *testing.T) {
+
+	myTestCases := []struct {
+		Value string
+		Valid bool
+		Roots []ProjectsRoot
+	}{
+		{Value: "/opt/dev", Valid: true, Roots: []ProjectsRoot{{DirPath: "/opt/dev"}}},
+		{Value: "dev=/opt/dev", Valid: true, Roots: []ProjectsRoot{{Label: "dev", DirPath: "/opt/dev"}}},
+		{Value: " dev = /opt/dev ; ops=/srv/ops ;", Valid: true, Roots: []ProjectsRoot{{Label: "dev", DirPath: "/opt/dev"}, {Label: "ops", DirPath: "/srv/ops"}}},
+		{Value: "a_1=/a;b-2=/b", Valid: true, Roots: []ProjectsRoot{{Label: "a_1", DirPath: "/a"}, {Label: "b-2", DirPath: "/b"}}},
+		{Value: "", Valid: false},
+		{Value: " ; ", Valid: false},
+		{Value: "opt/dev", Valid: false},
+		{Value: "dev=opt/dev", Valid: false},
+		{Value: "/opt/dev;/srv/ops", Valid: false},
+		{Value: "dev=/opt/dev;/srv/ops", Valid: false},
+		{Value: "dev=/opt/dev;dev=/srv/ops", Valid: false},
+		{Value: "Dev=/opt/dev", Valid: false},
+		{Value: "_dev=/opt/dev", Valid: false},
+		{Value: "a:b=/opt/dev", Valid: false},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myRoots, myParseErr := builder_parse_projects_roots(myTestCase.Value)
+		if (myParseErr == nil) != myTestCase.Valid {
+			theTest.Errorf("%q : error %v, expected valid %v", myTestCase.Value, myParseErr, myTestCase.Valid)
+			continue
+		}
+		if myTestCase.Valid && !reflect.DeepEqual(myRoots, myTestCase.Roots) {
+			theTest.Errorf("%q : %v, expected %v", myTestCase.Value, myRoots, myTestCase.Roots)
+		}
+	}
+}
+
+func TestBuilderIsProjectIgnoredInLabelledRoot (theTest *testing.T) {
+
+	myIgnorePatterns := []string{"tools@*", "legacy"}
+	myTestCases := []struct {
+		Root ProjectsRoot
+		ProjectId string
+		Ignored bool
+	}{
+		{Root: ProjectsRoot{DirPath: "/opt/dev"}, ProjectId: "tools@cli", Ignored: true},
+		{Root: ProjectsRoot{Label: "dev", DirPath: "/opt/dev"}, ProjectId: "dev:tools@cli", Ignored: true},
+		{Root: ProjectsRoot{Label: "dev", DirPath: "/opt/dev"}, ProjectId: "dev:legacy", Ignored: true},
+		{Root: ProjectsRoot{Label: "dev", DirPath: "/opt/dev"}, ProjectId: "dev:myservice", Ignored: false},
+		{Root: ProjectsRoot{Label: "ops", DirPath: "/srv/ops"}, ProjectId: "ops:dev:legacy", Ignored: false},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myIgnored := builder_is_project_ignored(builder_get_unlabelled_project_id(myTestCase.Root, myTestCase.ProjectId), myIgnorePatterns)
+		if myIgnored != myTestCase.Ignored {
+			theTest.Errorf("%s in %q : ignored %v, expected %v", myTestCase.ProjectId, myTestCase.Root.Label, myIgnored, myTestCase.Ignored)
+		}
+	}
+}
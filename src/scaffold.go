@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+const kScaffoldsAssetsDirPath = "assets/scaffolds"
+const kScaffoldTemplateSuffix = ".tmpl"
+
+type ScaffoldTemplate struct {
+    Id string // sub dir of assets/scaffolds
+    Label string
+    Engine string
+}
+
+var gScaffoldTemplates = []ScaffoldTemplate{
+	{Id: "cli", Label: "CLI tool", Engine: "go"},
+	{Id: "http", Label: "HTTP service, with Dockerfile and compose file", Engine: "go"},
+	{Id: "cgo-sqlite", Label: "cgo + sqlite service, with Dockerfile and compose file", Engine: "cgo"},
+}
+
+var gProjectNameRegexp = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+// Remote git URLs only : https://, ssh://, git:// or the scp-like user@host:path, never file:// nor the ext:: transport.
+var gGitURLRegexp = regexp.MustCompile(`^((https|ssh|git)://([A-Za-z0-9._~%-]+@)?[A-Za-z0-9\[][A-Za-z0-9.:\[\]-]*/|[A-Za-z0-9_][A-Za-z0-9._-]*@[A-Za-z0-9][A-Za-z0-9.-]*:)[^\s]+$`)
+const kGitAllowedProtocols = "https:ssh:git"
+
+// Top level paths already served by the builder itself.
+var gReservedProjectNames = []string{"assets", "projects.json", "new-project", "ca.crt", "status", "goproxy", "metrics", "audit"}
+
+//------------------------------------------------------------------------------
+
+func builder_find_scaffold_template (theTemplateId string) (ScaffoldTemplate, bool) {
+	for _, myScaffoldTemplate := range gScaffoldTemplates {
+		if myScaffoldTemplate.Id == theTemplateId {
+			return myScaffoldTemplate, true
+		}
+	}
+	return ScaffoldTemplate{}, false
+}
+
+func builder_validate_new_project (theRootLabel string, theName string, theTemplateId string, theGitURL string) []string {
+
+	var myErrors []string
+
+	myProjectsRoot, myRootExists := builder_find_projects_root(theRootLabel)
+	if !myRootExists {
+		myErrors = append(myErrors, "Root : unknown projects root \""+theRootLabel+"\"")
+		return myErrors
+	}
+
+	if !gProjectNameRegexp.MatchString(theName) {
+		myErrors = append(myErrors, "Name : lowercase letters, digits, '.', '_' and '-' only")
+	}
+	for _, myReservedName := range gReservedProjectNames {
+		if theName == myReservedName {
+			myErrors = append(myErrors, "Name : \""+theName+"\" is reserved")
+		}
+	}
+	if theName != "" {
+		_, myStatErr := os.Stat(filepath.Join(myProjectsRoot.DirPath, theName))
+		if !os.IsNotExist(myStatErr) {
+			myErrors = append(myErrors, "Name : \""+theName+"\" already exists")
+		}
+	}
+
+	if theGitURL != "" {
+		if !gGitURLRegexp.MatchString(theGitURL) {
+			myErrors = append(myErrors, "Git URL : https://, ssh://, git:// or user@host:path URL expected")
+		}
+	} else {
+		_, myTemplateExists := builder_find_scaffold_template(theTemplateId)
+		if !myTemplateExists {
+			myErrors = append(myErrors, "Template : choose a template or a git URL")
+		}
+	}
+
+	return myErrors
+}
+
+// Expands every file of the scaffold template in the project dir, the .tmpl suffix is dropped.
+func builder_scaffold_project (theProjectDirPath string, theScaffoldTemplate ScaffoldTemplate, theTemplateData map[string]string) error {
+
+	myScaffoldDirPath := path.Join(kScaffoldsAssetsDirPath, theScaffoldTemplate.Id)
+
+	return fs.WalkDir(gEmbeddedAssets, myScaffoldDirPath, func(theFilePath string, theDirEntry fs.DirEntry, theWalkErr error) error {
+		if theWalkErr != nil {
+			return theWalkErr
+		}
+		if theDirEntry.IsDir() {
+			return nil
+		}
+
+		myRelativeFilePath := strings.TrimSuffix(strings.TrimPrefix(theFilePath, myScaffoldDirPath+"/"), kScaffoldTemplateSuffix)
+		myTargetFilePath := filepath.Join(theProjectDirPath, filepath.FromSlash(myRelativeFilePath))
+
+		myFileTemplate, myParseErr := template.ParseFS(gEmbeddedAssets, theFilePath)
+		if myParseErr != nil {
+			return myParseErr
+		}
+		myMkdirErr := os.MkdirAll(filepath.Dir(myTargetFilePath), 0755)
+		if myMkdirErr != nil {
+			return myMkdirErr
+		}
+		myTargetFile, myCreateErr := os.OpenFile(myTargetFilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if myCreateErr != nil {
+			return myCreateErr
+		}
+		myExecuteErr := myFileTemplate.Execute(myTargetFile, theTemplateData)
+		myCloseErr := myTargetFile.Close()
+		if myExecuteErr != nil {
+			return myExecuteErr
+		}
+		return myCloseErr
+	})
+}
+
+// Creates the project dir, fills its src dir from a git clone or a scaffold template, and writes builder.settings.
+// The project dir is removed again when anything fails.
+func builder_create_project (theProjectsRoot ProjectsRoot, theName string, theTemplateId string, theGitURL string) ([]string, error) {
+
+	var myReturnLines []string
+
+	myProjectDirPath := filepath.Join(theProjectsRoot.DirPath, theName)
+	myImageName := strings.ReplaceAll(builder_get_root_project_id(theProjectsRoot, theName), kProjectsRootSeparator, "-")
+	myMkdirErr := os.Mkdir(myProjectDirPath, 0755)
+	if myMkdirErr != nil {
+		return myReturnLines, myMkdirErr
+	}
+
+	myEngine := "go"
+	var myCreateErr error
+
+	if theGitURL != "" {
+		myCloneContext, myCloneCancel := context.WithTimeout(context.Background(), gServerConfig.GitCloneTimeout)
+		defer myCloneCancel()
+		myCloneCommand := exec.CommandContext(myCloneContext, "git", "clone", "--", theGitURL, filepath.Join(myProjectDirPath, "src"))
+		myCloneCommand.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+kGitAllowedProtocols)
+		myCloneOutputBytes, myCloneErr := myCloneCommand.CombinedOutput()
+		myReturnLines = builder_append_output_lines(myReturnLines, myCloneOutputBytes)
+		if myCloneErr != nil {
+			myCreateErr = fmt.Errorf("git clone failed : %v", myCloneErr)
+		}
+	} else {
+		myScaffoldTemplate, _ := builder_find_scaffold_template(theTemplateId)
+		myEngine = myScaffoldTemplate.Engine
+		myCreateErr = builder_scaffold_project(myProjectDirPath, myScaffoldTemplate, map[string]string{
+			"Name": theName,
+			"ImageName": myImageName,
+		})
+	}
+
+	if myCreateErr == nil {
+		mySettingsText := "Engine="+myEngine+"\nSrcDir=src\nImageName="+myImageName+"\n"
+		myCreateErr = os.WriteFile(filepath.Join(myProjectDirPath, kProjectSettingsFileName), []byte(mySettingsText), 0644)
+	}
+
+	if myCreateErr != nil {
+		os.RemoveAll(myProjectDirPath)
+		return myReturnLines, myCreateErr
+	}
+
+	return myReturnLines, nil
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const kVulnDBDirName = "vulndb"
+const kStdlibModulePath = "stdlib" // module of the Go standard library in the vulnerability database
+
+const kFailureVulnerabilities = "vulnerabilities"
+
+// Severity levels, by rank, "any" fails on every finding, whatever its severity.
+var gScanSeverityLevels = []string{"any", "low", "moderate", "high", "critical"}
+
+const kScanSeverityUnknown = "unknown"
+
+// Level an unknown severity ranks as, against ScanFailOn.
+const kScanSeverityUnknownLevel = "high"
+
+type ScannedModule struct {
+    Path string
+    Version string
+    Replace string // "path version" of the replacement, if any
+}
+
+// Vulnerability database entry, OSV format (https://ossf.github.io/osv-schema/).
+type VulnEntry struct {
+    Id string `json:"id"`
+    Summary string `json:"summary"`
+    Aliases []string `json:"aliases"`
+    Affected []VulnAffected `json:"affected"`
+    Severity []struct {
+        Type string `json:"type"`
+        Score string `json:"score"`
+    } `json:"severity"`
+    DatabaseSpecific struct {
+        Severity string `json:"severity"`
+        URL string `json:"url"`
+    } `json:"database_specific"`
+}
+
+type VulnAffected struct {
+    Package struct {
+        Name string `json:"name"`
+        Ecosystem string `json:"ecosystem"`
+    } `json:"package"`
+    Ranges []struct {
+        Type string `json:"type"`
+        Events []struct {
+            Introduced string `json:"introduced"`
+            Fixed string `json:"fixed"`
+        } `json:"events"`
+    } `json:"ranges"`
+}
+
+type VulnFinding struct {
+    Module ScannedModule
+    Entry VulnEntry
+    Severity string // "low", "moderate", "high", "critical", "unknown"
+    Fixed string // first fixed version after the scanned one, empty when none
+}
+
+//------------------------------------------------------------------------------
+
+// Compares two semver versions, with or without the "v" prefix, pseudo-versions included.
+func builder_compare_semver (theVersionA string, theVersionB string) int {
+
+	mySplitVersion := func(theVersion string) ([]string, string) {
+		myVersion := strings.TrimPrefix(theVersion, "v")
+		myVersion, _, _ = strings.Cut(myVersion, "+")
+		myVersion, myPrerelease, _ := strings.Cut(myVersion, "-")
+		return strings.Split(myVersion, "."), myPrerelease
+	}
+	myCompareIdentifiers := func(theA string, theB string) int {
+		myNumberA, myErrA := strconv.ParseUint(theA, 10, 64)
+		myNumberB, myErrB := strconv.ParseUint(theB, 10, 64)
+		switch {
+		case myErrA == nil && myErrB == nil:
+			if myNumberA != myNumberB {
+				if myNumberA < myNumberB {
+					return -1
+				}
+				return 1
+			}
+			return 0
+		case myErrA == nil:
+			return -1
+		case myErrB == nil:
+			return 1
+		}
+		return strings.Compare(theA, theB)
+	}
+
+	myCoreA, myPrereleaseA := mySplitVersion(theVersionA)
+	myCoreB, myPrereleaseB := mySplitVersion(theVersionB)
+	for myIndex := 0; myIndex < 3; myIndex++ {
+		myPartA, myPartB := "0", "0"
+		if myIndex < len(myCoreA) {
+			myPartA = myCoreA[myIndex]
+		}
+		if myIndex < len(myCoreB) {
+			myPartB = myCoreB[myIndex]
+		}
+		myResult := myCompareIdentifiers(myPartA, myPartB)
+		if myResult != 0 {
+			return myResult
+		}
+	}
+
+	// a release comes after its prereleases
+	if myPrereleaseA == "" || myPrereleaseB == "" {
+		if myPrereleaseA == myPrereleaseB {
+			return 0
+		}
+		if myPrereleaseA == "" {
+			return 1
+		}
+		return -1
+	}
+	myIdentifiersA := strings.Split(myPrereleaseA, ".")
+	myIdentifiersB := strings.Split(myPrereleaseB, ".")
+	for myIndex := 0; myIndex < len(myIdentifiersA) && myIndex < len(myIdentifiersB); myIndex++ {
+		myResult := myCompareIdentifiers(myIdentifiersA[myIndex], myIdentifiersB[myIndex])
+		if myResult != 0 {
+			return myResult
+		}
+	}
+	return len(myIdentifiersA) - len(myIdentifiersB)
+}
+
+// Tells whether a version is in the SEMVER ranges of an affected module, and its first fixed version after it.
+func builder_is_version_affected (theAffected VulnAffected, theVersion string) (bool, string) {
+
+	for _, myRange := range theAffected.Ranges {
+		if myRange.Type != "SEMVER" {
+			continue
+		}
+		// the events are ordered by version
+		myAffected := false
+		myFixed := ""
+		for _, myEvent := range myRange.Events {
+			if myEvent.Introduced != "" && (myEvent.Introduced == "0" || builder_compare_semver(theVersion, myEvent.Introduced) >= 0) {
+				myAffected = true
+			}
+			if myEvent.Fixed != "" {
+				if builder_compare_semver(theVersion, myEvent.Fixed) >= 0 {
+					myAffected = false
+				} else if myAffected && myFixed == "" {
+					myFixed = myEvent.Fixed
+				}
+			}
+		}
+		if myAffected {
+			return true, myFixed
+		}
+	}
+	return false, ""
+}
+
+// Base score of a CVSS v3 vector, ex : "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H" is 9.8.
+func builder_get_cvss3_score (theVector string) (float64, bool) {
+
+	myMetrics := make(map[string]string)
+	for _, myMetric := range strings.Split(theVector, "/") {
+		myName, myValue, myHasValue := strings.Cut(myMetric, ":")
+		if myHasValue {
+			myMetrics[myName] = myValue
+		}
+	}
+	if !strings.HasPrefix(myMetrics["CVSS"], "3") {
+		return 0, false
+	}
+
+	myWeights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C": {"H": 0.56, "L": 0.22, "N": 0},
+		"I": {"H": 0.56, "L": 0.22, "N": 0},
+		"A": {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	myScopeChanged := myMetrics["S"] == "C"
+	myWeights["PR"] = map[string]float64{"N": 0.85, "L": 0.62, "H": 0.27}
+	if myScopeChanged {
+		myWeights["PR"] = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+	}
+	myValues := make(map[string]float64)
+	for myName, myNameWeights := range myWeights {
+		myWeight, myKnown := myNameWeights[myMetrics[myName]]
+		if !myKnown {
+			return 0, false
+		}
+		myValues[myName] = myWeight
+	}
+
+	myImpactSubScore := 1 - (1-myValues["C"])*(1-myValues["I"])*(1-myValues["A"])
+	myImpact := 6.42 * myImpactSubScore
+	if myScopeChanged {
+		myImpact = 7.52*(myImpactSubScore-0.029) - 3.25*math.Pow(myImpactSubScore-0.02, 15)
+	}
+	if myImpact <= 0 {
+		return 0, true
+	}
+	myExploitability := 8.22 * myValues["AV"] * myValues["AC"] * myValues["PR"] * myValues["UI"]
+	myScore := myImpact + myExploitability
+	if myScopeChanged {
+		myScore *= 1.08
+	}
+	myScore = math.Min(myScore, 10)
+
+	// round up to one decimal, as the CVSS v3.1 specification
+	myScaledScore := int64(math.Round(myScore * 100000))
+	if myScaledScore%10000 == 0 {
+		return float64(myScaledScore) / 100000, true
+	}
+	return (math.Floor(float64(myScaledScore)/10000) + 1) / 10, true
+}
+
+// Severity of an entry, from its database severity, else from its CVSS v3 vector.
+func builder_get_vuln_severity (theEntry VulnEntry) string {
+
+	switch strings.ToLower(theEntry.DatabaseSpecific.Severity) {
+	case "low":
+		return "low"
+	case "moderate", "medium":
+		return "moderate"
+	case "high":
+		return "high"
+	case "critical":
+		return "critical"
+	}
+
+	for _, mySeverity := range theEntry.Severity {
+		if mySeverity.Type != "CVSS_V3" {
+			continue
+		}
+		myScore, myValid := builder_get_cvss3_score(mySeverity.Score)
+		if !myValid {
+			continue
+		}
+		switch {
+		case myScore >= 9:
+			return "critical"
+		case myScore >= 7:
+			return "high"
+		case myScore >= 4:
+			return "moderate"
+		}
+		return "low"
+	}
+
+	return kScanSeverityUnknown
+}
+
+// Rank of a severity against ScanFailOn. An unknown severity ranks as high : the entries of vuln.go.dev
+// mostly carry none, ScanFailOn=high lets none of them pass, and ScanFailOn=critical keeps its meaning.
+func builder_get_severity_rank (theSeverity string) int {
+	if theSeverity == kScanSeverityUnknown {
+		theSeverity = kScanSeverityUnknownLevel
+	}
+	for myRank, myLevel := range gScanSeverityLevels {
+		if myLevel == theSeverity {
+			return myRank
+		}
+	}
+	return 0
+}
+
+//------------------------------------------------------------------------------
+
+// Reads a file of the vulnerability database, gzipped or not.
+func builder_read_vulndb_file (theRelativePath string, theValue interface{}) error {
+
+	myFilePath := filepath.Join(gServerConfig.VulnDBDir, filepath.FromSlash(theRelativePath))
+	var myReader io.Reader
+	myFile, myOpenErr := os.Open(myFilePath+".json")
+	if myOpenErr == nil {
+		myReader = myFile
+	} else {
+		myFile, myOpenErr = os.Open(myFilePath+".json.gz")
+		if myOpenErr != nil {
+			return myOpenErr
+		}
+		myGzipReader, myGzipErr := gzip.NewReader(myFile)
+		if myGzipErr != nil {
+			myFile.Close()
+			return myGzipErr
+		}
+		defer myGzipReader.Close()
+		myReader = myGzipReader
+	}
+	defer myFile.Close()
+
+	return json.NewDecoder(myReader).Decode(theValue)
+}
+
+// Returns the vulnerabilities of the database affecting the modules, ordered by severity.
+func builder_find_vulnerabilities (theModules []ScannedModule) ([]VulnFinding, error) {
+
+	var myFindings []VulnFinding
+
+	var myIndexModules []struct {
+		Path string `json:"path"`
+		Vulns []struct {
+			Id string `json:"id"`
+		} `json:"vulns"`
+	}
+	myIndexErr := builder_read_vulndb_file("index/modules", &myIndexModules)
+	if myIndexErr != nil {
+		return myFindings, fmt.Errorf("no vulnerability database in %s : %v", gServerConfig.VulnDBDir, myIndexErr)
+	}
+	myModuleVulnIds := make(map[string][]string)
+	for _, myIndexModule := range myIndexModules {
+		for _, myVuln := range myIndexModule.Vulns {
+			myModuleVulnIds[myIndexModule.Path] = append(myModuleVulnIds[myIndexModule.Path], myVuln.Id)
+		}
+	}
+
+	myEntries := make(map[string]VulnEntry)
+	for _, myModule := range theModules {
+		for _, myVulnId := range myModuleVulnIds[myModule.Path] {
+			myEntry, myLoaded := myEntries[myVulnId]
+			if !myLoaded {
+				myEntryErr := builder_read_vulndb_file("ID/"+myVulnId, &myEntry)
+				if myEntryErr != nil {
+					return myFindings, fmt.Errorf("vulnerability %s : %v", myVulnId, myEntryErr)
+				}
+				myEntries[myVulnId] = myEntry
+			}
+			for _, myAffected := range myEntry.Affected {
+				if myAffected.Package.Name != myModule.Path {
+					continue
+				}
+				myIsAffected, myFixed := builder_is_version_affected(myAffected, myModule.Version)
+				if myIsAffected {
+					myFindings = append(myFindings, VulnFinding{Module: myModule, Entry: myEntry, Severity: builder_get_vuln_severity(myEntry), Fixed: myFixed})
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(myFindings, func(i, j int) bool {
+		return builder_get_severity_rank(myFindings[i].Severity) > builder_get_severity_rank(myFindings[j].Severity)
+	})
+	return myFindings, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Reads the requirements of go.mod, "path version" lines, single or in require blocks.
+func builder_read_go_mod_requirements (theSrcDirPath string) []ScannedModule {
+
+	var myModules []ScannedModule
+
+	myGoModFile, myOpenErr := os.Open(filepath.Join(theSrcDirPath, "go.mod"))
+	if myOpenErr != nil {
+		return myModules
+	}
+	defer myGoModFile.Close()
+
+	myInRequireBlock := false
+	myScanner := bufio.NewScanner(myGoModFile)
+	for myScanner.Scan() {
+		myLine, _, _ := strings.Cut(myScanner.Text(), "//")
+		myFields := strings.Fields(myLine)
+		switch {
+		case len(myFields) == 0:
+			continue
+		case myInRequireBlock && myFields[0] == ")":
+			myInRequireBlock = false
+		case myFields[0] == "require" && len(myFields) == 2 && myFields[1] == "(":
+			myInRequireBlock = true
+		case myFields[0] == "require" && len(myFields) >= 3:
+			myModules = append(myModules, ScannedModule{Path: myFields[1], Version: myFields[2]})
+		case myInRequireBlock && len(myFields) >= 2:
+			myModules = append(myModules, ScannedModule{Path: myFields[0], Version: myFields[1]})
+		}
+	}
+	return myModules
+}
+
+// Reads the "path version" pairs of go.sum.
+func builder_read_go_sum_modules (theSrcDirPath string) map[string]bool {
+
+	myModules := make(map[string]bool)
+
+	myGoSumBytes, myReadErr := os.ReadFile(filepath.Join(theSrcDirPath, "go.sum"))
+	if myReadErr != nil {
+		return myModules
+	}
+	for _, myLine := range strings.Split(string(myGoSumBytes), "\n") {
+		myFields := strings.Fields(myLine)
+		if len(myFields) >= 2 {
+			myModules[myFields[0]+" "+strings.TrimSuffix(myFields[1], "/go.mod")] = true
+		}
+	}
+	return myModules
+}
+
+// Lists the modules of the project from the build info of its program, or from go.mod when the program has none,
+// then flags the known vulnerabilities of the modules and of the standard library.
+// Returns the report lines, and an error when the findings reach the ScanFailOn severity.
+func builder_scan_project (theProjectId string) ([]string, error) {
+
+	var myReturnLines []string
+
+	myProject := builder_get_project(theProjectId)
+	mySrcDirPath := builder_get_project_srcdirpath(theProjectId)
+
+	myReturnLines = append(myReturnLines, "Scan : "+theProjectId)
+
+	myRequirements := builder_read_go_mod_requirements(mySrcDirPath)
+	myGoSumModules := builder_read_go_sum_modules(mySrcDirPath)
+	myReturnLines = append(myReturnLines, fmt.Sprintf("go.mod : %d requirement(s), go.sum : %d module version(s)", len(myRequirements), len(myGoSumModules)))
+	for _, myRequirement := range myRequirements {
+		if !myGoSumModules[myRequirement.Path+" "+myRequirement.Version] {
+			myReturnLines = append(myReturnLines, "Missing in go.sum : "+myRequirement.Path+" "+myRequirement.Version)
+		}
+	}
+
+	var myModules []ScannedModule
+	myBuildInfo, myBuildInfoErr := buildinfo.ReadFile(myProject.TargetFilePath)
+	if myBuildInfoErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("No build info in %s (%v), modules of go.mod", myProject.TargetFilePath, myBuildInfoErr))
+		myModules = myRequirements
+	} else {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Build info : %s, %s", myBuildInfo.Path, myBuildInfo.GoVersion))
+		myModules = append(myModules, ScannedModule{Path: kStdlibModulePath, Version: strings.TrimPrefix(strings.Split(myBuildInfo.GoVersion, " ")[0], "go")})
+		for _, myDep := range myBuildInfo.Deps {
+			myModule := ScannedModule{Path: myDep.Path, Version: myDep.Version}
+			if myDep.Replace != nil {
+				myModule.Replace = myDep.Replace.Path+" "+myDep.Replace.Version
+				// the replacement is the code built, unless it is a local dir
+				if myDep.Replace.Version != "" {
+					myModule = ScannedModule{Path: myDep.Replace.Path, Version: myDep.Replace.Version, Replace: myDep.Path+" "+myDep.Version}
+				}
+			}
+			myModules = append(myModules, myModule)
+		}
+	}
+	for _, myModule := range myModules {
+		myModuleLine := "  "+myModule.Path+" "+myModule.Version
+		if myModule.Replace != "" {
+			myModuleLine += " (replace "+myModule.Replace+")"
+		}
+		myReturnLines = append(myReturnLines, myModuleLine)
+	}
+
+	myFindings, myFindErr := builder_find_vulnerabilities(myModules)
+	if myFindErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Vulnerabilities not checked : %v", myFindErr))
+		if myProject.ScanFailOn != "" {
+			return myReturnLines, fmt.Errorf("ScanFailOn=%s, and the vulnerabilities can't be checked", myProject.ScanFailOn)
+		}
+		return myReturnLines, nil
+	}
+
+	myReturnLines = append(myReturnLines, fmt.Sprintf("Vulnerabilities : %d", len(myFindings)))
+	myFailingCount := 0
+	for _, myFinding := range myFindings {
+		myFixedText := "no fix"
+		if myFinding.Fixed != "" {
+			myFixedText = "fixed in "+myFinding.Fixed
+		}
+		myFindingLine := fmt.Sprintf("  %s [%s] %s %s, %s : %s", myFinding.Entry.Id, myFinding.Severity, myFinding.Module.Path, myFinding.Module.Version, myFixedText, myFinding.Entry.Summary)
+		if len(myFinding.Entry.Aliases) > 0 {
+			myFindingLine += " ("+strings.Join(myFinding.Entry.Aliases, ", ")+")"
+		}
+		myReturnLines = append(myReturnLines, myFindingLine)
+		if myProject.ScanFailOn != "" && builder_get_severity_rank(myFinding.Severity) >= builder_get_severity_rank(myProject.ScanFailOn) {
+			myFailingCount++
+		}
+	}
+	if myFailingCount > 0 {
+		return myReturnLines, fmt.Errorf("%d finding(s) of severity %s or more", myFailingCount, myProject.ScanFailOn)
+	}
+
+	return myReturnLines, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuilderCompareSemver (theTest *testing.T) {
+
+	myTestCases := []struct {
+		VersionA string
+		VersionB string
+		Result int
+	}{
+		{VersionA: "v1.2.3", VersionB: "v1.2.3", Result: 0},
+		{VersionA: "1.2.3", VersionB: "v1.2.3", Result: 0},
+		{VersionA: "v1.2", VersionB: "v1.2.0", Result: 0},
+		{VersionA: "v1.2.3", VersionB: "v1.2.4", Result: -1},
+		{VersionA: "v1.10.0", VersionB: "v1.9.9", Result: 1},
+		{VersionA: "v2.0.0", VersionB: "v1.99.99", Result: 1},
+		{VersionA: "v1.0.0-rc.1", VersionB: "v1.0.0", Result: -1},
+		{VersionA: "v1.0.0", VersionB: "v1.0.0-rc.1", Result: 1},
+		{VersionA: "v1.0.0-alpha", VersionB: "v1.0.0-beta", Result: -1},
+		{VersionA: "v1.0.0-rc.2", VersionB: "v1.0.0-rc.10", Result: -1},
+		{VersionA: "v1.0.0-1", VersionB: "v1.0.0-alpha", Result: -1},
+		{VersionA: "v1.0.0-alpha", VersionB: "v1.0.0-alpha.1", Result: -1},
+		{VersionA: "v1.0.0+incompatible", VersionB: "v1.0.0", Result: 0},
+		// a pseudo-version comes before the release it prepares
+		{VersionA: "v0.0.0-20240101120000-abcdef123456", VersionB: "v0.0.1", Result: -1},
+		{VersionA: "v1.2.4-0.20240101120000-abcdef123456", VersionB: "v1.2.3", Result: 1},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myResult := builder_compare_semver(myTestCase.VersionA, myTestCase.VersionB)
+		if (myResult < 0) != (myTestCase.Result < 0) || (myResult > 0) != (myTestCase.Result > 0) {
+			theTest.Errorf("%s vs %s : %d, expected %d", myTestCase.VersionA, myTestCase.VersionB, myResult, myTestCase.Result)
+		}
+	}
+}
+
+func TestBuilderIsVersionAffected (theTest *testing.T) {
+
+	var myAffected VulnAffected
+	myUnmarshalErr := json.Unmarshal([]byte(`{"package":{"name":"example.com/mod","ecosystem":"Go"},"ranges":[
+		{"type":"SEMVER","events":[{"introduced":"0"},{"fixed":"1.2.5"},{"introduced":"1.3.0"},{"fixed":"1.3.2"}]},
+		{"type":"ECOSYSTEM","events":[{"introduced":"0"}]}
+	]}`), &myAffected)
+	if myUnmarshalErr != nil {
+		theTest.Fatal(myUnmarshalErr)
+	}
+
+	myTestCases := []struct {
+		Version string
+		Affected bool
+		Fixed string
+	}{
+		{Version: "v1.0.0", Affected: true, Fixed: "1.2.5"},
+		{Version: "v1.2.4", Affected: true, Fixed: "1.2.5"},
+		{Version: "v1.2.5", Affected: false},
+		{Version: "v1.2.9", Affected: false},
+		{Version: "v1.3.0", Affected: true, Fixed: "1.3.2"},
+		{Version: "v1.3.2", Affected: false},
+		{Version: "v2.0.0", Affected: false},
+		{Version: "v0.0.0-20200101000000-abcdef123456", Affected: true, Fixed: "1.2.5"},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myIsAffected, myFixed := builder_is_version_affected(myAffected, myTestCase.Version)
+		if myIsAffected != myTestCase.Affected || myFixed != myTestCase.Fixed {
+			theTest.Errorf("%s : affected %v fixed %q, expected %v %q", myTestCase.Version, myIsAffected, myFixed, myTestCase.Affected, myTestCase.Fixed)
+		}
+	}
+
+	var myOpenAffected VulnAffected
+	json.Unmarshal([]byte(`{"ranges":[{"type":"SEMVER","events":[{"introduced":"1.1.0"}]}]}`), &myOpenAffected)
+	for myVersion, myExpected := range map[string]bool{"v1.0.9": false, "v1.1.0": true, "v9.0.0": true} {
+		myIsAffected, myFixed := builder_is_version_affected(myOpenAffected, myVersion)
+		if myIsAffected != myExpected || myFixed != "" {
+			theTest.Errorf("%s without fix : affected %v fixed %q, expected %v", myVersion, myIsAffected, myFixed, myExpected)
+		}
+	}
+}
+
+func TestBuilderGetCVSS3Score (theTest *testing.T) {
+
+	myTestCases := []struct {
+		Vector string
+		Valid bool
+		Score float64
+	}{
+		{Vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", Valid: true, Score: 9.8},
+		{Vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H", Valid: true, Score: 10.0},
+		{Vector: "CVSS:3.0/AV:N/AC:L/PR:N/UI:N/S:U/C:N/I:N/A:H", Valid: true, Score: 7.5},
+		{Vector: "CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:H/I:H/A:H", Valid: true, Score: 7.8},
+		{Vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N", Valid: true, Score: 6.1},
+		{Vector: "CVSS:3.1/AV:N/AC:H/PR:N/UI:N/S:U/C:N/I:N/A:N", Valid: true, Score: 0},
+		{Vector: "CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P", Valid: false},
+		{Vector: "CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H", Valid: false},
+		{Vector: "CVSS:3.1/AV:X/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H", Valid: false},
+		{Vector: "", Valid: false},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myScore, myValid := builder_get_cvss3_score(myTestCase.Vector)
+		if myValid != myTestCase.Valid || (myValid && myScore != myTestCase.Score) {
+			theTest.Errorf("%q : %v %v, expected %v %v", myTestCase.Vector, myScore, myValid, myTestCase.Score, myTestCase.Valid)
+		}
+	}
+}
+
+func TestBuilderGetSeverityRank (theTest *testing.T) {
+
+	// the findings of unknown severity fail up to ScanFailOn=high, not ScanFailOn=critical
+	for myScanFailOn, myFails := range map[string]bool{"any": true, "low": true, "moderate": true, "high": true, "critical": false} {
+		if (builder_get_severity_rank(kScanSeverityUnknown) >= builder_get_severity_rank(myScanFailOn)) != myFails {
+			theTest.Errorf("ScanFailOn=%s : unknown severity fails %v, expected %v", myScanFailOn, !myFails, myFails)
+		}
+	}
+	if builder_get_severity_rank("moderate") >= builder_get_severity_rank("high") || builder_get_severity_rank("low") < builder_get_severity_rank("any") {
+		theTest.Errorf("severity ranks out of order")
+	}
+}
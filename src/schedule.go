@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const kTriggerScheduled = "scheduled"
+const kCronSearchYears = 5 // a schedule without run within this delay never runs, ex : "0 0 30 2 *"
+
+var gCronDescriptors = map[string]string{
+	"@yearly": "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly": "0 0 * * 0",
+	"@daily": "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly": "0 * * * *",
+}
+var gCronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var gCronWeekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Cron schedule, "minute hour day-of-month month day-of-week", in the local time of the builder.
+type CronSchedule struct {
+    Expression string
+    Minutes [60]bool
+    Hours [24]bool
+    Days [32]bool // 1 to 31
+    Months [13]bool // 1 to 12
+    Weekdays [7]bool // 0 (sunday) to 6
+    DaysRestricted bool // day-of-month isn't "*"
+    WeekdaysRestricted bool // day-of-week isn't "*"
+}
+
+//------------------------------------------------------------------------------
+
+// Parses a cron field value, a number or one of the names (numbered from theNamesBase).
+func builder_parse_cron_value (theValue string, theNames []string, theNamesBase int) (int, error) {
+	for myNameIndex, myName := range theNames {
+		if strings.EqualFold(theValue, myName) {
+			return theNamesBase+myNameIndex, nil
+		}
+	}
+	myNumber, myAtoiErr := strconv.Atoi(theValue)
+	if myAtoiErr != nil {
+		return 0, fmt.Errorf("invalid value \"%s\"", theValue)
+	}
+	return myNumber, nil
+}
+
+// Parses a cron field : "*", "5", "1-5", "1,15", "*/10" or "8-18/2", returns the matched values.
+func builder_parse_cron_field (theField string, theMin int, theMax int, theNames []string, theNamesBase int) ([]int, error) {
+
+	var myValues []int
+
+	for _, myItem := range strings.Split(theField, ",") {
+		myRange, myStepText, myHasStep := strings.Cut(myItem, "/")
+		myStep := 1
+		if myHasStep {
+			var myAtoiErr error
+			myStep, myAtoiErr = strconv.Atoi(myStepText)
+			if myAtoiErr != nil || myStep <= 0 {
+				return nil, fmt.Errorf("invalid step \"%s\"", myStepText)
+			}
+		}
+
+		myFirst, myLast := theMin, theMax
+		if myRange != "*" {
+			myFirstText, myLastText, myIsRange := strings.Cut(myRange, "-")
+			var myParseErr error
+			myFirst, myParseErr = builder_parse_cron_value(myFirstText, theNames, theNamesBase)
+			if myParseErr != nil {
+				return nil, myParseErr
+			}
+			myLast = myFirst
+			if myIsRange {
+				myLast, myParseErr = builder_parse_cron_value(myLastText, theNames, theNamesBase)
+				if myParseErr != nil {
+					return nil, myParseErr
+				}
+			} else if myHasStep {
+				myLast = theMax
+			}
+		}
+		if myFirst < theMin || myLast > theMax || myFirst > myLast {
+			return nil, fmt.Errorf("\"%s\" out of %d-%d", myItem, theMin, theMax)
+		}
+
+		for myValue := myFirst; myValue <= myLast; myValue += myStep {
+			myValues = append(myValues, myValue)
+		}
+	}
+
+	return myValues, nil
+}
+
+// Parses a 5 fields cron expression, or one of @hourly, @daily (@midnight), @weekly, @monthly, @yearly (@annually).
+func builder_parse_cron_schedule (theExpression string) (*CronSchedule, error) {
+
+	mySchedule := CronSchedule{Expression: strings.TrimSpace(theExpression)}
+
+	myExpression := mySchedule.Expression
+	myDescriptorExpression, myIsDescriptor := gCronDescriptors[strings.ToLower(myExpression)]
+	if myIsDescriptor {
+		myExpression = myDescriptorExpression
+	}
+	myFields := strings.Fields(myExpression)
+	if len(myFields) != 5 {
+		return nil, fmt.Errorf("\"%s\" : 5 fields expected, minute hour day-of-month month day-of-week", theExpression)
+	}
+
+	myMinutes, myFieldErr := builder_parse_cron_field(myFields[0], 0, 59, nil, 0)
+	if myFieldErr != nil {
+		return nil, fmt.Errorf("\"%s\" : minute %v", theExpression, myFieldErr)
+	}
+	for _, myMinute := range myMinutes {
+		mySchedule.Minutes[myMinute] = true
+	}
+	myHours, myFieldErr := builder_parse_cron_field(myFields[1], 0, 23, nil, 0)
+	if myFieldErr != nil {
+		return nil, fmt.Errorf("\"%s\" : hour %v", theExpression, myFieldErr)
+	}
+	for _, myHour := range myHours {
+		mySchedule.Hours[myHour] = true
+	}
+	myDays, myFieldErr := builder_parse_cron_field(myFields[2], 1, 31, nil, 0)
+	if myFieldErr != nil {
+		return nil, fmt.Errorf("\"%s\" : day-of-month %v", theExpression, myFieldErr)
+	}
+	for _, myDay := range myDays {
+		mySchedule.Days[myDay] = true
+	}
+	myMonths, myFieldErr := builder_parse_cron_field(myFields[3], 1, 12, gCronMonthNames, 1)
+	if myFieldErr != nil {
+		return nil, fmt.Errorf("\"%s\" : month %v", theExpression, myFieldErr)
+	}
+	for _, myMonth := range myMonths {
+		mySchedule.Months[myMonth] = true
+	}
+	// 7 is also sunday
+	myWeekdays, myFieldErr := builder_parse_cron_field(myFields[4], 0, 7, gCronWeekdayNames, 0)
+	if myFieldErr != nil {
+		return nil, fmt.Errorf("\"%s\" : day-of-week %v", theExpression, myFieldErr)
+	}
+	for _, myWeekday := range myWeekdays {
+		mySchedule.Weekdays[myWeekday%7] = true
+	}
+	mySchedule.DaysRestricted = myFields[2] != "*"
+	mySchedule.WeekdaysRestricted = myFields[4] != "*"
+
+	if builder_get_cron_next_time(&mySchedule, time.Now()).IsZero() {
+		return nil, fmt.Errorf("\"%s\" : never runs", theExpression)
+	}
+
+	return &mySchedule, nil
+}
+
+// Parses the ";" separated cron expressions of a setting, the invalid ones are skipped.
+func builder_load_cron_schedules (theSettingValue string) []*CronSchedule {
+	var mySchedules []*CronSchedule
+	for _, myExpression := range builder_split_setting_list(theSettingValue) {
+		mySchedule, myParseErr := builder_parse_cron_schedule(myExpression)
+		if myParseErr == nil {
+			mySchedules = append(mySchedules, mySchedule)
+		}
+	}
+	return mySchedules
+}
+
+// Returns one message per invalid schedule setting.
+func builder_validate_schedule_settings (theValues map[string]string) []string {
+
+	var myErrors []string
+
+	for _, mySettingKey := range []string{"Schedule", "RefreshSchedule"} {
+		for _, myExpression := range builder_split_setting_list(theValues[mySettingKey]) {
+			_, myParseErr := builder_parse_cron_schedule(myExpression)
+			if myParseErr != nil {
+				myErrors = append(myErrors, mySettingKey+" : "+myParseErr.Error())
+			}
+		}
+	}
+	if theValues["RefreshSchedule"] != "" && theValues["Engine"] == "custom" {
+		myErrors = append(myErrors, "RefreshSchedule : the refresh builds update go.mod, not available with the custom engine")
+	}
+	if theValues["RefreshSchedule"] != "" && theValues["Engine"] == kEngineDocker {
+		myErrors = append(myErrors, "RefreshSchedule : the refresh builds update go.mod, not available with the docker engine, which keeps the sources read-only")
+	}
+
+	return myErrors
+}
+
+// As cron, when both the day of month and the day of week are restricted, either one matches.
+func builder_is_cron_day (theSchedule *CronSchedule, theTime time.Time) bool {
+	myDayOK := theSchedule.Days[theTime.Day()]
+	myWeekdayOK := theSchedule.Weekdays[theTime.Weekday()]
+	if theSchedule.DaysRestricted && theSchedule.WeekdaysRestricted {
+		return myDayOK || myWeekdayOK
+	}
+	return myDayOK && myWeekdayOK
+}
+
+// Returns the first minute strictly after theTime matching the schedule, zero when none within kCronSearchYears.
+func builder_get_cron_next_time (theSchedule *CronSchedule, theTime time.Time) time.Time {
+
+	myTime := theTime.Truncate(time.Minute).Add(time.Minute)
+	myLimitTime := theTime.AddDate(kCronSearchYears, 0, 0)
+
+	for myTime.Before(myLimitTime) {
+		if !theSchedule.Months[myTime.Month()] {
+			myTime = time.Date(myTime.Year(), myTime.Month()+1, 1, 0, 0, 0, 0, myTime.Location())
+			continue
+		}
+		if !builder_is_cron_day(theSchedule, myTime) {
+			myTime = time.Date(myTime.Year(), myTime.Month(), myTime.Day()+1, 0, 0, 0, 0, myTime.Location())
+			continue
+		}
+		if !theSchedule.Hours[myTime.Hour()] {
+			// not Truncate, which rounds in absolute time : off by 30 minutes in the half-hour offset zones
+			myTime = time.Date(myTime.Year(), myTime.Month(), myTime.Day(), myTime.Hour()+1, 0, 0, 0, myTime.Location())
+			continue
+		}
+		if !theSchedule.Minutes[myTime.Minute()] {
+			myTime = myTime.Add(time.Minute)
+			continue
+		}
+		return myTime
+	}
+
+	return time.Time{}
+}
+
+//------------------------------------------------------------------------------
+
+// Returns the next scheduled job of a project, "build" or "refresh", and its time, zero without schedule.
+// A refresh build also builds : when both are due at the same time, the refresh runs.
+func builder_get_project_next_run (theProject *Project, theTime time.Time) (string, time.Time) {
+
+	myNextJob := ""
+	var myNextTime time.Time
+	for _, myJob := range []string{"refresh", "build"} {
+		mySchedules := theProject.Schedule
+		if myJob == "refresh" {
+			mySchedules = theProject.RefreshSchedule
+		}
+		for _, mySchedule := range mySchedules {
+			myTime := builder_get_cron_next_time(mySchedule, theTime)
+			if !myTime.IsZero() && (myNextTime.IsZero() || myTime.Before(myNextTime)) {
+				myNextJob = myJob
+				myNextTime = myTime
+			}
+		}
+	}
+	return myNextJob, myNextTime
+}
+
+// "2026-10-20 03:00 (refresh)", empty without schedule.
+func builder_get_project_next_run_text (theProject *Project) string {
+	myNextJob, myNextTime := builder_get_project_next_run(theProject, time.Now())
+	if myNextTime.IsZero() {
+		return ""
+	}
+	myNextRunText := myNextTime.Format("2006-01-02 15:04")
+	if myNextJob == "refresh" {
+		myNextRunText += " (refresh)"
+	}
+	return myNextRunText
+}
+
+// Queues every minute the builds due, with the "scheduled" trigger.
+// A project still busy at that time skips the run, the runs missed while the builder was stopped are not caught up.
+func builder_run_cron_scheduler () {
+	for {
+		myNow := time.Now()
+		time.Sleep(myNow.Truncate(time.Minute).Add(time.Minute).Sub(myNow))
+		myMinute := time.Now().Truncate(time.Minute)
+
+		myDueJobs := make(map[string]string)
+		gProjectsMutex.Lock()
+		for _, myProjectId := range gOrderedProjectIds {
+			// the next run after the minute before is this minute when it is due
+			myJob, myTime := builder_get_project_next_run(gProjects[myProjectId], myMinute.Add(-time.Minute))
+			if myTime.Equal(myMinute) {
+				myDueJobs[myProjectId] = myJob
+			}
+		}
+		gProjectsMutex.Unlock()
+
+		for myProjectId, myJob := range myDueJobs {
+			if !builder_queue_project_job(myProjectId, myJob, 0, kTriggerScheduled) {
+				gLogger.Warn("scheduled job skipped, project busy", "project", myProjectId, "job", myJob)
+			}
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// go.mod and go.sum of a project before its refresh, nil for a missing file.
+type GoModBackup struct {
+    SrcDirPath string
+    GoModBytes []byte
+    GoSumBytes []byte
+}
+
+func builder_backup_go_mod (theSrcDirPath string) GoModBackup {
+	myGoModBackup := GoModBackup{SrcDirPath: theSrcDirPath}
+	myGoModBackup.GoModBytes, _ = os.ReadFile(filepath.Join(theSrcDirPath, "go.mod"))
+	myGoModBackup.GoSumBytes, _ = os.ReadFile(filepath.Join(theSrcDirPath, "go.sum"))
+	return myGoModBackup
+}
+
+// Writes back the go.mod and go.sum of the backup when they changed, removing the ones the refresh created.
+func builder_restore_go_mod (theGoModBackup GoModBackup) error {
+	for myFileName, myFileBytes := range map[string][]byte{"go.mod": theGoModBackup.GoModBytes, "go.sum": theGoModBackup.GoSumBytes} {
+		myFilePath := filepath.Join(theGoModBackup.SrcDirPath, myFileName)
+		myCurrentBytes, myReadErr := os.ReadFile(myFilePath)
+		if (myReadErr == nil) == (myFileBytes != nil) && bytes.Equal(myCurrentBytes, myFileBytes) {
+			continue
+		}
+		if myFileBytes == nil {
+			myRemoveErr := os.Remove(myFilePath)
+			if myRemoveErr != nil && !os.IsNotExist(myRemoveErr) {
+				return myRemoveErr
+			}
+			continue
+		}
+		myWriteErr := os.WriteFile(myFilePath, myFileBytes, 0644)
+		if myWriteErr != nil {
+			return myWriteErr
+		}
+	}
+	return nil
+}
+
+// Updates the dependencies of a refresh build to their latest minor or patch versions (go get -u ./...,
+// then go mod tidy), from the upstream proxy only : the module caches of the builder only list the versions
+// already downloaded. The go.mod changes are listed in the build output, and stay in the project sources
+// once the build succeeds, see builder_build_project.
+func builder_refresh_project_deps (theProjectId string, theSrcDirPath string) ([]string, error) {
+
+	var myReturnLines []string
+
+	myGoModFilePath := filepath.Join(theSrcDirPath, "go.mod")
+	myOldGoModBytes, myReadErr := os.ReadFile(myGoModFilePath)
+	if myReadErr != nil {
+		return myReturnLines, fmt.Errorf("no go.mod to refresh : %v", myReadErr)
+	}
+	if gServerConfig.GoProxy == kGoProxyOff {
+		return myReturnLines, fmt.Errorf("no upstream proxy to refresh from, GoProxy=%s", kGoProxyOff)
+	}
+	if builder_get_project(theProjectId).Engine == kEngineDocker {
+		return myReturnLines, fmt.Errorf("the docker engine keeps the sources read-only")
+	}
+
+	for _, myGoArgs := range [][]string{{"get", "-u", "./..."}, {"mod", "tidy"}} {
+		myReturnLines = append(myReturnLines, "Refresh : go "+strings.Join(myGoArgs, " "))
+		myGoCommand := builder_new_job_command(builder_get_project_go_command(theProjectId), myGoArgs...)
+		myGoCommand.Dir = theSrcDirPath
+		myGoCommand.Env = append(append(os.Environ(), builder_get_go_env(theProjectId)...), "GOPROXY="+gServerConfig.GoProxy)
+		myGoOutputBytes, myGoErr := myGoCommand.CombinedOutput()
+		if len(bytes.TrimSpace(myGoOutputBytes)) > 0 {
+			myReturnLines = builder_append_output_lines(myReturnLines, bytes.TrimSpace(myGoOutputBytes))
+		}
+		if myGoErr != nil {
+			return myReturnLines, fmt.Errorf("go %s : %v", strings.Join(myGoArgs, " "), myGoErr)
+		}
+	}
+
+	myNewGoModBytes, _ := os.ReadFile(myGoModFilePath)
+	myOldLines := make(map[string]bool)
+	for _, myLine := range strings.Split(string(myOldGoModBytes), "\n") {
+		myOldLines[strings.TrimSpace(myLine)] = true
+	}
+	myNewLines := make(map[string]bool)
+	for _, myLine := range strings.Split(string(myNewGoModBytes), "\n") {
+		myNewLines[strings.TrimSpace(myLine)] = true
+	}
+	myChangesCount := 0
+	for _, myLine := range strings.Split(string(myOldGoModBytes), "\n") {
+		if strings.TrimSpace(myLine) != "" && !myNewLines[strings.TrimSpace(myLine)] {
+			myReturnLines = append(myReturnLines, "go.mod - "+strings.TrimSpace(myLine))
+			myChangesCount++
+		}
+	}
+	for _, myLine := range strings.Split(string(myNewGoModBytes), "\n") {
+		if strings.TrimSpace(myLine) != "" && !myOldLines[strings.TrimSpace(myLine)] {
+			myReturnLines = append(myReturnLines, "go.mod + "+strings.TrimSpace(myLine))
+			myChangesCount++
+		}
+	}
+	if myChangesCount == 0 {
+		myReturnLines = append(myReturnLines, "Refresh : dependencies already up to date")
+	}
+
+	return myReturnLines, nil
+}
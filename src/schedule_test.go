@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuilderParseCronSchedule (theTest *testing.T) {
+
+	myTestCases := []struct {
+		Expression string
+		Valid bool
+		Minutes []int
+		Hours []int
+		Weekdays []int
+		DaysRestricted bool
+		WeekdaysRestricted bool
+	}{
+		{Expression: "0 3 * * *", Valid: true, Minutes: []int{0}, Hours: []int{3}},
+		{Expression: "*/15 8-18/2 * * *", Valid: true, Minutes: []int{0, 15, 30, 45}, Hours: []int{8, 10, 12, 14, 16, 18}},
+		{Expression: "5,35 * * * mon-fri", Valid: true, Minutes: []int{5, 35}, Weekdays: []int{1, 2, 3, 4, 5}, WeekdaysRestricted: true},
+		{Expression: "0 0 * * 7", Valid: true, Minutes: []int{0}, Hours: []int{0}, Weekdays: []int{0}, WeekdaysRestricted: true},
+		{Expression: "0 4 1,15 * SUN", Valid: true, Minutes: []int{0}, Hours: []int{4}, Weekdays: []int{0}, DaysRestricted: true, WeekdaysRestricted: true},
+		{Expression: "@daily", Valid: true, Minutes: []int{0}, Hours: []int{0}},
+		{Expression: " @Weekly ", Valid: true, Minutes: []int{0}, Hours: []int{0}, Weekdays: []int{0}, WeekdaysRestricted: true},
+		{Expression: "", Valid: false},
+		{Expression: "0 3 * *", Valid: false},
+		{Expression: "0 3 * * * *", Valid: false},
+		{Expression: "60 * * * *", Valid: false},
+		{Expression: "* 24 * * *", Valid: false},
+		{Expression: "* * 0 * *", Valid: false},
+		{Expression: "* * * 13 *", Valid: false},
+		{Expression: "* * * * 8", Valid: false},
+		{Expression: "5-1 * * * *", Valid: false},
+		{Expression: "*/0 * * * *", Valid: false},
+		{Expression: "* * * foo *", Valid: false},
+		{Expression: "@often", Valid: false},
+		{Expression: "0 0 30 2 *", Valid: false},
+	}
+
+	for _, myTestCase := range myTestCases {
+		mySchedule, myParseErr := builder_parse_cron_schedule(myTestCase.Expression)
+		if !myTestCase.Valid {
+			if myParseErr == nil {
+				theTest.Errorf("%q : error expected", myTestCase.Expression)
+			}
+			continue
+		}
+		if myParseErr != nil {
+			theTest.Errorf("%q : unexpected error %v", myTestCase.Expression, myParseErr)
+			continue
+		}
+		for _, myMinute := range myTestCase.Minutes {
+			if !mySchedule.Minutes[myMinute] {
+				theTest.Errorf("%q : minute %d expected", myTestCase.Expression, myMinute)
+			}
+		}
+		for _, myHour := range myTestCase.Hours {
+			if !mySchedule.Hours[myHour] {
+				theTest.Errorf("%q : hour %d expected", myTestCase.Expression, myHour)
+			}
+		}
+		for _, myWeekday := range myTestCase.Weekdays {
+			if !mySchedule.Weekdays[myWeekday] {
+				theTest.Errorf("%q : weekday %d expected", myTestCase.Expression, myWeekday)
+			}
+		}
+		if mySchedule.DaysRestricted != myTestCase.DaysRestricted || mySchedule.WeekdaysRestricted != myTestCase.WeekdaysRestricted {
+			theTest.Errorf("%q : restricted %v %v, expected %v %v", myTestCase.Expression, mySchedule.DaysRestricted, mySchedule.WeekdaysRestricted, myTestCase.DaysRestricted, myTestCase.WeekdaysRestricted)
+		}
+	}
+}
+
+func TestBuilderGetCronNextTime (theTest *testing.T) {
+
+	// 2024-03-15 is a friday
+	myTime := time.Date(2024, 3, 15, 10, 30, 20, 0, time.UTC)
+
+	myTestCases := []struct {
+		Expression string
+		Next time.Time
+	}{
+		{Expression: "* * * * *", Next: time.Date(2024, 3, 15, 10, 31, 0, 0, time.UTC)},
+		{Expression: "30 10 * * *", Next: time.Date(2024, 3, 16, 10, 30, 0, 0, time.UTC)},
+		{Expression: "0 3 * * *", Next: time.Date(2024, 3, 16, 3, 0, 0, 0, time.UTC)},
+		{Expression: "*/20 * * * *", Next: time.Date(2024, 3, 15, 10, 40, 0, 0, time.UTC)},
+		{Expression: "@hourly", Next: time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{Expression: "0 4 * * mon", Next: time.Date(2024, 3, 18, 4, 0, 0, 0, time.UTC)},
+		{Expression: "@monthly", Next: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{Expression: "@yearly", Next: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 29 2 *", Next: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{Expression: "0 0 31 * *", Next: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)},
+		// day-of-month or day-of-week when both are restricted : the 20th, or a sunday first
+		{Expression: "0 0 20 * sun", Next: time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		// day-of-month and month when day-of-week is "*"
+		{Expression: "0 12 1 jun *", Next: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+	}
+
+	for _, myTestCase := range myTestCases {
+		mySchedule, myParseErr := builder_parse_cron_schedule(myTestCase.Expression)
+		if myParseErr != nil {
+			theTest.Errorf("%q : unexpected error %v", myTestCase.Expression, myParseErr)
+			continue
+		}
+		myNext := builder_get_cron_next_time(mySchedule, myTime)
+		if !myNext.Equal(myTestCase.Next) {
+			theTest.Errorf("%q : next %v, expected %v", myTestCase.Expression, myNext, myTestCase.Next)
+		}
+	}
+}
+
+func TestBuilderGetCronNextTimeHalfHourZone (theTest *testing.T) {
+
+	// India and Nepal offsets, the hours start at :30 and :15 in UTC
+	for _, myLocation := range []*time.Location{time.FixedZone("IST", 5*3600+1800), time.FixedZone("NPT", 5*3600+2700)} {
+
+		myLocalLocation := time.Local
+		time.Local = myLocation
+		_, myParseErr := builder_parse_cron_schedule("0 3 * * *")
+		time.Local = myLocalLocation
+		if myParseErr != nil {
+			theTest.Errorf("%s : unexpected error %v", myLocation, myParseErr)
+		}
+
+		myTime := time.Date(2024, 3, 15, 10, 30, 20, 0, myLocation)
+		for myExpression, myNext := range map[string]time.Time{
+			"0 3 * * *": time.Date(2024, 3, 16, 3, 0, 0, 0, myLocation),
+			"@hourly": time.Date(2024, 3, 15, 11, 0, 0, 0, myLocation),
+			"15 12 * * *": time.Date(2024, 3, 15, 12, 15, 0, 0, myLocation),
+		} {
+			mySchedule, _ := builder_parse_cron_schedule(myExpression)
+			myNextTime := builder_get_cron_next_time(mySchedule, myTime)
+			if !myNextTime.Equal(myNext) {
+				theTest.Errorf("%s %q : next %v, expected %v", myLocation, myExpression, myNextTime, myNext)
+			}
+		}
+	}
+}
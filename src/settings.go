@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type EditableSetting struct {
+    Key string
+    Description string
+    Value string // current value in builder.settings, empty when defaulted
+}
+
+// Settings editable from the project settings page, in display order.
+var gEditableSettingKeys = []string{"Engine", "SrcDir", "ImageName", "BuildCommand", "BuilderImage", "ImageTags", "PushRegistry", "KeepImages", "GoCache", "GoVersion", "GoArch", "CgoCC", "CgoCXX", "CgoCFlags", "CgoLDFlags", "CgoPkgConfigPath", "CgoLink", "CgoLibc", "CgoCrossCC", "Scan", "ScanFailOn", "LimitCPU", "LimitMemory", "LimitTime", "LimitOutput", "NotifyWebhook", "NotifyChat", "NotifyEmail", "NotifyOn", "Schedule", "RefreshSchedule"}
+// Settings holding credentials (the tokens of the webhook and chat URLs), masked in the audit entries.
+var gSecretSettingKeys = []string{"NotifyWebhook", "NotifyChat"}
+
+var gEditableSettingDescriptions = map[string]string{
+	"Engine": "go (default), cgo, docker to build in a container of BuilderImage, or custom to run BuildCommand",
+	"SrcDir": "Go sources dir, relative to the project dir (default : src)",
+	"ImageName": "Docker image name (default : project id)",
+	"BuildCommand": "Build command of the custom engine, or of the docker engine (writing the program in /out)",
+	"BuilderImage": "Image of the build containers of the docker engine (default : "+kDefaultBuilderImage+")",
+	"ImageTags": "Image tag templates, ex : latest;{build};{commit}",
+	"PushRegistry": "Registry the image tags are pushed to, ex : localhost:5000",
+	"KeepImages": "Number of image versions kept for rollbacks (default : 5)",
+	"GoVersion": "Go toolchain, ex : 1.22.3, installed from the toolchain archives (default : toolchain of go.mod, else the Go of the builder)",
+	"GoCache": "shared (default) or project : Go build and module caches shared by all projects, or of this project only",
+	"GoArch": "GOARCH of the go and cgo engines, ex : arm64 (default : arch of the builder)",
+	"CgoCC": "C compiler of the cgo engine (default : CgoCrossCC of GoArch, musl-gcc with musl, else go env CC)",
+	"CgoCXX": "C++ compiler of the cgo engine (default : go env CXX)",
+	"CgoCFlags": "CGO_CFLAGS of the cgo engine",
+	"CgoLDFlags": "CGO_LDFLAGS of the cgo engine",
+	"CgoPkgConfigPath": "PKG_CONFIG_PATH of the cgo engine, ex : /opt/sqlite/lib/pkgconfig",
+	"CgoLink": "static (default) or dynamic link of the cgo engine",
+	"CgoLibc": "glibc (default) or musl, compiled with musl-gcc unless CgoCC is set",
+	"CgoCrossCC": "C compiler of every GoArch, ex : arm64=aarch64-linux-gnu-gcc;arm=arm-linux-gnueabihf-gcc",
+	"Scan": "true to scan the modules of the program for known vulnerabilities after the build (default : false)",
+	"ScanFailOn": "Severity failing the build : any, low, moderate, high or critical, the findings of unknown severity (most vuln.go.dev entries) count as high (default : none, the scan only reports)",
+	"LimitCPU": "CPU cores of the build command, ex : 2 or 0.5 (default : no limit)",
+	"LimitMemory": "Memory of the build command, ex : 2g (default : no limit)",
+	"LimitTime": "Wall time of the build command, ex : 10m (default : no limit)",
+	"LimitOutput": "Output size of the build command, ex : 1m (default : no limit)",
+	"NotifyWebhook": "Webhook URLs receiving the job events as a JSON POST, ex : https://ci.example.com/hook",
+	"NotifyChat": "Slack or Mattermost incoming webhook URLs",
+	"NotifyEmail": "Email addresses, ex : dev@example.com;ops@example.com",
+	"NotifyOn": "Notified events : success, failure, recovery, or job:event for build, up, down and deploy, ex : build:failure;recovery (default : failure;recovery)",
+	"Schedule": "Cron schedules of periodic builds, pulling the base images again, ex : 0 3 * * * or @daily (local time of the builder)",
+	"RefreshSchedule": "Cron schedules of dependency refresh builds, updating go.mod with go get -u, ex : 0 4 * * mon or @weekly",
+}
+
+var gKnownEngines = []string{"go", "cgo", kEngineDocker, "custom"}
+
+var gImageNameRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+var gRegistryRegexp = regexp.MustCompile(`^[A-Za-z0-9.-]+(:[0-9]+)?(/[a-z0-9._-]+)*/?$`)
+
+//------------------------------------------------------------------------------
+
+func builder_get_project_settings_filepath (theProjectId string) string {
+	return filepath.Join(builder_get_project_dirpath(theProjectId), kProjectSettingsFileName)
+}
+
+func builder_get_editable_settings (theProjectId string, theValues map[string]string) []EditableSetting {
+
+	if theValues == nil {
+		theValues = builder_load_project_settings(builder_get_project_dirpath(theProjectId))
+	}
+
+	var myEditableSettings []EditableSetting
+	for _, mySettingKey := range gEditableSettingKeys {
+		myEditableSettings = append(myEditableSettings, EditableSetting{Key: mySettingKey,
+			Description: gEditableSettingDescriptions[mySettingKey],
+			Value: strings.TrimSpace(theValues[mySettingKey]),
+		})
+	}
+	return myEditableSettings
+}
+
+// Returns one message per invalid value, empty values are always valid (default).
+// Without project id, checks the server defaults of the settings : the src dir only against leaving
+// the project dirs, and the custom engine without BuildCommand, that every project sets.
+func builder_validate_project_settings (theProjectId string, theValues map[string]string) []string {
+
+	var myErrors []string
+
+	for mySettingKey, mySettingValue := range theValues {
+		if strings.ContainsAny(mySettingValue, "\r\n") {
+			myErrors = append(myErrors, mySettingKey+" : line breaks are not allowed")
+		}
+	}
+
+	myEngine := theValues["Engine"]
+	if myEngine != "" {
+		myEngineKnown := false
+		for _, myKnownEngine := range gKnownEngines {
+			if myEngine == myKnownEngine {
+				myEngineKnown = true
+			}
+		}
+		if !myEngineKnown {
+			myErrors = append(myErrors, "Engine : unknown engine \""+myEngine+"\", expected one of "+strings.Join(gKnownEngines, ", "))
+		}
+		if myEngine == "custom" && theValues["BuildCommand"] == "" && theProjectId != "" {
+			myErrors = append(myErrors, "BuildCommand : required by the custom engine")
+		}
+	}
+
+	mySrcDir := theValues["SrcDir"]
+	if mySrcDir != "" && theProjectId == "" {
+		if !filepath.IsLocal(mySrcDir) {
+			myErrors = append(myErrors, "SrcDir : must be inside the project dir")
+		}
+	} else if mySrcDir != "" {
+		myProjectDirPath := builder_get_project_dirpath(theProjectId)
+		mySrcDirPath := filepath.Join(myProjectDirPath, mySrcDir)
+		myRelPath, myRelErr := filepath.Rel(myProjectDirPath, mySrcDirPath)
+		mySrcDirInfo, myStatErr := os.Stat(mySrcDirPath)
+		if filepath.IsAbs(mySrcDir) || myRelErr != nil || strings.HasPrefix(myRelPath, "..") {
+			myErrors = append(myErrors, "SrcDir : must be inside the project dir")
+		} else if myStatErr != nil || !mySrcDirInfo.IsDir() {
+			myErrors = append(myErrors, "SrcDir : directory \""+mySrcDir+"\" does not exist")
+		}
+	}
+
+	myImageName := theValues["ImageName"]
+	if myImageName != "" && (len(myImageName) > 255 || !gImageNameRegexp.MatchString(myImageName)) {
+		myErrors = append(myErrors, "ImageName : invalid image name \""+myImageName+"\"")
+	}
+
+	for _, myImageTag := range builder_split_setting_list(theValues["ImageTags"]) {
+		myExpandedTag := strings.NewReplacer("{commit}", "0", "{tag}", "0", "{build}", "0", "{date}", "0").Replace(myImageTag)
+		if !gImageTagRegexp.MatchString(myExpandedTag) {
+			myErrors = append(myErrors, "ImageTags : invalid tag template \""+myImageTag+"\"")
+		}
+	}
+
+	myBuilderImage := theValues["BuilderImage"]
+	if myBuilderImage != "" && (len(myBuilderImage) > 255 || !gBuilderImageRegexp.MatchString(myBuilderImage)) {
+		myErrors = append(myErrors, "BuilderImage : invalid image reference \""+myBuilderImage+"\"")
+	}
+
+	myPushRegistry := theValues["PushRegistry"]
+	if myPushRegistry != "" && !gRegistryRegexp.MatchString(myPushRegistry) {
+		myErrors = append(myErrors, "PushRegistry : invalid registry \""+myPushRegistry+"\"")
+	}
+
+	myGoVersion := theValues["GoVersion"]
+	if myGoVersion != "" && builder_normalize_go_version(myGoVersion) == "" {
+		myErrors = append(myErrors, "GoVersion : invalid Go version \""+myGoVersion+"\", ex : 1.22.3")
+	}
+
+	myGoArch := theValues["GoArch"]
+	if myGoArch != "" {
+		_, myKnownArch := gGoArchMachines[myGoArch]
+		if !myKnownArch {
+			myErrors = append(myErrors, "GoArch : unknown arch \""+myGoArch+"\"")
+		}
+	}
+
+	myScanFailOn := theValues["ScanFailOn"]
+	if myScanFailOn != "" && !builder_contains_string(gScanSeverityLevels, strings.ToLower(myScanFailOn)) {
+		myErrors = append(myErrors, "ScanFailOn : expected one of "+strings.Join(gScanSeverityLevels, ", "))
+	}
+
+	myGoCache := theValues["GoCache"]
+	if myGoCache != "" && myGoCache != kGoCacheModeShared && myGoCache != kGoCacheModeProject {
+		myErrors = append(myErrors, "GoCache : expected "+kGoCacheModeShared+" or "+kGoCacheModeProject)
+	}
+
+	myErrors = append(myErrors, builder_validate_cgo_settings(theValues)...)
+	myErrors = append(myErrors, builder_validate_build_limits(theValues)...)
+	myErrors = append(myErrors, builder_validate_notify_settings(theValues)...)
+	myErrors = append(myErrors, builder_validate_schedule_settings(theValues)...)
+
+	myKeepImages := theValues["KeepImages"]
+	if myKeepImages != "" {
+		myKeepImagesCount, myAtoiErr := strconv.Atoi(myKeepImages)
+		if myAtoiErr != nil || myKeepImagesCount <= 0 {
+			myErrors = append(myErrors, "KeepImages : must be a positive number")
+		}
+	}
+
+	return myErrors
+}
+
+// Describes a setting change for the audit, the values of the secret settings masked.
+func builder_get_setting_change (theSettingKey string, theOldValue string, theNewValue string) string {
+	if builder_contains_string(gSecretSettingKeys, theSettingKey) {
+		if theOldValue != "" {
+			theOldValue = "********"
+		}
+		if theNewValue != "" {
+			theNewValue = "********"
+		}
+	}
+	return fmt.Sprintf("%s : %q -> %q", theSettingKey, theOldValue, theNewValue)
+}
+
+// Updates the given keys in builder.settings, keeping the other lines (unknown keys, comments) untouched.
+// An empty value removes the key, so that its default applies.
+// The file is replaced atomically.
+func builder_save_project_settings (theProjectId string, theValues map[string]string) ([]string, error) {
+
+	var myChanges []string
+
+	// auto-discovered projects get their builder.settings on their first edit
+	mySettingsFilePath := builder_get_project_settings_filepath(theProjectId)
+	mySettingsFileMode := os.FileMode(0644)
+	mySettingsFileInfo, myStatErr := os.Stat(mySettingsFilePath)
+	if myStatErr == nil {
+		mySettingsFileMode = mySettingsFileInfo.Mode().Perm()
+	} else if !os.IsNotExist(myStatErr) {
+		return myChanges, myStatErr
+	}
+	mySettingsFileText, myReadFileError := os.ReadFile(mySettingsFilePath)
+	if myReadFileError != nil && !os.IsNotExist(myReadFileError) {
+		return myChanges, myReadFileError
+	}
+
+	myWrittenKeys := make(map[string]bool)
+	var myNewLines []string
+
+	mySettingsFileText = bytes.TrimSuffix(mySettingsFileText, []byte("\n"))
+	for _, mySettingsFileLineBytes := range bytes.Split(mySettingsFileText, []byte("\n")) {
+		if len(mySettingsFileText) == 0 {
+			break
+		}
+		mySettingsFileLine := string(mySettingsFileLineBytes)
+		myTrimmedLine := strings.TrimSpace(mySettingsFileLine)
+		myEqualPos := strings.Index(myTrimmedLine, "=")
+		if myEqualPos > 0 {
+			mySettingKey := myTrimmedLine[0:myEqualPos]
+			myNewValue, myKeyUpdated := theValues[mySettingKey]
+			if myKeyUpdated {
+				if myWrittenKeys[mySettingKey] {
+					continue
+				}
+				myWrittenKeys[mySettingKey] = true
+				myOldValue := strings.TrimSpace(myTrimmedLine[myEqualPos+1:])
+				if myOldValue != myNewValue {
+					myChanges = append(myChanges, builder_get_setting_change(mySettingKey, myOldValue, myNewValue))
+				}
+				if myNewValue == "" {
+					continue
+				}
+				mySettingsFileLine = mySettingKey+"="+myNewValue
+			}
+		}
+		myNewLines = append(myNewLines, mySettingsFileLine)
+	}
+
+	for _, mySettingKey := range gEditableSettingKeys {
+		myNewValue, myKeyUpdated := theValues[mySettingKey]
+		if myKeyUpdated && !myWrittenKeys[mySettingKey] && myNewValue != "" {
+			myChanges = append(myChanges, builder_get_setting_change(mySettingKey, "", myNewValue))
+			myNewLines = append(myNewLines, mySettingKey+"="+myNewValue)
+		}
+	}
+
+	if len(myChanges) == 0 {
+		return myChanges, nil
+	}
+
+	// the first edit of an auto-discovered project keeps it discovered, else its monorepo targets would
+	// collapse into one project, and its src dir would default to "src"
+	if os.IsNotExist(myStatErr) {
+		myNewLines = append(myNewLines, "Targets=auto")
+		myChanges = append(myChanges, fmt.Sprintf("%s : %q -> %q", "Targets", "", "auto"))
+		if theValues["SrcDir"] == "" {
+			mySrcDir := builder_get_discovered_srcdir(builder_get_project_dirpath(theProjectId))
+			if mySrcDir == "" {
+				mySrcDir = "."
+			}
+			myNewLines = append(myNewLines, "SrcDir="+mySrcDir)
+			myChanges = append(myChanges, fmt.Sprintf("%s : %q -> %q", "SrcDir", "", mySrcDir))
+		}
+	}
+
+	myTempFile, myTempErr := os.CreateTemp(filepath.Dir(mySettingsFilePath), "."+kProjectSettingsFileName+".*")
+	if myTempErr != nil {
+		return nil, myTempErr
+	}
+	myTempFilePath := myTempFile.Name()
+	defer os.Remove(myTempFilePath)
+
+	_, myWriteErr := myTempFile.WriteString(strings.Join(myNewLines, "\n")+"\n")
+	if myWriteErr == nil {
+		myWriteErr = myTempFile.Chmod(mySettingsFileMode)
+	}
+	if myWriteErr == nil {
+		myWriteErr = myTempFile.Sync()
+	}
+	myCloseErr := myTempFile.Close()
+	if myWriteErr != nil {
+		return nil, myWriteErr
+	}
+	if myCloseErr != nil {
+		return nil, myCloseErr
+	}
+
+	myRenameErr := os.Rename(myTempFilePath, mySettingsFilePath)
+	if myRenameErr != nil {
+		return nil, myRenameErr
+	}
+
+	return myChanges, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Delay between the SIGTERM and the SIGKILL of the processes of a cancelled job.
+const kJobKillDelay = 10 * time.Second
+
+// Cancelled when the running jobs outlive the shutdown timeout.
+var gJobsContext, gJobsCancel = context.WithCancel(context.Background())
+
+var gShuttingDown = false // guarded by gProjectsMutex
+
+//------------------------------------------------------------------------------
+
+// Returns a command cancelled with gJobsContext. It runs in its own process group,
+// so that the cancel also reaches its children (go build, docker-compose...).
+func builder_new_job_command (theName string, theArgs ...string) *exec.Cmd {
+	return builder_new_job_command_context(gJobsContext, theName, theArgs...)
+}
+
+// Same as builder_new_job_command, with a context derived from gJobsContext, ex : the time limit of a build.
+func builder_new_job_command_context (theContext context.Context, theName string, theArgs ...string) *exec.Cmd {
+
+	myCommand := exec.CommandContext(theContext, theName, theArgs...)
+	myCommand.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	myCommand.Cancel = func() error {
+		myProcessGroupId := myCommand.Process.Pid
+		syscall.Kill(-myProcessGroupId, syscall.SIGTERM)
+		time.AfterFunc(kJobKillDelay, func() {
+			syscall.Kill(-myProcessGroupId, syscall.SIGKILL)
+		})
+		return nil
+	}
+	myCommand.WaitDelay = kJobKillDelay
+	return myCommand
+}
+
+func builder_is_shutting_down () bool {
+	gProjectsMutex.Lock()
+	defer gProjectsMutex.Unlock()
+	return gShuttingDown
+}
+
+// Answers 503 to the new jobs once the builder is stopping.
+func builder_check_accepting_jobs (theHTTPResponse http.ResponseWriter) bool {
+	if builder_is_shutting_down() {
+		theHTTPResponse.Header().Set("Retry-After", "60")
+		http.Error(theHTTPResponse, "Builder stopping, no new job accepted", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func builder_get_running_jobs_count () int {
+	gProjectsMutex.Lock()
+	defer gProjectsMutex.Unlock()
+	return gRunningJobs
+}
+
+func builder_wait_running_jobs (theTimeout time.Duration) bool {
+	myDeadline := time.Now().Add(theTimeout)
+	for builder_get_running_jobs_count() > 0 {
+		if time.Now().After(myDeadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return true
+}
+
+// On SIGTERM or SIGINT : refuses the new jobs, lets the running ones end within ShutdownTimeout,
+// cancels them past it, then stops the web server and exits.
+// The pending jobs are cancelled right away, see builder_cancel_pending_jobs.
+// The interrupted jobs stay recorded in the data dir for the next start.
+func builder_handle_shutdown_signals (theServers ...*http.Server) {
+
+	mySignalChannel := make(chan os.Signal, 1)
+	signal.Notify(mySignalChannel, syscall.SIGTERM, syscall.SIGINT)
+	mySignal := <-mySignalChannel
+
+	gProjectsMutex.Lock()
+	gShuttingDown = true
+	myRunningJobs := gRunningJobs
+	gProjectsMutex.Unlock()
+
+	gLogger.Info("builder stopping", "signal", mySignal.String(), "running_jobs", myRunningJobs)
+	builder_cancel_pending_jobs()
+	if !builder_wait_running_jobs(gServerConfig.ShutdownTimeout) {
+		gLogger.Warn("cancelling the running jobs", "running_jobs", builder_get_running_jobs_count())
+		gJobsCancel()
+		builder_wait_running_jobs(kJobKillDelay + 5*time.Second)
+	}
+
+	myShutdownContext, myShutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer myShutdownCancel()
+	for _, myServer := range theServers {
+		myServer.Shutdown(myShutdownContext)
+	}
+
+	gLogger.Info("builder stopped")
+	os.Exit(0)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"path"
+)
+
+// Every page is rendered inside layout.html, the partials are shared by all pages.
+var gPageTemplateFiles = map[string][]string{
+	"projects": {"projects/index.html", "projects/project.html"},
+	"project": {"project/index.html"},
+	"settings": {"project/settings.html"},
+	"deps": {"project/deps.html"},
+	"binary": {"project/binary.html"},
+	"new-project": {"projects/new.html"},
+	"status": {"status/index.html"},
+	"audit": {"status/audit.html"},
+}
+var gPartialTemplateFiles = []string{"layout.html", "partials.html"}
+
+var gPageTemplates map[string]*template.Template
+
+//------------------------------------------------------------------------------
+
+func builder_register_page_templates () {
+
+	gPageTemplates = make(map[string]*template.Template)
+
+	for myPageName, myPageFiles := range gPageTemplateFiles {
+		var myTemplateFilePaths []string
+		for _, myTemplateFile := range append(gPartialTemplateFiles, myPageFiles...) {
+			myTemplateFilePaths = append(myTemplateFilePaths, path.Join("assets", myTemplateFile))
+		}
+		// csrf_token is bound to the request token when the page is rendered
+		gPageTemplates[myPageName] = template.Must(template.New(myPageName).Funcs(template.FuncMap{
+			"csrf_token": func() string { return "" },
+		}).ParseFS(gEmbeddedAssets, myTemplateFilePaths...))
+	}
+}
+
+func builder_render_page (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request, thePageName string, thePageData any) {
+	builder_render_page_status(theHTTPResponse, theHTTPRequest, http.StatusOK, thePageName, thePageData)
+}
+
+// Renders a page with an error status : the headers, and the CSRF cookie of a first page, are set before the status.
+func builder_render_page_status (theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request, theStatus int, thePageName string, thePageData any) {
+
+	myPageTemplate, myPageExists := gPageTemplates[thePageName]
+	if !myPageExists {
+		http.Error(theHTTPResponse, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	myCSRFToken := builder_get_csrf_token(theHTTPResponse, theHTTPRequest)
+	myPageTemplate, myCloneErr := myPageTemplate.Clone()
+	if myCloneErr != nil {
+		http.Error(theHTTPResponse, "Page rendering failed", http.StatusInternalServerError)
+		return
+	}
+	myPageTemplate.Funcs(template.FuncMap{
+		"csrf_token": func() string { return myCSRFToken },
+	})
+
+	theHTTPResponse.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if theStatus != http.StatusOK {
+		theHTTPResponse.WriteHeader(theStatus)
+	}
+	myExecuteErr := myPageTemplate.ExecuteTemplate(theHTTPResponse, "layout", thePageData)
+	if myExecuteErr != nil {
+		http.Error(theHTTPResponse, "Page rendering failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const kTLSDirName = "tls"
+const kCACertFileName = "ca.crt"
+const kCAKeyFileName = "ca.key"
+const kServerCertFileName = "server.crt"
+const kServerKeyFileName = "server.key"
+
+const kCACertValidity = 10 * 365 * 24 * time.Hour
+const kServerCertValidity = 365 * 24 * time.Hour
+const kServerCertRenewBefore = 30 * 24 * time.Hour
+
+// Serves the certificate of the cert/key files, reloaded when one of them changes.
+type CertificateReloader struct {
+    CertFilePath string
+    KeyFilePath string
+    Mutex sync.Mutex
+    Certificate *tls.Certificate
+    CertModTime time.Time
+    KeyModTime time.Time
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_tls_dirpath () string {
+	return filepath.Join(gDataDirPath, kTLSDirName)
+}
+
+func builder_get_file_modtime (theFilePath string) time.Time {
+	myFileInfo, myStatErr := os.Stat(theFilePath)
+	if myStatErr != nil {
+		return time.Time{}
+	}
+	return myFileInfo.ModTime()
+}
+
+func (theReloader *CertificateReloader) builder_get_certificate (theHelloInfo *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	theReloader.Mutex.Lock()
+	defer theReloader.Mutex.Unlock()
+
+	myCertModTime := builder_get_file_modtime(theReloader.CertFilePath)
+	myKeyModTime := builder_get_file_modtime(theReloader.KeyFilePath)
+	if theReloader.Certificate != nil && myCertModTime.Equal(theReloader.CertModTime) && myKeyModTime.Equal(theReloader.KeyModTime) {
+		return theReloader.Certificate, nil
+	}
+
+	myCertificate, myLoadErr := tls.LoadX509KeyPair(theReloader.CertFilePath, theReloader.KeyFilePath)
+	if myLoadErr != nil {
+		// keep serving the previous certificate while the files are being replaced
+		if theReloader.Certificate != nil {
+			return theReloader.Certificate, nil
+		}
+		return nil, myLoadErr
+	}
+	if theReloader.Certificate != nil {
+		gLogger.Info("TLS certificate reloaded", "file", theReloader.CertFilePath)
+	}
+	theReloader.Certificate = &myCertificate
+	theReloader.CertModTime = myCertModTime
+	theReloader.KeyModTime = myKeyModTime
+	return theReloader.Certificate, nil
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_tls_hosts () []string {
+	if len(gServerConfig.TLSHosts) > 0 {
+		return gServerConfig.TLSHosts
+	}
+	myHosts := []string{"localhost", "127.0.0.1", "::1"}
+	myHostname, myHostnameErr := os.Hostname()
+	if myHostnameErr == nil && myHostname != "" {
+		myHosts = append([]string{myHostname}, myHosts...)
+	}
+	return myHosts
+}
+
+func builder_write_pem_file (theFilePath string, theBlockType string, theBytes []byte, theFileMode os.FileMode) error {
+	myPemBytes := pem.EncodeToMemory(&pem.Block{Type: theBlockType, Bytes: theBytes})
+	myTempFilePath := theFilePath+".tmp"
+	myWriteErr := os.WriteFile(myTempFilePath, myPemBytes, theFileMode)
+	if myWriteErr != nil {
+		return myWriteErr
+	}
+	return os.Rename(myTempFilePath, theFilePath)
+}
+
+func builder_new_serial_number () (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Loads the CA of the data dir, generating it on first use.
+func builder_load_or_create_ca () (*x509.Certificate, *ecdsa.PrivateKey, error) {
+
+	myCACertFilePath := filepath.Join(builder_get_tls_dirpath(), kCACertFileName)
+	myCAKeyFilePath := filepath.Join(builder_get_tls_dirpath(), kCAKeyFileName)
+
+	myCAPair, myLoadErr := tls.LoadX509KeyPair(myCACertFilePath, myCAKeyFilePath)
+	if myLoadErr == nil {
+		myCACert, myParseErr := x509.ParseCertificate(myCAPair.Certificate[0])
+		myCAKey, myKeyOk := myCAPair.PrivateKey.(*ecdsa.PrivateKey)
+		if myParseErr == nil && myKeyOk && time.Now().Before(myCACert.NotAfter) {
+			return myCACert, myCAKey, nil
+		}
+	}
+
+	myCAKey, myKeyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if myKeyErr != nil {
+		return nil, nil, myKeyErr
+	}
+	mySerialNumber, mySerialErr := builder_new_serial_number()
+	if mySerialErr != nil {
+		return nil, nil, mySerialErr
+	}
+	myHostname, _ := os.Hostname()
+	myCATemplate := x509.Certificate{SerialNumber: mySerialNumber,
+		Subject: pkix.Name{Organization: []string{"go-builder"}, CommonName: "go-builder CA "+myHostname},
+		NotBefore: time.Now().Add(-1 * time.Hour),
+		NotAfter: time.Now().Add(kCACertValidity),
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA: true,
+		MaxPathLenZero: true,
+	}
+	myCACertBytes, myCreateErr := x509.CreateCertificate(rand.Reader, &myCATemplate, &myCATemplate, &myCAKey.PublicKey, myCAKey)
+	if myCreateErr != nil {
+		return nil, nil, myCreateErr
+	}
+	myCAKeyBytes, myMarshalErr := x509.MarshalECPrivateKey(myCAKey)
+	if myMarshalErr != nil {
+		return nil, nil, myMarshalErr
+	}
+	myWriteErr := builder_write_pem_file(myCAKeyFilePath, "EC PRIVATE KEY", myCAKeyBytes, 0600)
+	if myWriteErr == nil {
+		myWriteErr = builder_write_pem_file(myCACertFilePath, "CERTIFICATE", myCACertBytes, 0644)
+	}
+	if myWriteErr != nil {
+		return nil, nil, myWriteErr
+	}
+	gLogger.Info("TLS CA generated", "file", myCACertFilePath)
+
+	myCACert, myParseErr := x509.ParseCertificate(myCACertBytes)
+	if myParseErr != nil {
+		return nil, nil, myParseErr
+	}
+	return myCACert, myCAKey, nil
+}
+
+func builder_is_server_cert_valid (theCertFilePath string, theCACert *x509.Certificate, theHosts []string) bool {
+
+	myCertBytes, myReadErr := os.ReadFile(theCertFilePath)
+	if myReadErr != nil {
+		return false
+	}
+	myPemBlock, _ := pem.Decode(myCertBytes)
+	if myPemBlock == nil {
+		return false
+	}
+	myCert, myParseErr := x509.ParseCertificate(myPemBlock.Bytes)
+	if myParseErr != nil || time.Now().Add(kServerCertRenewBefore).After(myCert.NotAfter) {
+		return false
+	}
+	if myCert.CheckSignatureFrom(theCACert) != nil {
+		return false
+	}
+
+	var myCertHosts []string
+	myCertHosts = append(myCertHosts, myCert.DNSNames...)
+	for _, myIP := range myCert.IPAddresses {
+		myCertHosts = append(myCertHosts, myIP.String())
+	}
+	myWantedHosts := append([]string{}, theHosts...)
+	for myHostIndex, myHost := range myWantedHosts {
+		myIP := net.ParseIP(myHost)
+		if myIP != nil {
+			myWantedHosts[myHostIndex] = myIP.String()
+		}
+	}
+	sort.Strings(myCertHosts)
+	sort.Strings(myWantedHosts)
+	return strings.Join(myCertHosts, ",") == strings.Join(myWantedHosts, ",")
+}
+
+// Makes sure the data dir holds a CA, and a server certificate signed by it for the TLS hosts.
+// The server certificate is renewed before it expires, or when the hosts change.
+func builder_ensure_auto_certificate () (string, string, error) {
+
+	myMkdirErr := os.MkdirAll(builder_get_tls_dirpath(), 0700)
+	if myMkdirErr != nil {
+		return "", "", myMkdirErr
+	}
+
+	myCACert, myCAKey, myCAErr := builder_load_or_create_ca()
+	if myCAErr != nil {
+		return "", "", fmt.Errorf("CA : %v", myCAErr)
+	}
+
+	myCertFilePath := filepath.Join(builder_get_tls_dirpath(), kServerCertFileName)
+	myKeyFilePath := filepath.Join(builder_get_tls_dirpath(), kServerKeyFileName)
+	myHosts := builder_get_tls_hosts()
+	if builder_is_server_cert_valid(myCertFilePath, myCACert, myHosts) {
+		return myCertFilePath, myKeyFilePath, nil
+	}
+
+	myKey, myKeyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if myKeyErr != nil {
+		return "", "", myKeyErr
+	}
+	mySerialNumber, mySerialErr := builder_new_serial_number()
+	if mySerialErr != nil {
+		return "", "", mySerialErr
+	}
+	myTemplate := x509.Certificate{SerialNumber: mySerialNumber,
+		Subject: pkix.Name{Organization: []string{"go-builder"}, CommonName: myHosts[0]},
+		NotBefore: time.Now().Add(-1 * time.Hour),
+		NotAfter: time.Now().Add(kServerCertValidity),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, myHost := range myHosts {
+		myIP := net.ParseIP(myHost)
+		if myIP != nil {
+			myTemplate.IPAddresses = append(myTemplate.IPAddresses, myIP)
+		} else {
+			myTemplate.DNSNames = append(myTemplate.DNSNames, myHost)
+		}
+	}
+	myCertBytes, myCreateErr := x509.CreateCertificate(rand.Reader, &myTemplate, myCACert, &myKey.PublicKey, myCAKey)
+	if myCreateErr != nil {
+		return "", "", myCreateErr
+	}
+	myKeyBytes, myMarshalErr := x509.MarshalECPrivateKey(myKey)
+	if myMarshalErr != nil {
+		return "", "", myMarshalErr
+	}
+	// the key first, the reloader only picks the pair up once the cert changes
+	myWriteErr := builder_write_pem_file(myKeyFilePath, "EC PRIVATE KEY", myKeyBytes, 0600)
+	if myWriteErr == nil {
+		myWriteErr = builder_write_pem_file(myCertFilePath, "CERTIFICATE", myCertBytes, 0644)
+	}
+	if myWriteErr != nil {
+		return "", "", myWriteErr
+	}
+	gLogger.Info("TLS certificate generated", "hosts", strings.Join(myHosts, ", "), "file", myCertFilePath)
+
+	return myCertFilePath, myKeyFilePath, nil
+}
+
+//------------------------------------------------------------------------------
+
+// Returns the TLS config of the HTTPS server, HTTP/2 is negotiated by net/http.
+func builder_get_tls_config () (*tls.Config, error) {
+
+	myCertificateReloader := &CertificateReloader{CertFilePath: gServerConfig.TLSCertFile, KeyFilePath: gServerConfig.TLSKeyFile}
+
+	if gServerConfig.TLSAutoCert {
+		myCertFilePath, myKeyFilePath, myAutoCertErr := builder_ensure_auto_certificate()
+		if myAutoCertErr != nil {
+			return nil, myAutoCertErr
+		}
+		myCertificateReloader.CertFilePath = myCertFilePath
+		myCertificateReloader.KeyFilePath = myKeyFilePath
+
+		// renews the server certificate while the builder runs
+		go func() {
+			for {
+				time.Sleep(24 * time.Hour)
+				_, _, myRenewErr := builder_ensure_auto_certificate()
+				if myRenewErr != nil {
+					gLogger.Error("TLS certificate renewal failed", "error", myRenewErr)
+				}
+			}
+		}()
+	}
+
+	// fail at startup rather than at the first handshake
+	_, myLoadErr := myCertificateReloader.builder_get_certificate(nil)
+	if myLoadErr != nil {
+		return nil, myLoadErr
+	}
+
+	return &tls.Config{MinVersion: tls.VersionTLS12,
+		GetCertificate: myCertificateReloader.builder_get_certificate,
+	}, nil
+}
+
+// Redirects plain HTTP requests to the same URL over HTTPS.
+func builder_serve_http_redirect () {
+
+	_, myHTTPSPort, _ := net.SplitHostPort(gServerConfig.ListenAddr)
+
+	myRedirectHandler := http.HandlerFunc(func(theHTTPResponse http.ResponseWriter, theHTTPRequest *http.Request) {
+		myHost := theHTTPRequest.Host
+		mySplitHost, _, mySplitErr := net.SplitHostPort(myHost)
+		if mySplitErr == nil {
+			myHost = mySplitHost
+		}
+		if strings.Contains(myHost, ":") {
+			myHost = "["+myHost+"]"
+		}
+		if myHTTPSPort != "" && myHTTPSPort != "443" {
+			myHost += ":"+myHTTPSPort
+		}
+		http.Redirect(theHTTPResponse, theHTTPRequest, "https://"+myHost+theHTTPRequest.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+
+	gLogger.Info("builder redirecting HTTP", "addr", gServerConfig.HTTPRedirectAddr)
+	myServeErr := http.ListenAndServe(gServerConfig.HTTPRedirectAddr, myRedirectHandler)
+	gLogger.Error("HTTP redirect stopped", "error", myServeErr)
+}
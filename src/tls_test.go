@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuilderEnsureAutoCertificate (theTest *testing.T) {
+
+	myDataDirPath := gDataDirPath
+	defer func() {
+		gDataDirPath = myDataDirPath
+		gServerConfig.TLSHosts = nil
+	}()
+	gDataDirPath = theTest.TempDir()
+	gServerConfig.TLSHosts = []string{"builder.local", "10.0.0.1"}
+
+	myCertFilePath, _, myEnsureErr := builder_ensure_auto_certificate()
+	if myEnsureErr != nil {
+		theTest.Fatal(myEnsureErr)
+	}
+	myCACert, _, myCAErr := builder_load_or_create_ca()
+	if myCAErr != nil {
+		theTest.Fatal(myCAErr)
+	}
+
+	myTestCases := []struct {
+		Hosts []string
+		Valid bool
+	}{
+		{Hosts: []string{"builder.local", "10.0.0.1"}, Valid: true},
+		{Hosts: []string{"10.0.0.1", "builder.local"}, Valid: true},
+		{Hosts: []string{"builder.local"}, Valid: false},
+		{Hosts: []string{"builder.local", "10.0.0.1", "localhost"}, Valid: false},
+		{Hosts: []string{"other.local", "10.0.0.1"}, Valid: false},
+	}
+	for _, myTestCase := range myTestCases {
+		if builder_is_server_cert_valid(myCertFilePath, myCACert, myTestCase.Hosts) != myTestCase.Valid {
+			theTest.Errorf("%v : expected valid %v", myTestCase.Hosts, myTestCase.Valid)
+		}
+	}
+
+	// kept while its hosts don't change, renewed when they do
+	myCertBytes, _ := os.ReadFile(myCertFilePath)
+	builder_ensure_auto_certificate()
+	myKeptCertBytes, _ := os.ReadFile(myCertFilePath)
+	if string(myKeptCertBytes) != string(myCertBytes) {
+		theTest.Errorf("certificate renewed for the same hosts")
+	}
+	gServerConfig.TLSHosts = []string{"builder.local"}
+	builder_ensure_auto_certificate()
+	if !builder_is_server_cert_valid(myCertFilePath, myCACert, gServerConfig.TLSHosts) {
+		theTest.Errorf("certificate not renewed for the new hosts")
+	}
+
+	// a certificate of another CA is not valid
+	gDataDirPath = theTest.TempDir()
+	builder_ensure_auto_certificate()
+	myOtherCACert, _, myOtherCAErr := builder_load_or_create_ca()
+	if myOtherCAErr != nil {
+		theTest.Fatal(myOtherCAErr)
+	}
+	if builder_is_server_cert_valid(myCertFilePath, myOtherCACert, gServerConfig.TLSHosts) {
+		theTest.Errorf("certificate valid for another CA")
+	}
+	if builder_is_server_cert_valid(filepath.Join(gDataDirPath, "missing.crt"), myCACert, gServerConfig.TLSHosts) {
+		theTest.Errorf("missing certificate valid")
+	}
+}
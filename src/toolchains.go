@@ -0,0 +1,337 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const kToolchainsDirName = "toolchains"
+const kToolchainArchivesDirName = "toolchain-archives"
+
+var gGoVersionRegexp = regexp.MustCompile(`^go1(\.[0-9]+){1,2}((rc|beta)[0-9]+)?$`)
+
+var gToolchainsMutex sync.Mutex // one install at a time
+
+var gBuilderGoVersion string
+var gBuilderGoVersionOnce sync.Once
+
+//------------------------------------------------------------------------------
+
+// Returns the "go1.x.y" form of a GoVersion setting, empty when invalid.
+func builder_normalize_go_version (theGoVersion string) string {
+	myGoVersion := strings.TrimSpace(theGoVersion)
+	if myGoVersion == "" {
+		return ""
+	}
+	if !strings.HasPrefix(myGoVersion, "go") {
+		myGoVersion = "go"+myGoVersion
+	}
+	if !gGoVersionRegexp.MatchString(myGoVersion) {
+		return ""
+	}
+	return myGoVersion
+}
+
+// Reads the toolchain directive of go.mod, ex : "toolchain go1.22.3".
+func builder_get_go_mod_toolchain (theSrcDirPath string) string {
+
+	myGoModFile, myOpenErr := os.Open(filepath.Join(theSrcDirPath, "go.mod"))
+	if myOpenErr != nil {
+		return ""
+	}
+	defer myGoModFile.Close()
+
+	myScanner := bufio.NewScanner(myGoModFile)
+	for myScanner.Scan() {
+		myFields := strings.Fields(myScanner.Text())
+		if len(myFields) >= 2 && myFields[0] == "toolchain" {
+			return builder_normalize_go_version(myFields[1])
+		}
+	}
+	return ""
+}
+
+// Compares two Go versions, ex : "go1.22rc1" < "go1.22.0" = "go1.22" < "go1.22.3".
+func builder_compare_go_versions (theGoVersionA string, theGoVersionB string) int {
+	myGetSemver := func(theGoVersion string) string {
+		myVersion := strings.TrimPrefix(theGoVersion, "go")
+		for _, myPrereleaseTag := range []string{"beta", "rc"} {
+			myCore, myNumber, myFound := strings.Cut(myVersion, myPrereleaseTag)
+			if myFound {
+				return myCore+"-"+myPrereleaseTag+"."+myNumber
+			}
+		}
+		return myVersion
+	}
+	return builder_compare_semver(myGetSemver(theGoVersionA), myGetSemver(theGoVersionB))
+}
+
+// Version of the go command of the PATH, the Go of the builder, read once.
+func builder_get_builder_go_version () string {
+	gBuilderGoVersionOnce.Do(func() {
+		myGoEnvCommand := exec.Command("go", "env", "GOVERSION")
+		myGoEnvCommand.Env = append(os.Environ(), "GOTOOLCHAIN=local")
+		myOutputBytes, myGoEnvErr := myGoEnvCommand.Output()
+		gBuilderGoVersion = strings.TrimSpace(string(myOutputBytes))
+		if myGoEnvErr != nil || gBuilderGoVersion == "" {
+			gBuilderGoVersion = runtime.Version()
+		}
+	})
+	return gBuilderGoVersion
+}
+
+// Whether the toolchain directive of go.mod is to be used : it is newer than the Go of the builder,
+// and installed or installable. Unlike the GoVersion setting, it is only a preference.
+func builder_use_go_mod_toolchain (theGoVersion string) bool {
+	if theGoVersion == "" || builder_compare_go_versions(builder_get_builder_go_version(), theGoVersion) >= 0 {
+		return false
+	}
+	if builder_is_toolchain_installed(theGoVersion) {
+		return true
+	}
+	_, myStatErr := os.Stat(builder_get_toolchain_archive_filepath(theGoVersion))
+	return myStatErr == nil
+}
+
+// GoVersion setting first, then the go.mod toolchain directive when used, empty for the Go of the builder.
+func builder_get_project_go_version (theProjectId string) string {
+	myGoVersion := builder_get_project(theProjectId).GoVersion
+	if myGoVersion != "" {
+		return myGoVersion
+	}
+	myGoVersion = builder_get_go_mod_toolchain(builder_get_project_srcdirpath(theProjectId))
+	if !builder_use_go_mod_toolchain(myGoVersion) {
+		return ""
+	}
+	return myGoVersion
+}
+
+func builder_get_toolchain_dirpath (theGoVersion string) string {
+	return filepath.Join(gServerConfig.ToolchainsDir, theGoVersion)
+}
+
+func builder_get_toolchain_archive_filepath (theGoVersion string) string {
+	return filepath.Join(gServerConfig.ToolchainArchivesDir, theGoVersion+"."+runtime.GOOS+"-"+runtime.GOARCH+".tar.gz")
+}
+
+func builder_is_toolchain_installed (theGoVersion string) bool {
+	_, myStatErr := os.Stat(filepath.Join(builder_get_toolchain_dirpath(theGoVersion), "bin", "go"))
+	return myStatErr == nil
+}
+
+// Returns the go command of the project toolchain, "go" of the PATH when none is installed.
+func builder_get_project_go_command (theProjectId string) string {
+	myGoVersion := builder_get_project_go_version(theProjectId)
+	if myGoVersion == "" || !builder_is_toolchain_installed(myGoVersion) {
+		return "go"
+	}
+	return filepath.Join(builder_get_toolchain_dirpath(myGoVersion), "bin", "go")
+}
+
+// Environment selecting the toolchain of the project, when installed.
+// GOTOOLCHAIN=local keeps the go command from switching to another version by itself,
+// ex : to download the toolchain of a go.mod directive the builder doesn't use.
+func builder_get_toolchain_env (theProjectId string) []string {
+	myGoVersion := builder_get_project_go_version(theProjectId)
+	if myGoVersion == "" {
+		if builder_get_go_mod_toolchain(builder_get_project_srcdirpath(theProjectId)) != "" {
+			return []string{"GOTOOLCHAIN=local"}
+		}
+		return nil
+	}
+	if !builder_is_toolchain_installed(myGoVersion) {
+		return nil
+	}
+	myToolchainDirPath := builder_get_toolchain_dirpath(myGoVersion)
+	return []string{"PATH="+filepath.Join(myToolchainDirPath, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"GOROOT="+myToolchainDirPath,
+		"GOTOOLCHAIN=local",
+	}
+}
+
+// Extracts the go/ dir of a go<version>.<os>-<arch>.tar.gz archive as the toolchain dir.
+func builder_extract_toolchain_archive (theArchiveFilePath string, theToolchainDirPath string) error {
+
+	myArchiveFile, myOpenErr := os.Open(theArchiveFilePath)
+	if myOpenErr != nil {
+		return myOpenErr
+	}
+	defer myArchiveFile.Close()
+	myGzipReader, myGzipErr := gzip.NewReader(myArchiveFile)
+	if myGzipErr != nil {
+		return myGzipErr
+	}
+	defer myGzipReader.Close()
+
+	myTempDirPath, myTempErr := os.MkdirTemp(filepath.Dir(theToolchainDirPath), ".install-*")
+	if myTempErr != nil {
+		return myTempErr
+	}
+	defer os.RemoveAll(myTempDirPath)
+
+	myTarReader := tar.NewReader(myGzipReader)
+	for {
+		myHeader, myNextErr := myTarReader.Next()
+		if myNextErr == io.EOF {
+			break
+		}
+		if myNextErr != nil {
+			return myNextErr
+		}
+		myRelativePath, myInGoDir := strings.CutPrefix(filepath.Clean(myHeader.Name), "go"+string(filepath.Separator))
+		if !myInGoDir || !filepath.IsLocal(myRelativePath) {
+			continue
+		}
+		myTargetPath := filepath.Join(myTempDirPath, myRelativePath)
+
+		switch myHeader.Typeflag {
+		case tar.TypeDir:
+			myMkdirErr := os.MkdirAll(myTargetPath, 0755)
+			if myMkdirErr != nil {
+				return myMkdirErr
+			}
+		case tar.TypeReg:
+			myMkdirErr := os.MkdirAll(filepath.Dir(myTargetPath), 0755)
+			if myMkdirErr != nil {
+				return myMkdirErr
+			}
+			myTargetFile, myCreateErr := os.OpenFile(myTargetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(myHeader.Mode)&0755)
+			if myCreateErr != nil {
+				return myCreateErr
+			}
+			_, myCopyErr := io.Copy(myTargetFile, myTarReader)
+			myCloseErr := myTargetFile.Close()
+			if myCopyErr != nil {
+				return myCopyErr
+			}
+			if myCloseErr != nil {
+				return myCloseErr
+			}
+		case tar.TypeSymlink:
+			// a link out of the toolchain dir, followed by an entry under it, would write anywhere
+			if filepath.IsAbs(myHeader.Linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(myRelativePath), myHeader.Linkname)) {
+				return fmt.Errorf("symlink %s -> %s out of the toolchain dir", myHeader.Name, myHeader.Linkname)
+			}
+			myMkdirErr := os.MkdirAll(filepath.Dir(myTargetPath), 0755)
+			if myMkdirErr != nil {
+				return myMkdirErr
+			}
+			mySymlinkErr := os.Symlink(myHeader.Linkname, myTargetPath)
+			if mySymlinkErr != nil {
+				return mySymlinkErr
+			}
+		}
+	}
+
+	_, myStatErr := os.Stat(filepath.Join(myTempDirPath, "bin", "go"))
+	if myStatErr != nil {
+		return fmt.Errorf("no go/bin/go in %s", theArchiveFilePath)
+	}
+	myChmodErr := os.Chmod(myTempDirPath, 0755)
+	if myChmodErr != nil {
+		return myChmodErr
+	}
+	return os.Rename(myTempDirPath, theToolchainDirPath)
+}
+
+// Installs the toolchain of the project from the archives dir, when not installed yet.
+func builder_install_project_toolchain (theProjectId string) ([]string, error) {
+
+	var myReturnLines []string
+
+	myGoVersion := builder_get_project_go_version(theProjectId)
+	if myGoVersion == "" {
+		myGoModToolchain := builder_get_go_mod_toolchain(builder_get_project_srcdirpath(theProjectId))
+		if myGoModToolchain != "" && builder_compare_go_versions(builder_get_builder_go_version(), myGoModToolchain) < 0 {
+			gLogger.Warn("go.mod toolchain not available", "project", theProjectId, "toolchain", myGoModToolchain, "go", builder_get_builder_go_version())
+			myReturnLines = append(myReturnLines, "Warning : toolchain "+myGoModToolchain+" of go.mod not installed and no archive, building with "+builder_get_builder_go_version())
+		}
+		return myReturnLines, nil
+	}
+
+	gToolchainsMutex.Lock()
+	defer gToolchainsMutex.Unlock()
+
+	if builder_is_toolchain_installed(myGoVersion) {
+		return myReturnLines, nil
+	}
+
+	myArchiveFilePath := builder_get_toolchain_archive_filepath(myGoVersion)
+	_, myStatErr := os.Stat(myArchiveFilePath)
+	if myStatErr != nil {
+		return myReturnLines, fmt.Errorf("toolchain %s not installed, and no %s archive", myGoVersion, myArchiveFilePath)
+	}
+
+	myMkdirErr := os.MkdirAll(gServerConfig.ToolchainsDir, 0755)
+	if myMkdirErr != nil {
+		return myReturnLines, myMkdirErr
+	}
+	myExtractErr := builder_extract_toolchain_archive(myArchiveFilePath, builder_get_toolchain_dirpath(myGoVersion))
+	if myExtractErr != nil {
+		return myReturnLines, fmt.Errorf("toolchain %s install failed : %v", myGoVersion, myExtractErr)
+	}
+	myReturnLines = append(myReturnLines, "Toolchain installed : "+myGoVersion+" from "+myArchiveFilePath)
+
+	return myReturnLines, nil
+}
+
+// Runs "go env GOVERSION" in the build environment of the project.
+func builder_get_project_go_version_used (theProjectId string) string {
+	myGoEnvCommand := builder_new_job_command(builder_get_project_go_command(theProjectId), "env", "GOVERSION")
+	myGoEnvCommand.Dir = builder_get_project_srcdirpath(theProjectId)
+	myGoEnvCommand.Env = append(os.Environ(), builder_get_go_env(theProjectId)...)
+	myOutputBytes, myGoEnvErr := myGoEnvCommand.Output()
+	if myGoEnvErr != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(myOutputBytes))
+}
+
+//------------------------------------------------------------------------------
+
+// Lists the installed toolchains and the archives ready to be installed.
+func builder_get_toolchains_info () []map[string]interface{} {
+
+	myToolchains := make(map[string]map[string]interface{})
+	myDirEntries, _ := os.ReadDir(gServerConfig.ToolchainsDir)
+	for _, myDirEntry := range myDirEntries {
+		if myDirEntry.IsDir() && gGoVersionRegexp.MatchString(myDirEntry.Name()) {
+			myToolchains[myDirEntry.Name()] = map[string]interface{}{"GoVersion": myDirEntry.Name(), "Installed": true, "Archive": false}
+		}
+	}
+	myArchiveSuffix := "."+runtime.GOOS+"-"+runtime.GOARCH+".tar.gz"
+	myArchiveEntries, _ := os.ReadDir(gServerConfig.ToolchainArchivesDir)
+	for _, myArchiveEntry := range myArchiveEntries {
+		myGoVersion, myIsArchive := strings.CutSuffix(myArchiveEntry.Name(), myArchiveSuffix)
+		if !myIsArchive || !gGoVersionRegexp.MatchString(myGoVersion) {
+			continue
+		}
+		if myToolchains[myGoVersion] == nil {
+			myToolchains[myGoVersion] = map[string]interface{}{"GoVersion": myGoVersion, "Installed": false}
+		}
+		myToolchains[myGoVersion]["Archive"] = true
+	}
+
+	var myGoVersions []string
+	for myGoVersion := range myToolchains {
+		myGoVersions = append(myGoVersions, myGoVersion)
+	}
+	sort.Strings(myGoVersions)
+
+	myToolchainsInfo := []map[string]interface{}{}
+	for _, myGoVersion := range myGoVersions {
+		myToolchainsInfo = append(myToolchainsInfo, myToolchains[myGoVersion])
+	}
+	return myToolchainsInfo
+}
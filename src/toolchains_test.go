@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuilderNormalizeGoVersion (theTest *testing.T) {
+
+	myTestCases := []struct {
+		GoVersion string
+		Normalized string
+	}{
+		{GoVersion: "1.22.3", Normalized: "go1.22.3"},
+		{GoVersion: "go1.22.3", Normalized: "go1.22.3"},
+		{GoVersion: " 1.21 ", Normalized: "go1.21"},
+		{GoVersion: "1.23rc1", Normalized: "go1.23rc1"},
+		{GoVersion: "go1.22beta2", Normalized: "go1.22beta2"},
+		{GoVersion: "", Normalized: ""},
+		{GoVersion: "1", Normalized: ""},
+		{GoVersion: "2.0.0", Normalized: ""},
+		{GoVersion: "1.22.3.4", Normalized: ""},
+		{GoVersion: "1.22-rc1", Normalized: ""},
+		{GoVersion: "go1.22.3 ; rm -rf /", Normalized: ""},
+		{GoVersion: "../go1.22", Normalized: ""},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myNormalized := builder_normalize_go_version(myTestCase.GoVersion)
+		if myNormalized != myTestCase.Normalized {
+			theTest.Errorf("%q : %q, expected %q", myTestCase.GoVersion, myNormalized, myTestCase.Normalized)
+		}
+	}
+}
+
+func TestBuilderCompareGoVersions (theTest *testing.T) {
+
+	myTestCases := []struct {
+		GoVersionA string
+		GoVersionB string
+		Result int
+	}{
+		{GoVersionA: "go1.22.3", GoVersionB: "go1.22.3", Result: 0},
+		{GoVersionA: "go1.22", GoVersionB: "go1.22.0", Result: 0},
+		{GoVersionA: "go1.22.3", GoVersionB: "go1.22.10", Result: -1},
+		{GoVersionA: "go1.23.0", GoVersionB: "go1.22.10", Result: 1},
+		{GoVersionA: "go1.22rc1", GoVersionB: "go1.22.0", Result: -1},
+		{GoVersionA: "go1.22beta1", GoVersionB: "go1.22rc1", Result: -1},
+		{GoVersionA: "go1.22rc2", GoVersionB: "go1.22rc1", Result: 1},
+		{GoVersionA: "go1.22rc1", GoVersionB: "go1.21.9", Result: 1},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myResult := builder_compare_go_versions(myTestCase.GoVersionA, myTestCase.GoVersionB)
+		if (myResult < 0) != (myTestCase.Result < 0) || (myResult > 0) != (myTestCase.Result > 0) {
+			theTest.Errorf("%s vs %s : %d, expected %d", myTestCase.GoVersionA, myTestCase.GoVersionB, myResult, myTestCase.Result)
+		}
+	}
+}
+
+func TestBuilderExtractToolchainArchiveSymlinks (theTest *testing.T) {
+
+	myTestCases := []struct {
+		Linkname string
+		Valid bool
+	}{
+		{Linkname: "go", Valid: true},
+		{Linkname: "../pkg/tool", Valid: true},
+		{Linkname: "/etc", Valid: false},
+		{Linkname: "../../..", Valid: false},
+		{Linkname: "../../outside", Valid: false},
+	}
+
+	for _, myTestCase := range myTestCases {
+		myDirPath := theTest.TempDir()
+		myArchiveFilePath := filepath.Join(myDirPath, "go.tar.gz")
+		myArchiveFile, myCreateErr := os.Create(myArchiveFilePath)
+		if myCreateErr != nil {
+			theTest.Fatal(myCreateErr)
+		}
+		myGzipWriter := gzip.NewWriter(myArchiveFile)
+		myTarWriter := tar.NewWriter(myGzipWriter)
+		myTarWriter.WriteHeader(&tar.Header{Name: "go/bin/go", Typeflag: tar.TypeReg, Mode: 0755})
+		myTarWriter.WriteHeader(&tar.Header{Name: "go/bin/link", Typeflag: tar.TypeSymlink, Linkname: myTestCase.Linkname})
+		myTarWriter.Close()
+		myGzipWriter.Close()
+		myArchiveFile.Close()
+
+		myExtractErr := builder_extract_toolchain_archive(myArchiveFilePath, filepath.Join(myDirPath, "go1.22.3"))
+		if (myExtractErr == nil) != myTestCase.Valid {
+			theTest.Errorf("%q : error %v, expected valid %v", myTestCase.Linkname, myExtractErr, myTestCase.Valid)
+		}
+	}
+}
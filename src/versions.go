@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const kVersionTagPrefix = "build-"
+const kDefaultKeepImages = 5
+
+type ImageVersion struct {
+    BuildNumber int
+    Tag string // "build-<number>"
+    ImageId string
+    CreatedSince string
+    Size string
+    Deployed bool // same image as the deployed tag, see builder_get_deployed_image_tag
+    Record *BuildRecord // nil when the build history is lost
+}
+
+//------------------------------------------------------------------------------
+
+func builder_get_version_tag (theBuildNumber int) string {
+	return kVersionTagPrefix+strconv.Itoa(theBuildNumber)
+}
+
+func builder_get_image_id (theImageRef string) string {
+	myOutputBytes, myInspectErr := builder_run_probe_command("", "docker", "image", "inspect", "--format", "{{.Id}}", theImageRef)
+	if myInspectErr != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(myOutputBytes))
+}
+
+// Returns the tag of the deployed image, the one the compose file refers to : the first tag of ImageTags
+// without placeholder, else the first tag of the last image build, as expanded by builder_expand_image_tags.
+func builder_get_deployed_image_tag (theProject *Project) string {
+	for _, myTemplate := range theProject.ImageTags {
+		if !strings.Contains(myTemplate, "{") {
+			return myTemplate
+		}
+	}
+	if theProject.LastBuild != nil && len(theProject.LastBuild.ImageTags) > 0 {
+		return theProject.LastBuild.ImageTags[0]
+	}
+	return kDefaultImageTags
+}
+
+// Lists the build-<number> tags of the project image, most recent first.
+func builder_list_project_image_versions (theProjectId string) []ImageVersion {
+
+	var myVersions []ImageVersion
+
+	if !builder_is_docker_connected() {
+		return myVersions
+	}
+
+	myProject := builder_get_project(theProjectId)
+	if myProject == nil {
+		return myVersions
+	}
+	myImageName := myProject.ImageName
+	myOutputBytes, myImagesErr := builder_run_command("", "docker", "images", myImageName, "--format", "{{json .}}")
+	if myImagesErr != nil {
+		return myVersions
+	}
+
+	myRecords := builder_load_build_history(theProjectId)
+	myDeployedImageId := builder_get_image_id(myImageName+":"+builder_get_deployed_image_tag(myProject))
+
+	for _, myOutputLine := range strings.Split(string(myOutputBytes), "\n") {
+		var myDockerImage struct {
+			Id string `json:"ID"`
+			Tag string `json:"Tag"`
+			CreatedSince string `json:"CreatedSince"`
+			Size string `json:"Size"`
+		}
+		myUnmarshalErr := json.Unmarshal([]byte(myOutputLine), &myDockerImage)
+		if myUnmarshalErr != nil || !strings.HasPrefix(myDockerImage.Tag, kVersionTagPrefix) {
+			continue
+		}
+		myBuildNumber, myAtoiErr := strconv.Atoi(strings.TrimPrefix(myDockerImage.Tag, kVersionTagPrefix))
+		if myAtoiErr != nil {
+			continue
+		}
+		myVersion := ImageVersion{BuildNumber: myBuildNumber,
+			Tag: myDockerImage.Tag,
+			ImageId: myDockerImage.Id,
+			CreatedSince: myDockerImage.CreatedSince,
+			Size: myDockerImage.Size,
+			Deployed: myDeployedImageId != "" && strings.Contains(myDeployedImageId, myDockerImage.Id),
+		}
+		for myRecordIndex := range myRecords {
+			if myRecords[myRecordIndex].Number == myBuildNumber {
+				myVersion.Record = &myRecords[myRecordIndex]
+			}
+		}
+		myVersions = append(myVersions, myVersion)
+	}
+
+	sort.Slice(myVersions, func(i, j int) bool {
+		return myVersions[i].BuildNumber > myVersions[j].BuildNumber
+	})
+
+	return myVersions
+}
+
+// Tags the freshly built image with its build number, and untags the versions beyond KeepImages.
+func builder_register_project_image_version (theProjectId string, theRecord *BuildRecord) []string {
+
+	var myReturnLines []string
+
+	if len(theRecord.ImageTags) == 0 {
+		return myReturnLines
+	}
+
+	myImageName := builder_get_project(theProjectId).ImageName
+	myVersionTag := builder_get_version_tag(theRecord.Number)
+	myTagOutputBytes, myTagErr := builder_run_command("", "docker", "tag", myImageName+":"+theRecord.ImageTags[0], myImageName+":"+myVersionTag)
+	if myTagErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker tag %s failed : %v", myVersionTag, myTagErr))
+		myReturnLines = builder_append_output_lines(myReturnLines, myTagOutputBytes)
+		return myReturnLines
+	}
+	theRecord.VersionTag = myVersionTag
+	myReturnLines = append(myReturnLines, "Image version : "+myImageName+":"+myVersionTag)
+
+	myVersions := builder_list_project_image_versions(theProjectId)
+	myKeepImages := builder_get_project(theProjectId).KeepImages
+	for myVersionIndex, myVersion := range myVersions {
+		if myVersionIndex < myKeepImages || myVersion.Deployed {
+			continue
+		}
+		myRemoveOutputBytes, myRemoveErr := builder_run_command("", "docker", "rmi", myImageName+":"+myVersion.Tag)
+		if myRemoveErr != nil {
+			myReturnLines = append(myReturnLines, fmt.Sprintf("Image version %s removal failed : %v", myVersion.Tag, myRemoveErr))
+			myReturnLines = builder_append_output_lines(myReturnLines, myRemoveOutputBytes)
+		} else {
+			myReturnLines = append(myReturnLines, "Image version removed : "+myImageName+":"+myVersion.Tag)
+		}
+	}
+
+	return myReturnLines
+}
+
+//------------------------------------------------------------------------------
+
+// Retags a kept version as the deployed tag, then recreates the compose stack, as an up job does.
+// Returns the output lines, and the error of the tag or of docker-compose.
+func builder_deploy_project_version (theProject *Project, theBuildNumber int) ([]string, error) {
+
+	var myReturnLines []string
+
+	myImageName := theProject.ImageName
+	myVersionRef := myImageName+":"+builder_get_version_tag(theBuildNumber)
+	myDeployedRef := myImageName+":"+builder_get_deployed_image_tag(theProject)
+
+	myReturnLines = append(myReturnLines, "Deploying version : "+myVersionRef)
+
+	myTagOutputBytes, myTagErr := builder_run_command("", "docker", "tag", myVersionRef, myDeployedRef)
+	if myTagErr != nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Docker tag %s failed : %v", myDeployedRef, myTagErr))
+		myReturnLines = builder_append_output_lines(myReturnLines, myTagOutputBytes)
+		return myReturnLines, myTagErr
+	}
+	myReturnLines = append(myReturnLines, "Docker tag OK : "+myVersionRef+" -> "+myDeployedRef)
+
+	myDCOutputLines, myDCErr := builder_docker_compose_up(theProject, true)
+	myReturnLines = append(myReturnLines, myDCOutputLines...)
+	if myDCErr == nil {
+		myReturnLines = append(myReturnLines, fmt.Sprintf("Version #%d deployed for %s", theBuildNumber, theProject.Id))
+	}
+
+	return myReturnLines, myDCErr
+}
+
+func builder_get_project_versions_info (theProjectId string, theDeployEnabled bool) []map[string]interface{} {
+
+	myVersionsInfo := []map[string]interface{}{}
+
+	for _, myVersion := range builder_list_project_image_versions(theProjectId) {
+		myDate := myVersion.CreatedSince
+		myCommit := ""
+		myTags := ""
+		if myVersion.Record != nil {
+			myDate = myVersion.Record.FinishedAt.Format("2006-01-02 15:04")
+			myCommit = myVersion.Record.Commit
+			if myVersion.Record.GitTag != "" {
+				myCommit += " ("+myVersion.Record.GitTag+")"
+			}
+			myTags = strings.Join(myVersion.Record.ImageTags, ", ")
+		}
+		myVersionsInfo = append(myVersionsInfo, map[string]interface{}{
+			"BuildNumber": myVersion.BuildNumber,
+			"Date": myDate,
+			"Commit": myCommit,
+			"Tags": myTags,
+			"Size": myVersion.Size,
+			"Deployed": myVersion.Deployed,
+			"Deployable": theDeployEnabled && !myVersion.Deployed,
+		})
+	}
+
+	return myVersionsInfo
+}